@@ -0,0 +1,157 @@
+package pglogrus
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GroupBy are the groups of entries counted by Hook.Aggregate.
+type GroupBy struct {
+	// Level groups the entries by level.
+	Level bool
+	// TimeBucket groups the entries by periods of time (eg. 5 minutes),
+	// aligned on the Unix epoch.
+	TimeBucket time.Duration
+	// Fields group the entries by the text value of fields, stored in
+	// columns or in message_data. Dots separate the keys of nested objects
+	// (eg. "user.id").
+	Fields []string
+}
+
+// AggregateOptions configure Hook.Aggregate.
+type AggregateOptions struct {
+	// From and To restrict the aggregation to entries created in [From,
+	// To), if set.
+	From, To time.Time
+	// Percentiles (eg. 0.5 and 0.99) of the numeric field Value (eg.
+	// "duration_ms") are computed for each group. Values of message_data
+	// which aren't numbers are skipped.
+	Value       string
+	Percentiles []float64
+	// Where restricts the aggregation to the entries matching all filters.
+	Where []Filter
+}
+
+// An Aggregation is a group of entries returned by Hook.Aggregate.
+type Aggregation struct {
+	// Level is set when grouping by level.
+	Level logrus.Level
+	// Time is the start of the period, when grouping by TimeBucket.
+	Time time.Time
+	// Values are the values of the GroupBy Fields, "" for missing fields.
+	Values []string
+	// Count is the number of entries of the group.
+	Count int64
+	// Percentiles are the percentiles of AggregateOptions.Value, in the
+	// same order. They're NaN if no entry of the group has the field.
+	Percentiles []float64
+}
+
+// Aggregate counts the entries stored in the table, by groups, ordered by
+// group, so dashboards can be built without writing SQL (PostgreSQL only):
+//
+//	aggregations, err := hook.Aggregate(ctx, pglogrus.GroupBy{Level: true, TimeBucket: 5 * time.Minute}, pglogrus.AggregateOptions{
+//		From:        time.Now().Add(-time.Hour),
+//		Value:       "duration_ms",
+//		Percentiles: []float64{0.5, 0.99},
+//	})
+func (hook *Hook) Aggregate(ctx context.Context, groupBy GroupBy, opts AggregateOptions) ([]Aggregation, error) {
+	table := hook.table()
+	if table.Dialect != Postgres {
+		return nil, fmt.Errorf("pglogrus: aggregations need PostgreSQL")
+	}
+	query, args := table.aggregateQuery(groupBy, opts)
+	rows, err := hook.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var aggregations []Aggregation
+	for rows.Next() {
+		var (
+			a      Aggregation
+			dest   []interface{}
+			values = make([]sql.NullString, len(groupBy.Fields))
+			pcts   = make([]sql.NullFloat64, len(opts.Percentiles))
+		)
+		if groupBy.Level {
+			dest = append(dest, &a.Level)
+		}
+		if groupBy.TimeBucket > 0 {
+			dest = append(dest, &a.Time)
+		}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &a.Count)
+		for i := range pcts {
+			dest = append(dest, &pcts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if len(values) > 0 {
+			a.Values = make([]string, len(values))
+			for i, v := range values {
+				a.Values[i] = v.String
+			}
+		}
+		if len(pcts) > 0 {
+			a.Percentiles = make([]float64, len(pcts))
+			for i, p := range pcts {
+				a.Percentiles[i] = math.NaN()
+				if p.Valid {
+					a.Percentiles[i] = p.Float64
+				}
+			}
+		}
+		aggregations = append(aggregations, a)
+	}
+	return aggregations, rows.Err()
+}
+
+// aggregateQuery returns the query of Hook.Aggregate, and its arguments.
+func (t *TableConfig) aggregateQuery(groupBy GroupBy, opts AggregateOptions) (string, []interface{}) {
+	var groups []string
+	if groupBy.Level {
+		groups = append(groups, "level")
+	}
+	if groupBy.TimeBucket > 0 {
+		seconds := strconv.FormatFloat(groupBy.TimeBucket.Seconds(), 'f', -1, 64)
+		groups = append(groups, fmt.Sprintf("to_timestamp(floor(extract(epoch FROM created_at) / %s) * %[1]s)", seconds))
+	}
+	for _, field := range groupBy.Fields {
+		groups = append(groups, t.fieldExpression(field))
+	}
+
+	columns := append(groups[:len(groups):len(groups)], "count(*)")
+	value, column := FieldPath(opts.Value).expression(t)
+	if column {
+		value = fmt.Sprintf("(%s)::double precision", value)
+	} else {
+		// Values of message_data which aren't numbers are skipped
+		value = numericExpression(value)
+	}
+	for _, p := range opts.Percentiles {
+		columns = append(columns, fmt.Sprintf("percentile_cont(%s) WITHIN GROUP (ORDER BY %s)", strconv.FormatFloat(p, 'f', -1, 64), value))
+	}
+	c := conditions{dialect: t.Dialect}
+	c.timeRange(opts.From, opts.To)
+	c.filter(t, opts.Where)
+	query := fmt.Sprintf("SELECT %s FROM %s %s", strings.Join(columns, ", "), t.source(), c.where())
+	if len(groups) > 0 {
+		positions := make([]string, len(groups))
+		for i := range groups {
+			positions[i] = strconv.Itoa(i + 1)
+		}
+		query = fmt.Sprintf("%s GROUP BY %s ORDER BY %[2]s", strings.TrimSpace(query), strings.Join(positions, ", "))
+	}
+	return strings.TrimSpace(query) + ";", c.args
+}
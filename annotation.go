@@ -0,0 +1,71 @@
+package pglogrus
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AnnotationField flags the entries marking an event, like a deploy or an
+// incident, which are also stored in the AnnotationTable of the hook. Its
+// value are the tags of the annotation: a string (eg. "deploy"), a []string,
+// or true for no tags.
+const AnnotationField = "annotation"
+
+// AnnotationEndField is the end time (time.Time) of annotations marking a
+// period, like an incident.
+const AnnotationEndField = "annotation_end"
+
+// annotationTableSchema returns the SQL statement creating the table storing
+// annotations. The columns are the ones expected by the annotation queries of
+// the PostgreSQL data source of Grafana:
+//
+//	SELECT time, time_end AS "timeEnd", text, tags FROM annotations WHERE $__timeFilter(time);
+func annotationTableSchema(name string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id SERIAL,
+    time timestamp with time zone NOT NULL,
+    time_end timestamp with time zone,
+    text text NOT NULL,
+    tags text[] NOT NULL DEFAULT '{}'
+);`, name)
+}
+
+// annotationStatement returns the statement inserting the annotation of entry
+// in the AnnotationTable, or false if entry isn't an annotation.
+func (hook *Hook) annotationStatement(entry *logrus.Entry) (string, []interface{}, bool) {
+	if hook.AnnotationTable == "" {
+		return "", nil, false
+	}
+	var tags []string
+	switch v := entry.Data[AnnotationField].(type) {
+	case string:
+		tags = []string{v}
+	case []string:
+		tags = v
+	case bool:
+		if !v {
+			return "", nil, false
+		}
+	default:
+		return "", nil, false
+	}
+	var end interface{}
+	if t, ok := entry.Data[AnnotationEndField].(time.Time); ok {
+		end = t
+	}
+	query := fmt.Sprintf("INSERT INTO %s(time, time_end, text, tags) VALUES ($1,$2,$3,$4);", hook.AnnotationTable)
+	return query, []interface{}{entry.Time, end, entry.Message, textArray(tags)}, true
+}
+
+// textArray returns the literal of a PostgreSQL text[] value.
+func textArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		v = strings.Replace(v, `\`, `\\`, -1)
+		quoted[i] = `"` + strings.Replace(v, `"`, `\"`, -1) + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
@@ -0,0 +1,202 @@
+package pglogrus
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+)
+
+// An ObjectStore stores archives, for example in a S3-compatible bucket.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+}
+
+// ArchiveOptions configure Hook.Archive.
+type ArchiveOptions struct {
+	// Before is the time before which entries are archived.
+	Before time.Time
+	// Store is where the archive is uploaded.
+	Store ObjectStore
+	// Key of the archive in the store, "<table>/<before>.ndjson.gz" by
+	// default.
+	Key string
+}
+
+// Archive exports the entries created before opts.Before to a gzipped NDJSON
+// file in opts.Store, and then deletes them from the table.
+// Entries are only deleted if the upload succeeded.
+// It returns the number of archived entries.
+func (hook *Hook) Archive(ctx context.Context, opts ArchiveOptions) (int, error) {
+	key := opts.Key
+	if key == "" {
+		key = fmt.Sprintf("%s/%s.ndjson.gz", hook.Table.Name, opts.Before.UTC().Format("20060102T150405Z"))
+	}
+
+	// The snapshot of a repeatable read transaction guarantees that only the
+	// exported entries are deleted.
+	txn, err := hook.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return 0, err
+	}
+	defer txn.Rollback()
+
+	table := hook.table()
+	rows, err := txn.QueryContext(ctx, table.SelectQuery("WHERE created_at < $1 ORDER BY created_at"), opts.Before)
+	if err != nil {
+		return 0, err
+	}
+
+	pr, pw := io.Pipe()
+	var count int
+	// exportErr is checked after the upload, as stores may not return the
+	// errors of the reader
+	var exportErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer rows.Close()
+		exportErr = func() error {
+			gz := gzip.NewWriter(pw)
+			for rows.Next() {
+				entry, err := table.scanEntry(rows)
+				if err != nil {
+					return err
+				}
+				if err := WriteNDJSON(gz, entry); err != nil {
+					return err
+				}
+				count++
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			return gz.Close()
+		}()
+		pw.CloseWithError(exportErr)
+	}()
+
+	err = opts.Store.Put(ctx, key, pr)
+	pr.CloseWithError(err) // unblock the export if the upload stopped early
+	<-done
+	if err == nil {
+		err = exportErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	for _, name := range hook.Table.deletedTables() {
+		if _, err := txn.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE created_at < $1;", name), opts.Before); err != nil {
+			return 0, err
+		}
+	}
+	return count, txn.Commit()
+}
+
+// ArchiveEvery archives the entries older than maxAge to store every
+// interval, until ctx is done.
+// Errors are reported to the ErrorHandler.
+func (hook *Hook) ArchiveEvery(ctx context.Context, interval, maxAge time.Duration, store ObjectStore) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := hook.Archive(ctx, ArchiveOptions{Before: time.Now().Add(-maxAge), Store: store})
+			if err != nil {
+				hook.handleError(&ErrorEvent{Time: time.Now(), Op: "archive", Err: err})
+			}
+		}
+	}
+}
+
+// Prune deletes the entries created before before, and matching all filters,
+// without archiving them. It returns the number of deleted entries.
+func (hook *Hook) Prune(ctx context.Context, before time.Time, where ...Filter) (int64, error) {
+	if len(where) > 0 && hook.Table.Dialect != Postgres {
+		return 0, fmt.Errorf("pglogrus: filters need PostgreSQL")
+	}
+	var deleted int64
+	for _, table := range hook.Table.shardTables() {
+		n, err := hook.prune(ctx, table, before, where)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	if len(where) == 0 && hook.Table.offloadsPayloads() {
+		if _, err := hook.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE created_at < $1;", hook.Table.PayloadTable), before); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// prune deletes the entries of table (or of a shard) created before before,
+// and matching where, with their offloaded payloads.
+func (hook *Hook) prune(ctx context.Context, table *TableConfig, before time.Time, where []Filter) (int64, error) {
+	c := conditions{dialect: table.Dialect}
+	c.add("created_at < " + c.arg(before))
+	if len(where) == 0 {
+		res, err := hook.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s %s;", table.Name, c.where()), c.args...)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+
+	// Filters match the offloaded payloads too
+	matching := conditions{dialect: table.Dialect, args: c.args}
+	matching.filter(table, where)
+	c.args = matching.args
+	c.add(fmt.Sprintf("id IN (SELECT id FROM %s %s)", table.source(), matching.where()))
+	query := fmt.Sprintf("DELETE FROM %s %s", table.Name, c.where())
+	if !table.offloadsPayloads() {
+		res, err := hook.db.ExecContext(ctx, query+";", c.args...)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+	var deleted int64
+	err := hook.db.QueryRowContext(ctx, fmt.Sprintf("WITH deleted AS (%s RETURNING %s), payloads AS (DELETE FROM %s WHERE id IN (SELECT %[2]s FROM deleted)) SELECT count(*) FROM deleted;", query, PayloadIDColumn, table.PayloadTable), c.args...).Scan(&deleted)
+	return deleted, err
+}
+
+// EnforceRetention deletes the entries older than the Retention of the hook
+// every interval, until ctx is done. It returns immediately if Retention is
+// 0.
+// Errors are reported to the ErrorHandler.
+func (hook *Hook) EnforceRetention(ctx context.Context, interval time.Duration) {
+	if hook.Retention <= 0 {
+		return
+	}
+	hook.PruneEvery(ctx, interval, hook.Retention)
+}
+
+// PruneEvery deletes the entries older than maxAge, and matching all filters,
+// every interval, until ctx is done, to enforce a retention period (see
+// EnforceRetention).
+// Errors are reported to the ErrorHandler.
+func (hook *Hook) PruneEvery(ctx context.Context, interval, maxAge time.Duration, where ...Filter) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := hook.Prune(ctx, time.Now().Add(-maxAge), where...); err != nil {
+				hook.handleError(&ErrorEvent{Time: time.Now(), Op: "prune", Err: err})
+			}
+		}
+	}
+}
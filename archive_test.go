@@ -0,0 +1,263 @@
+package pglogrus
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingDB is a database/sql driver recording the statements executed,
+// and answering queries with the rows returned by its rows func. Statements
+// fail with the error returned by fail, if set.
+type recordingDB struct {
+	mu         sync.Mutex
+	statements []string
+	rows       func(query string) [][]driver.Value
+	fail       func(query string, args []driver.NamedValue) error
+}
+
+// openRecordingDB returns a DB recording its statements in the returned
+// recordingDB
+func openRecordingDB(rows func(query string) [][]driver.Value) (*sql.DB, *recordingDB) {
+	r := &recordingDB{rows: rows}
+	return sql.OpenDB(r), r
+}
+
+func (r *recordingDB) record(statement string) {
+	r.mu.Lock()
+	r.statements = append(r.statements, statement)
+	r.mu.Unlock()
+}
+
+func (r *recordingDB) Connect(ctx context.Context) (driver.Conn, error) { return recordingConn{r}, nil }
+func (r *recordingDB) Driver() driver.Driver                            { return nil }
+
+type recordingConn struct {
+	db *recordingDB
+}
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c recordingConn) Close() error { return nil }
+func (c recordingConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c recordingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.db.record("BEGIN")
+	return c, nil
+}
+
+func (c recordingConn) Commit() error {
+	c.db.record("COMMIT")
+	return nil
+}
+
+func (c recordingConn) Rollback() error {
+	c.db.record("ROLLBACK")
+	return nil
+}
+
+func (c recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query)
+	if c.db.fail != nil {
+		if err := c.db.fail(query, args); err != nil {
+			return nil, err
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c recordingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.record(query)
+	var rows [][]driver.Value
+	if c.db.rows != nil {
+		rows = c.db.rows(query)
+	}
+	var columns []string
+	if len(rows) > 0 {
+		columns = make([]string, len(rows[0]))
+	}
+	return &recordingRows{rows: rows, columns: columns}, nil
+}
+
+type recordingRows struct {
+	rows    [][]driver.Value
+	columns []string
+}
+
+func (r *recordingRows) Columns() []string {
+	return r.columns
+}
+
+func (r *recordingRows) Close() error { return nil }
+
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// memoryStore stores the archives in memory. If ignoreReadErrors is true, it
+// stores what was read before an error, like a store treating it as EOF.
+type memoryStore struct {
+	objects          map[string][]byte
+	err              error
+	ignoreReadErrors bool
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, r io.Reader) error {
+	if s.err != nil {
+		return s.err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil && !s.ignoreReadErrors {
+		return err
+	}
+	s.objects[key] = b
+	return nil
+}
+
+func TestArchive(t *testing.T) {
+	before := time.Date(2019, 3, 18, 10, 0, 0, 0, time.UTC)
+	db, recorded := openRecordingDB(func(query string) [][]driver.Value {
+		return [][]driver.Value{
+			{int64(1), int64(4), "first", []byte(`{"a":1}`), before.Add(-time.Hour)},
+			{int64(2), int64(2), "second", []byte(`{}`), before.Add(-time.Minute)},
+		}
+	})
+	hook := NewHook(db, map[string]interface{}{})
+	hook.Table.PayloadTable = "log_payloads"
+	store := &memoryStore{objects: map[string][]byte{}}
+
+	n, err := hook.Archive(context.Background(), ArchiveOptions{Before: before, Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 archived entries, got %d\n", n)
+	}
+	// Offloaded payloads are read with their entries, and deleted with them
+	expected := []string{
+		"BEGIN",
+		hook.Table.SelectQuery("WHERE created_at < $1 ORDER BY created_at"),
+		"DELETE FROM logs WHERE created_at < $1;",
+		"DELETE FROM log_payloads WHERE created_at < $1;",
+		"COMMIT",
+	}
+	if !reflect.DeepEqual(expected, recorded.statements) {
+		t.Errorf("Expected statements to be %q, got %q\n", expected, recorded.statements)
+	}
+	if !strings.Contains(expected[1], "FROM log_payloads") {
+		t.Errorf("Expected the payloads to be archived, got %q\n", expected[1])
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(store.objects["logs/20190318T100000Z.ndjson.gz"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		entry, err := UnmarshalNDJSON(scanner.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, entry.Message)
+	}
+	if expected := []string{"first", "second"}; !reflect.DeepEqual(expected, messages) {
+		t.Errorf("Expected archived entries to be %v, got %v\n", expected, messages)
+	}
+
+	// Nothing is deleted if the upload fails
+	recorded.statements = nil
+	store.err = errors.New("bucket not found")
+	if _, err := hook.Archive(context.Background(), ArchiveOptions{Before: before, Store: store}); err != store.err {
+		t.Errorf("Expected error to be %v, got %v\n", store.err, err)
+	}
+	for _, stmt := range recorded.statements {
+		if strings.HasPrefix(stmt, "DELETE") || stmt == "COMMIT" {
+			t.Errorf("Expected entries not to be deleted, got %q\n", recorded.statements)
+		}
+	}
+
+	// Nor if the export fails, even if the store doesn't return the error
+	db, recorded = openRecordingDB(func(query string) [][]driver.Value {
+		return [][]driver.Value{
+			{int64(1), int64(4), "first", []byte(`{"a":1}`), before.Add(-time.Hour)},
+			{int64(2), int64(2), "second", []byte(`{`), before.Add(-time.Minute)},
+		}
+	})
+	hook = NewHook(db, map[string]interface{}{})
+	store = &memoryStore{objects: map[string][]byte{}, ignoreReadErrors: true}
+	if _, err := hook.Archive(context.Background(), ArchiveOptions{Before: before, Store: store}); err == nil {
+		t.Error("Expected the export error to be returned")
+	}
+	for _, stmt := range recorded.statements {
+		if strings.HasPrefix(stmt, "DELETE") || stmt == "COMMIT" {
+			t.Errorf("Expected entries not to be deleted, got %q\n", recorded.statements)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	before := time.Date(2019, 3, 18, 10, 0, 0, 0, time.UTC)
+	db, recorded := openRecordingDB(func(query string) [][]driver.Value {
+		return [][]driver.Value{{int64(3)}}
+	})
+	hook := NewHook(db, map[string]interface{}{})
+	hook.Table.PayloadTable = "log_payloads"
+
+	if _, err := hook.Prune(context.Background(), before); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"DELETE FROM logs WHERE created_at < $1;",
+		"DELETE FROM log_payloads WHERE created_at < $1;",
+	}
+	if !reflect.DeepEqual(expected, recorded.statements) {
+		t.Errorf("Expected statements to be %q, got %q\n", expected, recorded.statements)
+	}
+
+	// The payloads of the entries matching the filters are deleted with them
+	recorded.statements = nil
+	n, err := hook.Prune(context.Background(), before, Field("env").Eq("staging"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 deleted entries, got %d\n", n)
+	}
+	expected = []string{
+		"WITH deleted AS (DELETE FROM logs WHERE created_at < $1 AND id IN (SELECT id FROM " + hook.Table.source() + " WHERE message_data->>'env' = $2) RETURNING payload_id), " +
+			"payloads AS (DELETE FROM log_payloads WHERE id IN (SELECT payload_id FROM deleted)) SELECT count(*) FROM deleted;",
+	}
+	if !reflect.DeepEqual(expected, recorded.statements) {
+		t.Errorf("Expected statements to be %q, got %q\n", expected, recorded.statements)
+	}
+
+	// Filters need PostgreSQL
+	recorded.statements = nil
+	hook.Table = TableConfig{Name: "logs", Dialect: SQLite}
+	if _, err := hook.Prune(context.Background(), before, Field("env").Eq("staging")); err == nil || len(recorded.statements) > 0 {
+		t.Errorf("Expected filters to be rejected with SQLite, got %v and statements %q\n", err, recorded.statements)
+	}
+	if _, err := hook.Query(context.Background(), QueryOptions{Where: []Filter{Field("latency_ms").Gt(500)}}); err == nil || len(recorded.statements) > 0 {
+		t.Errorf("Expected filters to be rejected with SQLite, got %v and statements %q\n", err, recorded.statements)
+	}
+}
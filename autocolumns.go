@@ -0,0 +1,105 @@
+package pglogrus
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AutoColumns adds a column to the hook table when a field of allowlist is
+// logged for the first time, with a type inferred from its value (see
+// InferColumnType), so the schema evolves with the code without migrations.
+// Only the fields of allowlist are added, and their names are used as column
+// names: it's meant for internal tools, where fields are trusted.
+// Columns are added with ALTER TABLE ... ADD COLUMN IF NOT EXISTS (PostgreSQL
+// only), while the entry is logged: other entries are still logged meanwhile,
+// unless they need a new column too. Failures are reported with the "ddl" Op,
+// and the field is kept in message_data.
+// With DryRun, the statements are written to the DryRun writer instead.
+func (hook *Hook) AutoColumns(allowlist ...string) {
+	allowed := make(map[string]bool, len(allowlist))
+	for _, field := range allowlist {
+		allowed[field] = true
+	}
+	hook.AddFilter(func(entry *logrus.Entry) *logrus.Entry {
+		for field, v := range entry.Data {
+			if !allowed[field] || hook.table().hasField(field) {
+				continue
+			}
+			typ, ok := InferColumnType(v)
+			if !ok {
+				continue
+			}
+			if err := hook.addColumn(Column{Name: field, Field: field, Type: typ}); err != nil {
+				hook.handleError(&ErrorEvent{Time: time.Now(), Op: "ddl", Err: err, Entry: entry})
+			}
+		}
+		return entry
+	})
+}
+
+// InferColumnType returns the PostgreSQL type of a column storing v, or false
+// if v isn't a scalar value.
+func InferColumnType(v interface{}) (string, bool) {
+	switch v.(type) {
+	case bool:
+		return "boolean", true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32:
+		return "bigint", true
+	case float32, float64:
+		return "double precision", true
+	case time.Time:
+		return "timestamp with time zone", true
+	case net.IP:
+		return "inet", true
+	case string:
+		return "text", true
+	}
+	return "", false
+}
+
+// addColumn adds c to the hook table (and its shards) and to its columns.
+// The statements are run while holding hook.columnsMu only: hook.mu is taken
+// to append c to the columns, so entries are still logged (and written by
+// an AsyncHook worker) while the table is altered.
+func (hook *Hook) addColumn(c Column) error {
+	hook.columnsMu.Lock()
+	defer hook.columnsMu.Unlock()
+	if hook.table().hasField(c.Field) {
+		return nil
+	}
+	for _, name := range hook.table().tableNames() {
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s;", name, c.definition())
+		var err error
+		if hook.DryRun != nil {
+			hook.dryRunMu.Lock()
+			_, err = io.WriteString(hook.DryRun, stmt+"\n")
+			hook.dryRunMu.Unlock()
+		} else {
+			_, err = hook.db.Exec(stmt)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	hook.mu.Lock()
+	hook.autoColumns = append(hook.autoColumns, c)
+	hook.mu.Unlock()
+	return nil
+}
+
+// table returns the hook Table, with the columns added by AutoColumns.
+func (hook *Hook) table() *TableConfig {
+	hook.mu.RLock()
+	auto := hook.autoColumns
+	hook.mu.RUnlock()
+	if len(auto) == 0 {
+		return &hook.Table
+	}
+	t := hook.Table
+	t.Columns = append(append([]Column(nil), t.Columns...), auto...)
+	return &t
+}
@@ -0,0 +1,223 @@
+package pglogrus
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// writeBatch writes batch in a transaction, and returns the entries which
+// couldn't be written.
+// bytes is the estimated size of batch, if known.
+func (hook *AsyncHook) writeBatch(batch []*logrus.Entry, bytes int) []EntryError {
+	return hook.writeTx(batch, bytes, false)
+}
+
+// writeTx writes batch in a transaction, and returns the entries which
+// couldn't be written. When allOrNothing is true, the transaction is rolled
+// back if an entry can't be inserted.
+func (hook *AsyncHook) writeTx(batch []*logrus.Entry, bytes int, allOrNothing bool) []EntryError {
+	end := hook.trace("batch")
+	start := time.Now()
+
+	var batchID string
+	if hook.BatchTable != "" || hook.Table.hasField(BatchIDField) {
+		batchID = newUUID()
+		batch = withBatchID(batch, batchID)
+	}
+
+	if hook.SortBatches {
+		batch = sortByTime(batch)
+	}
+	batch = hook.Table.groupByShard(batch)
+	ctx := context.Background()
+	driver := hook.Driver
+	if driver == nil {
+		driver = SQLDriver(hook.db)
+	}
+	if hook.TargetSelector != nil {
+		if target := hook.TargetSelector(batch); target != nil {
+			driver = SQLDriver(target)
+		}
+	}
+
+	txn, err := driver.BeginBatch(ctx)
+	for err != nil {
+		hook.handleError(&ErrorEvent{Time: time.Now(), Op: "begin", Err: err})
+		if hook.outage(batch) {
+			end(TraceInfo{Entries: len(batch), Failed: len(batch), Bytes: bytes, Err: err})
+			atomic.AddUint64(&hook.stats.dropped, uint64(len(batch)))
+			failures := make([]EntryError, len(batch))
+			for i, entry := range batch {
+				failures[i] = EntryError{Entry: entry, Err: err}
+			}
+			return failures
+		}
+		// Don't create new transactions too fast, it will flood stderr
+		<-hook.ticker.C
+		hook.checkLag()
+		txn, err = driver.BeginBatch(ctx)
+	}
+	hook.recovered()
+
+	insert := hook.wrapInsert(func(entry *logrus.Entry) error {
+		if hook.Savepoints && !allOrNothing {
+			return hook.insertWithSavepoint(ctx, txn, entry)
+		}
+		return hook.insertEntry(ctx, txn, entry)
+	})
+	var lastTime time.Time
+	var inserted []*logrus.Entry
+	var failures []EntryError
+	// insertedInTable is true once an entry is inserted in the hook table,
+	// instead of the table of its TableControlField
+	var insertedInTable bool
+	// abort rolls back the transaction, failing all the entries of batch
+	abort := func(err error) []EntryError {
+		txn.Rollback()
+		failures := make([]EntryError, len(batch))
+		for i, entry := range batch {
+			failures[i] = EntryError{Entry: entry, Err: err}
+		}
+		end(TraceInfo{Entries: len(batch), Failed: len(batch), Bytes: bytes, Err: err})
+		atomic.AddUint64(&hook.stats.dropped, uint64(len(batch)))
+		return failures
+	}
+	if hook.Copy {
+		if err := hook.copyEntries(ctx, txn, batch); err != nil {
+			hook.handleError(&ErrorEvent{Time: time.Now(), Op: "insert", Err: err})
+			return abort(err)
+		}
+	}
+	for _, entry := range batch {
+		var err error
+		if !hook.Copy {
+			err = insert(entry)
+		}
+		if err != nil {
+			hook.handleError(&ErrorEvent{Time: time.Now(), Op: "insert", Err: err, Entry: entry})
+			if allOrNothing {
+				return abort(err)
+			}
+			failures = append(failures, EntryError{Entry: entry, Err: err})
+			continue
+		}
+		inserted = append(inserted, entry)
+		if entryControls(entry).table == "" {
+			insertedInTable = true
+		}
+		if entry.Time.After(lastTime) {
+			lastTime = entry.Time
+		}
+	}
+
+	for _, entry := range inserted {
+		if query, args, ok := hook.annotationStatement(entry); ok {
+			// A failing annotation doesn't abort the transaction of the entries
+			err := withSavepoint(ctx, txn, "pglogrus_annotation", func() error {
+				return txn.Insert(ctx, query, args...)
+			})
+			if err != nil {
+				hook.handleError(&ErrorEvent{Time: time.Now(), Op: "annotation", Err: err, Entry: entry})
+			}
+		}
+	}
+
+	if hook.BatchTable != "" {
+		err = hook.saveBatch(ctx, txn, batchID, len(inserted), len(failures), time.Since(start))
+		if err != nil {
+			hook.handleError(&ErrorEvent{Time: time.Now(), Op: "batch", Err: err})
+		}
+	}
+
+	if hook.CheckpointTable != "" && !lastTime.IsZero() {
+		err = hook.saveCheckpoint(ctx, txn, lastTime, insertedInTable)
+		if err != nil {
+			hook.handleError(&ErrorEvent{Time: time.Now(), Op: "checkpoint", Err: err})
+		}
+	}
+
+	commitStart := time.Now()
+	err = txn.Commit()
+	hook.observe(stageCommit, commitStart)
+	if err != nil {
+		hook.handleError(&ErrorEvent{Time: time.Now(), Op: "commit", Err: err})
+		for _, entry := range inserted {
+			failures = append(failures, EntryError{Entry: entry, Err: err})
+		}
+		inserted = nil
+	} else {
+		hook.committed(lastTime)
+		hook.addRecent(inserted...)
+		hook.sink(inserted...)
+	}
+
+	end(TraceInfo{Entries: len(batch), Failed: len(failures), Bytes: bytes, Err: err})
+	atomic.AddUint64(&hook.stats.written, uint64(len(inserted)))
+	atomic.AddUint64(&hook.stats.dropped, uint64(len(failures)))
+	return failures
+}
+
+// sortByTime returns the entries of batch sorted by time. Entries with the
+// same time keep their order.
+func sortByTime(batch []*logrus.Entry) []*logrus.Entry {
+	sorted := append([]*logrus.Entry(nil), batch...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+	return sorted
+}
+
+// BatchIDField is the field holding the ID of the batch of an entry, when the
+// hook has a BatchTable, or a column storing it (see BatchIDColumn).
+const BatchIDField = "pglogrus.batch_id"
+
+// BatchIDColumn returns an indexed uuid column storing the ID of the batch
+// (transaction) which inserted each entry.
+func BatchIDColumn(name string) Column {
+	return Column{Name: name, Field: BatchIDField, Type: "uuid", Index: true}
+}
+
+// withBatchID returns copies of the entries of batch, with the BatchIDField.
+func withBatchID(batch []*logrus.Entry, id string) []*logrus.Entry {
+	stamped := make([]*logrus.Entry, len(batch))
+	for i, entry := range batch {
+		copied := *entry
+		copied.Data = make(logrus.Fields, len(entry.Data)+1)
+		for k, v := range entry.Data {
+			copied.Data[k] = v
+		}
+		copied.Data[BatchIDField] = id
+		stamped[i] = &copied
+	}
+	return stamped
+}
+
+// saveBatch inserts the metadata of a batch in the BatchTable, within a
+// savepoint of txn: a missing or outdated table doesn't abort the transaction
+// of the entries.
+func (hook *AsyncHook) saveBatch(ctx context.Context, txn Batch, id string, entries, failed int, duration time.Duration) error {
+	host, _ := os.Hostname()
+	return withSavepoint(ctx, txn, "pglogrus_batch", func() error {
+		return txn.Insert(ctx, fmt.Sprintf("INSERT INTO %s(id, entries, failed, duration_ms, host, committed_at) VALUES ($1,$2,$3,$4,$5,$6);", hook.BatchTable),
+			id, entries, failed, duration.Seconds()*1000, host, time.Now())
+	})
+}
+
+// batchTableSchema returns the SQL statement creating the table storing the
+// metadata of batches.
+func batchTableSchema(name string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id uuid PRIMARY KEY,
+    entries integer NOT NULL,
+    failed integer NOT NULL,
+    duration_ms double precision NOT NULL,
+    host text NOT NULL,
+    committed_at timestamp with time zone NOT NULL
+);`, name)
+}
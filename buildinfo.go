@@ -0,0 +1,57 @@
+package pglogrus
+
+import (
+	"runtime/debug"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fields added by AddBuildInfo
+const (
+	BuildVersionField  = "build.version"
+	BuildRevisionField = "build.revision"
+	BuildModifiedField = "build.modified"
+)
+
+// AddBuildInfo adds the version of the main module and the VCS revision of
+// the binary (see debug.ReadBuildInfo) to the entry fields, so every entry
+// identifies the binary that logged it.
+// Fields are omitted when the information isn't available, like in binaries
+// built outside of a VCS checkout.
+func (hook *Hook) AddBuildInfo() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	hook.AddFilter(fieldsFilter(buildInfoFields(info)))
+}
+
+// buildInfoFields returns the fields describing info
+func buildInfoFields(info *debug.BuildInfo) logrus.Fields {
+	fields := logrus.Fields{}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		fields[BuildVersionField] = v
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			fields[BuildRevisionField] = s.Value
+		case "vcs.modified":
+			fields[BuildModifiedField] = s.Value == "true"
+		}
+	}
+	return fields
+}
+
+// fieldsFilter adds fields to the entries, without overwriting their own
+// fields.
+func fieldsFilter(fields logrus.Fields) filter {
+	return func(entry *logrus.Entry) *logrus.Entry {
+		for k, v := range fields {
+			if _, ok := entry.Data[k]; !ok {
+				entry.Data[k] = v
+			}
+		}
+		return entry
+	}
+}
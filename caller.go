@@ -0,0 +1,131 @@
+package pglogrus
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fields added by AddCaller, named like the fields of the formatters of
+// logrus.
+const (
+	CallerFileField     = logrus.FieldKeyFile
+	CallerFunctionField = logrus.FieldKeyFunc
+)
+
+// CallerOptions configure how AddCaller trims the paths of the caller files.
+type CallerOptions struct {
+	// TrimPrefixes are removed from the paths, eg. the directory of the
+	// module ("/home/ci/src/github.com/org/app/"). By default, the paths
+	// start with the import path of their package, when it can be found
+	// from the main module or the GOPATH: the GOPATH src directory and the
+	// module cache are trimmed.
+	TrimPrefixes []string
+	// PathElements is the number of path elements kept at the end of the
+	// paths ("pkg/file.go" for 2). 0 keeps the whole paths.
+	PathElements int
+}
+
+// AddCaller adds the caller of entries, reported by loggers with
+// SetReportCaller(true), to their fields: its file ("path/file.go:42") in the
+// CallerFileField, and its function in the CallerFunctionField.
+// Paths are trimmed according to opts, so they're short and consistent in the
+// DB, and don't leak local build paths (see also the -trimpath flag of go
+// build).
+func (hook *Hook) AddCaller(opts CallerOptions) {
+	hook.AddFilter(func(entry *logrus.Entry) *logrus.Entry {
+		if entry.Caller == nil {
+			return entry
+		}
+		entry.Data[CallerFileField] = fmt.Sprintf("%s:%d", trimPath(entry.Caller.File, entry.Caller.Function, opts), entry.Caller.Line)
+		entry.Data[CallerFunctionField] = entry.Caller.Function
+		return entry
+	})
+}
+
+// mainModule is the path of the main module of the binary, if known.
+var mainModule = func() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Path
+	}
+	return ""
+}()
+
+// gopathPrefixes are the GOPATH src directories and the module cache, with a
+// trailing slash.
+var gopathPrefixes = func() []string {
+	var prefixes []string
+	if cache := os.Getenv("GOMODCACHE"); cache != "" {
+		prefixes = append(prefixes, filepath.ToSlash(cache)+"/")
+	}
+	for _, dir := range filepath.SplitList(build.Default.GOPATH) {
+		dir = filepath.ToSlash(dir)
+		prefixes = append(prefixes, dir+"/src/", dir+"/pkg/mod/")
+	}
+	return prefixes
+}()
+
+// trimPath returns the path of the file of function trimmed according to
+// opts.
+func trimPath(file, function string, opts CallerOptions) string {
+	trimmed := false
+	for _, prefix := range opts.TrimPrefixes {
+		if strings.HasPrefix(file, prefix) {
+			file = file[len(prefix):]
+			trimmed = true
+			break
+		}
+	}
+	if !trimmed && len(opts.TrimPrefixes) == 0 {
+		file = trimGoPath(file, function)
+	}
+	if opts.PathElements > 0 {
+		elements := strings.Split(file, "/")
+		if len(elements) > opts.PathElements {
+			file = strings.Join(elements[len(elements)-opts.PathElements:], "/")
+		}
+	}
+	return file
+}
+
+// trimGoPath returns file starting with the import path of the package of
+// function, when its directory ends with the import path (GOPATH) or with
+// its path in the main module. Otherwise, the GOPATH src directories and the
+// module cache are trimmed.
+func trimGoPath(file, function string) string {
+	if pkg := functionPackage(function); pkg != "" {
+		dir := path.Dir(file)
+		if strings.HasSuffix(dir, "/"+pkg) {
+			return pkg + "/" + path.Base(file)
+		}
+		if mainModule != "" && strings.HasPrefix(pkg+"/", mainModule+"/") {
+			if rel := strings.TrimPrefix(pkg, mainModule); strings.HasSuffix(dir, rel) {
+				return pkg + "/" + path.Base(file)
+			}
+		}
+	}
+	for _, prefix := range gopathPrefixes {
+		if strings.HasPrefix(file, prefix) {
+			return file[len(prefix):]
+		}
+	}
+	return file
+}
+
+// functionPackage returns the import path of the package of function, as
+// named by runtime.Frame (eg. "github.com/org/app/pkg.(*T).Method").
+func functionPackage(function string) string {
+	slash := strings.LastIndex(function, "/")
+	dot := strings.Index(function[slash+1:], ".")
+	if dot < 0 {
+		return ""
+	}
+	// Dots of the last element are escaped
+	return strings.Replace(function[:slash+1+dot], "%2e", ".", -1)
+}
@@ -0,0 +1,98 @@
+package pglogrus
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LastCommitted returns the time of the most recent entry committed to the DB
+// by the hook, or the zero time if none was.
+// Entries aren't logged in time order: entries older than LastCommitted can
+// still be committed afterwards. Downstream consumers reading the table
+// incrementally should use the CommittedID of the Checkpoint instead.
+func (hook *Hook) LastCommitted() time.Time {
+	nsec := atomic.LoadInt64(&hook.stats.lastCommitted)
+	if nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec)
+}
+
+// committed records t as committed, if it's more recent than the last
+// committed time.
+func (hook *Hook) committed(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	nsec := t.UnixNano()
+	for {
+		last := atomic.LoadInt64(&hook.stats.lastCommitted)
+		if nsec <= last || atomic.CompareAndSwapInt64(&hook.stats.lastCommitted, last, nsec) {
+			return
+		}
+	}
+}
+
+// A Checkpoint is the progress of the AsyncHook writing to its table, persisted
+// in the CheckpointTable.
+type Checkpoint struct {
+	// CommittedAt is the time of the most recent entry committed (see
+	// LastCommitted).
+	CommittedAt time.Time
+	// CommittedID is the id of the last row inserted in the hook table, 0
+	// if none was (or if the table is sharded).
+	// The ids are generated in insertion order: when the hook is the only
+	// writer of the table, all the rows up to CommittedID are committed.
+	// With several writers, rows with smaller ids may still be committed by
+	// concurrent transactions.
+	CommittedID int64
+}
+
+// Checkpoint returns the checkpoint of the hook table, persisted in the
+// CheckpointTable, or a zero Checkpoint if there's none yet.
+func (hook *Hook) Checkpoint(ctx context.Context) (Checkpoint, error) {
+	if hook.CheckpointTable == "" {
+		return Checkpoint{}, fmt.Errorf("pglogrus: no CheckpointTable")
+	}
+	var checkpoint Checkpoint
+	var id sql.NullInt64
+	err := hook.db.QueryRowContext(ctx, fmt.Sprintf("SELECT committed_at, committed_id FROM %s WHERE name = $1;", hook.CheckpointTable), hook.Table.Name).Scan(&checkpoint.CommittedAt, &id)
+	if err == sql.ErrNoRows {
+		return Checkpoint{}, nil
+	}
+	checkpoint.CommittedID = id.Int64
+	return checkpoint, err
+}
+
+// saveCheckpoint persists t as the last committed time of the hook table in
+// the CheckpointTable, within a savepoint of txn: a failure doesn't abort the
+// transaction of the entries. If inserted is true, the id of the last row
+// inserted in the hook table by txn is persisted too.
+func (hook *Hook) saveCheckpoint(ctx context.Context, txn Batch, t time.Time, inserted bool) error {
+	id := "NULL"
+	if inserted && hook.Table.Shards <= 1 {
+		// The last value generated by the session, for the rows of txn
+		id = fmt.Sprintf("currval(pg_get_serial_sequence('%s', 'id'))", strings.Replace(hook.Table.Name, "'", "''", -1))
+	}
+	return withSavepoint(ctx, txn, "pglogrus_checkpoint", func() error {
+		return txn.Insert(ctx, fmt.Sprintf("INSERT INTO %[1]s(name, committed_at, committed_id) VALUES ($1,$2,%[2]s) ON CONFLICT (name) DO UPDATE SET committed_at = GREATEST(%[1]s.committed_at, EXCLUDED.committed_at), committed_id = GREATEST(%[1]s.committed_id, EXCLUDED.committed_id);", hook.CheckpointTable, id), hook.Table.Name, t)
+	})
+}
+
+// checkpointTableSchema returns the SQL statements creating the table storing
+// checkpoints. The committed_id column is added to the tables created by
+// previous versions.
+func checkpointTableSchema(name string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    name text PRIMARY KEY,
+    committed_at timestamp with time zone NOT NULL,
+    committed_id bigint
+);`, name),
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS committed_id bigint;", name),
+	}
+}
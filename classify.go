@@ -0,0 +1,113 @@
+package pglogrus
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+)
+
+// ErrorClass tells whether an operation failing with an error can be retried.
+type ErrorClass int
+
+const (
+	// UnknownError can't be classified.
+	UnknownError ErrorClass = iota
+	// RetryableError is transient: connection errors, serialization
+	// failures, deadlocks, ...
+	RetryableError
+	// PermanentError fails again when retried: constraint violations, data
+	// type errors, undefined tables, ...
+	PermanentError
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case RetryableError:
+		return "retryable"
+	case PermanentError:
+		return "permanent"
+	}
+	return "unknown"
+}
+
+// sqlStater is implemented by the errors of lib/pq and pgx, among others.
+type sqlStater interface {
+	SQLState() string
+}
+
+// A DBError is a database error, with its SQLSTATE code and class.
+// Errors of the DB operations of the hook are passed to the ErrorHandler as
+// DBError.
+type DBError struct {
+	// Code is the SQLSTATE code of the error, if any.
+	Code  string
+	Class ErrorClass
+	Err   error
+}
+
+func (e *DBError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the driver error.
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+// newDBError wraps err into a DBError, unless it's one already.
+func newDBError(err error) error {
+	var dbErr *DBError
+	if err == nil || errors.As(err, &dbErr) {
+		return err
+	}
+	dbErr = &DBError{Class: Classify(err), Err: err}
+	var s sqlStater
+	if errors.As(err, &s) {
+		dbErr.Code = s.SQLState()
+	}
+	return dbErr
+}
+
+// Classify returns the class of err, using its SQLSTATE code if it has one.
+func Classify(err error) ErrorClass {
+	var dbErr *DBError
+	if errors.As(err, &dbErr) {
+		return dbErr.Class
+	}
+	var s sqlStater
+	if errors.As(err, &s) {
+		return classifySQLState(s.SQLState())
+	}
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) {
+		return RetryableError
+	}
+	return UnknownError
+}
+
+// classifySQLState classifies a SQLSTATE code.
+// See https://www.postgresql.org/docs/current/errcodes-appendix.html
+func classifySQLState(code string) ErrorClass {
+	switch code {
+	case "40001", // serialization_failure
+		"40P01", // deadlock_detected
+		"55P03", // lock_not_available
+		"57014", // query_canceled
+		"57P01", // admin_shutdown
+		"57P02", // crash_shutdown
+		"57P03": // cannot_connect_now
+		return RetryableError
+	}
+	switch {
+	case strings.HasPrefix(code, "08"), // connection exception
+		strings.HasPrefix(code, "53"): // insufficient resources
+		return RetryableError
+	case strings.HasPrefix(code, "22"), // data exception
+		strings.HasPrefix(code, "23"), // integrity constraint violation
+		strings.HasPrefix(code, "42"): // syntax error or access rule violation
+		return PermanentError
+	}
+	return UnknownError
+}
@@ -0,0 +1,286 @@
+package pglogrus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Config is the configuration of a hook loaded from the environment
+// (ConfigFromEnv) or a file (ConfigFromJSON), so deployments can tune the
+// hook without recompiling:
+//
+//	config, err := pglogrus.ConfigFromEnv()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	hook, err := pglogrus.NewAsyncHookDSN(config.DSN, config.Options()...)
+//
+// Options following config.Options() override the config.
+type Config struct {
+	// DSN of the database, for NewHookDSN and NewAsyncHookDSN.
+	DSN string `json:"dsn"`
+	// Table is the name of the table, "logs" by default.
+	Table string `json:"table"`
+	// Level is the least severe level of the stored entries (eg. "info"),
+	// all levels by default.
+	Level string `json:"level"`
+	// Sampling is the ratio of the entries stored, by level (eg.
+	// {"debug": 0.1}). Entries of the other levels are all stored.
+	Sampling map[string]float64 `json:"sampling"`
+	// FlushInterval is the interval between the transactions of the
+	// AsyncHook, a second by default.
+	FlushInterval Duration `json:"flush_interval"`
+	// MaxBatchBytes is AsyncHook.MaxBatchBytes, unchanged if 0.
+	MaxBatchBytes int `json:"max_batch_bytes"`
+	// NonBlocking is AsyncHook.NonBlocking, unchanged if nil.
+	NonBlocking *bool `json:"non_blocking"`
+	// Retention is Hook.Retention: the maximum age of the entries, deleted by
+	// EnforceRetention. 0 keeps the entries forever.
+	Retention Duration `json:"retention"`
+}
+
+// Duration is a time.Duration decoded from strings like "1m30s".
+type Duration time.Duration
+
+// UnmarshalJSON decodes a duration string, or a number of nanoseconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	case float64:
+		*d = Duration(v)
+	default:
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	return nil
+}
+
+// MarshalJSON encodes d as a duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ConfigFromEnv loads the config from the environment variables:
+// PGLOGRUS_DSN, PGLOGRUS_TABLE, PGLOGRUS_LEVEL, PGLOGRUS_SAMPLING (like
+// "debug=0.1,trace=0.01"), PGLOGRUS_FLUSH_INTERVAL, PGLOGRUS_MAX_BATCH_BYTES,
+// PGLOGRUS_NON_BLOCKING and PGLOGRUS_RETENTION.
+// Unset variables keep their default value.
+func ConfigFromEnv() (Config, error) {
+	return configFromEnv(os.Getenv)
+}
+
+func configFromEnv(getenv func(string) string) (Config, error) {
+	c := Config{
+		DSN:   getenv("PGLOGRUS_DSN"),
+		Table: getenv("PGLOGRUS_TABLE"),
+		Level: getenv("PGLOGRUS_LEVEL"),
+	}
+	durations := map[string]*Duration{
+		"PGLOGRUS_FLUSH_INTERVAL": &c.FlushInterval,
+		"PGLOGRUS_RETENTION":      &c.Retention,
+	}
+	for name, d := range durations {
+		if v := getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return c, fmt.Errorf("pglogrus: invalid %s: %v", name, err)
+			}
+			*d = Duration(parsed)
+		}
+	}
+	var err error
+	if v := getenv("PGLOGRUS_SAMPLING"); v != "" {
+		c.Sampling = map[string]float64{}
+		for _, pair := range strings.Split(v, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return c, fmt.Errorf("pglogrus: invalid PGLOGRUS_SAMPLING: %q", pair)
+			}
+			if c.Sampling[strings.TrimSpace(kv[0])], err = strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err != nil {
+				return c, fmt.Errorf("pglogrus: invalid PGLOGRUS_SAMPLING: %v", err)
+			}
+		}
+	}
+	if v := getenv("PGLOGRUS_MAX_BATCH_BYTES"); v != "" {
+		if c.MaxBatchBytes, err = strconv.Atoi(v); err != nil {
+			return c, fmt.Errorf("pglogrus: invalid PGLOGRUS_MAX_BATCH_BYTES: %v", err)
+		}
+	}
+	if v := getenv("PGLOGRUS_NON_BLOCKING"); v != "" {
+		nonBlocking, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("pglogrus: invalid PGLOGRUS_NON_BLOCKING: %v", err)
+		}
+		c.NonBlocking = &nonBlocking
+	}
+	return c, c.validate()
+}
+
+// ConfigFromJSON loads the config from a JSON document, like:
+//
+//	{"dsn": "host=postgres dbname=logs", "level": "info", "flush_interval": "500ms"}
+//
+// Unknown keys are rejected, to catch typos.
+func ConfigFromJSON(r io.Reader) (Config, error) {
+	var c Config
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		return c, fmt.Errorf("pglogrus: invalid config: %v", err)
+	}
+	return c, c.validate()
+}
+
+// validate checks the values of the config.
+func (c Config) validate() error {
+	if c.Level != "" {
+		if _, err := logrus.ParseLevel(c.Level); err != nil {
+			return fmt.Errorf("pglogrus: invalid config: %v", err)
+		}
+	}
+	if _, err := c.sampling(); err != nil {
+		return err
+	}
+	if c.FlushInterval < 0 || c.Retention < 0 || c.MaxBatchBytes < 0 {
+		return fmt.Errorf("pglogrus: invalid config: negative value")
+	}
+	return nil
+}
+
+// Options returns the options applying the config (except the DSN). The
+// settings of the AsyncHook are only applied by NewAsync and
+// NewAsyncHookDSN.
+func (c Config) Options() []Option {
+	var opts []Option
+	if c.Table != "" {
+		opts = append(opts, func(o *options) error {
+			o.hook.Table.Name = c.Table
+			return nil
+		})
+	}
+	if c.Level != "" {
+		opts = append(opts, func(o *options) error {
+			level, err := logrus.ParseLevel(c.Level)
+			if err != nil {
+				return err
+			}
+			o.hook.setLevel(level)
+			return nil
+		})
+	}
+	if len(c.Sampling) > 0 {
+		opts = append(opts, func(o *options) error {
+			rates, err := c.sampling()
+			if err != nil {
+				return err
+			}
+			o.hook.setSampling(rates)
+			return nil
+		})
+	}
+	if c.Retention > 0 {
+		opts = append(opts, func(o *options) error {
+			o.hook.Retention = time.Duration(c.Retention)
+			return nil
+		})
+	}
+	opts = append(opts, func(o *options) error {
+		o.hook.asyncOptions = append(o.hook.asyncOptions, func(a *AsyncHook) {
+			if c.FlushInterval > 0 {
+				a.ticker.Stop()
+				a.ticker = time.NewTicker(time.Duration(c.FlushInterval))
+			}
+			// Settings which aren't set keep those of previous options
+			if c.MaxBatchBytes > 0 {
+				a.MaxBatchBytes = c.MaxBatchBytes
+			}
+			if c.NonBlocking != nil {
+				a.NonBlocking = *c.NonBlocking
+			}
+		})
+		return nil
+	})
+	return opts
+}
+
+// WithLevel makes the hook ignore the entries less severe than level.
+func WithLevel(level logrus.Level) Option {
+	return func(o *options) error {
+		o.hook.setLevel(level)
+		return nil
+	}
+}
+
+// setLevel sets the least severe level of the stored entries.
+func (hook *Hook) setLevel(level logrus.Level) {
+	atomic.StoreUint32(&hook.level, uint32(level)+1)
+}
+
+// resetLevel makes the hook store the entries of all levels.
+func (hook *Hook) resetLevel() {
+	atomic.StoreUint32(&hook.level, 0)
+}
+
+// ignoredLevel reports whether entries of level are ignored (see WithLevel).
+func (hook *Hook) ignoredLevel(level logrus.Level) bool {
+	max := atomic.LoadUint32(&hook.level)
+	return max != 0 && uint32(level) >= max
+}
+
+// sampleRates are the ratios of the entries stored, by level.
+type sampleRates [logrus.TraceLevel + 1]float64
+
+// sampling returns the sample rates of the config, nil if all the entries are
+// stored.
+func (c Config) sampling() (*sampleRates, error) {
+	if len(c.Sampling) == 0 {
+		return nil, nil
+	}
+	rates := &sampleRates{}
+	for i := range rates {
+		rates[i] = 1
+	}
+	for name, rate := range c.Sampling {
+		level, err := logrus.ParseLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("pglogrus: invalid config: %v", err)
+		}
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("pglogrus: invalid config: sampling rate %v of %s isn't between 0 and 1", rate, name)
+		}
+		rates[level] = rate
+	}
+	return rates, nil
+}
+
+// setSampling sets the sample rates of the hook, nil to store all the
+// entries.
+func (hook *Hook) setSampling(rates *sampleRates) {
+	hook.sampling.Store(rates)
+}
+
+// sampledOut reports whether entry is dropped by the sampling of the hook.
+func (hook *Hook) sampledOut(entry *logrus.Entry) bool {
+	rates, _ := hook.sampling.Load().(*sampleRates)
+	if rates == nil || int(entry.Level) >= len(rates) {
+		return false
+	}
+	return rand.Float64() >= rates[entry.Level]
+}
@@ -0,0 +1,98 @@
+package pglogrus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Control fields are reserved fields changing how an entry is written. They
+// are removed from the entry before it's stored, and can be set by filters
+// too.
+const (
+	// TableControlField is the table where the entry is stored, instead of
+	// the hook table (or its shards). The table must be one of the
+	// ControlTables of the hook, with the columns of the hook table.
+	TableControlField = "pglogrus.table"
+	// SyncControlField makes the AsyncHook write the entry synchronously,
+	// when it's true: Fire returns once the entry is committed, like Sync.
+	SyncControlField = "pglogrus.sync"
+	// SkipControlField makes the hook ignore the entry, when it's set (see
+	// Skip).
+	SkipControlField = "pglogrus.skip"
+)
+
+// Skip returns entry with the SkipControlField, so it's ignored by the hook
+// but still handled by the other hooks and formatters of the logger. Use it
+// to log from code paths which must not recurse into the hook, like the code
+// accessing the database:
+//
+//	pglogrus.Skip(log.WithField("query", query)).Warn("Slow query")
+func Skip(entry *logrus.Entry) *logrus.Entry {
+	return entry.WithField(SkipControlField, true)
+}
+
+// controls are the control fields of an entry, carried by its context once
+// removed from its fields.
+type controls struct {
+	table string
+	sync  bool
+}
+
+type controlsKey struct{}
+
+// stripControls removes the control fields of entry, and stores them in its
+// context.
+func (hook *Hook) stripControls(entry *logrus.Entry) {
+	table, hasTable := entry.Data[TableControlField]
+	sync, hasSync := entry.Data[SyncControlField]
+	if !hasTable && !hasSync {
+		return
+	}
+	delete(entry.Data, TableControlField)
+	delete(entry.Data, SyncControlField)
+
+	var c controls
+	if hasTable {
+		name := fmt.Sprint(table)
+		if hook.controlTable(name) {
+			c.table = name
+		} else {
+			hook.handleError(&ErrorEvent{Time: time.Now(), Op: "filter", Err: fmt.Errorf("pglogrus: %s %q isn't one of the ControlTables", TableControlField, name), Entry: entry})
+		}
+	}
+	switch v := sync.(type) {
+	case bool:
+		c.sync = v
+	case string:
+		c.sync, _ = strconv.ParseBool(v)
+	}
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	entry.Context = context.WithValue(ctx, controlsKey{}, c)
+}
+
+// controlTable reports whether entries can be stored in table with the
+// TableControlField.
+func (hook *Hook) controlTable(table string) bool {
+	for _, t := range hook.ControlTables {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
+
+// entryControls returns the control fields of entry.
+func entryControls(entry *logrus.Entry) controls {
+	if entry.Context == nil {
+		return controls{}
+	}
+	c, _ := entry.Context.Value(controlsKey{}).(controls)
+	return c
+}
@@ -0,0 +1,72 @@
+package pglogrus
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+// copyEntries inserts the entries of batch with the Copy of txn: the rows of
+// consecutive entries stored in the same table, with the same columns, are
+// copied together. Entries with an offloaded payload are inserted with their
+// statement.
+func (hook *AsyncHook) copyEntries(ctx context.Context, txn Batch, batch []*logrus.Entry) error {
+	t := hook.table()
+	if t.Dialect != Postgres {
+		return errors.New("pglogrus: Copy is only supported by PostgreSQL")
+	}
+	var table string
+	var columns []string
+	var rows [][]interface{}
+	copyRows := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		err := txn.Copy(ctx, table, columns, rows)
+		rows = nil
+		return err
+	}
+	for _, entry := range batch {
+		entryColumns, args, err := t.insertValues(entry)
+		if err != nil {
+			return err
+		}
+		hook.observeSize(args)
+		if !t.Wide && t.offloaded(args[2]) {
+			if err := copyRows(); err != nil {
+				return err
+			}
+			if err := txn.Insert(ctx, t.offloadStatement(entry, entryColumns, args), args...); err != nil {
+				return err
+			}
+			continue
+		}
+		if data, ok := args[2].([]byte); ok && !t.Wide && t.Encoding == nil {
+			// COPY sends []byte as bytea: send the JSON as text
+			args[2] = string(data)
+		}
+		name := t.tableName(entry)
+		if name != table || !sameColumns(columns, entryColumns) {
+			if err := copyRows(); err != nil {
+				return err
+			}
+			table, columns = name, entryColumns
+		}
+		rows = append(rows, args)
+	}
+	return copyRows()
+}
+
+// sameColumns reports whether a and b are the same columns, in the same order.
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package pglogrus
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// debugConfig is the configuration of the hook exposed by DebugHandler.
+// Extra field values aren't exposed, as they may be sensitive.
+type debugConfig struct {
+	Table           TableConfig
+	ExtraFields     []string
+	ErrorTable      string
+	CheckpointTable string
+	BufSize         uint
+}
+
+// debugState is the state of the hook exposed by DebugHandler.
+type debugState struct {
+	Stats         Stats
+	LastCommitted time.Time
+	Config        debugConfig
+	Recent        []ndjsonEntry
+}
+
+// DebugHandler returns an HTTP handler exposing the hook stats, config and
+// recent entries (see KeepRecent) as JSON.
+// The number of recent entries can be limited with the "limit" query
+// parameter.
+func (hook *Hook) DebugHandler() http.Handler {
+	return hook.debugHandler(nil)
+}
+
+// DebugHandler returns an HTTP handler exposing the hook stats, config and
+// recent entries (see KeepRecent) as JSON.
+// The number of recent entries can be limited with the "limit" query
+// parameter.
+// POST requests write the queued entries to the DB first (see Sync).
+func (hook *AsyncHook) DebugHandler() http.Handler {
+	return hook.debugHandler(hook.Sync)
+}
+
+func (hook *Hook) debugHandler(sync func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if sync != nil {
+				if err := sync(); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		state := debugState{
+			Stats:         hook.Stats(),
+			LastCommitted: hook.LastCommitted(),
+			Config: debugConfig{
+				Table:           hook.Table,
+				ErrorTable:      hook.ErrorTable,
+				CheckpointTable: hook.CheckpointTable,
+				BufSize:         BufSize,
+			},
+			Recent: []ndjsonEntry{},
+		}
+		hook.mu.RLock()
+		for k := range hook.Extra {
+			state.Config.ExtraFields = append(state.Config.ExtraFields, k)
+		}
+		hook.mu.RUnlock()
+
+		recent := hook.Recent(nil)
+		if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit >= 0 && limit < len(recent) {
+			recent = recent[len(recent)-limit:]
+		}
+		for _, entry := range recent {
+			state.Recent = append(state.Recent, newNDJSONEntry(entry))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(state)
+	})
+}
@@ -0,0 +1,89 @@
+package pglogrus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect is the SQL dialect of the database storing the hook table.
+// Features other than inserting entries and creating the table (like the
+// ErrorTable, CheckpointTable, BatchTable, migrations and archives) are
+// specific to PostgreSQL.
+type Dialect int
+
+const (
+	// Postgres is the dialect of PostgreSQL, and the default.
+	Postgres Dialect = iota
+	// MySQL is the dialect of MySQL (5.7 and later) and MariaDB.
+	MySQL
+	// SQLite is the dialect of SQLite (3.31 and later, for generated
+	// columns).
+	SQLite
+)
+
+// placeholder returns the placeholder of the i-th argument of a statement,
+// starting at 1.
+func (d Dialect) placeholder(i int) string {
+	if d == Postgres {
+		return fmt.Sprintf("$%d", i)
+	}
+	return "?"
+}
+
+// baseColumns returns the definitions of the columns of all hook tables.
+func (d Dialect) baseColumns() []string {
+	switch d {
+	case MySQL:
+		return []string{
+			"id BIGINT AUTO_INCREMENT PRIMARY KEY",
+			"level smallint NOT NULL",
+			"message text NOT NULL",
+			"message_data json NOT NULL",
+			"created_at datetime(6) NOT NULL",
+		}
+	case SQLite:
+		return []string{
+			"id INTEGER PRIMARY KEY AUTOINCREMENT",
+			"level integer NOT NULL",
+			"message text NOT NULL",
+			"message_data text NOT NULL",
+			"created_at timestamp NOT NULL",
+		}
+	}
+	return []string{
+		"id SERIAL",
+		"level smallint NOT NULL",
+		"message text NOT NULL",
+		"message_data json NOT NULL",
+		"created_at timestamp with time zone NOT NULL",
+	}
+}
+
+// jsonValue returns the argument inserting data, encoded in JSON, in
+// message_data.
+func (d Dialect) jsonValue(data []byte) interface{} {
+	if d == Postgres {
+		return data
+	}
+	// MySQL rejects JSON values in binary strings
+	return string(data)
+}
+
+// renderStatement returns query with its placeholders replaced by the SQL
+// literals of args.
+func (d Dialect) renderStatement(query string, args []interface{}) string {
+	if d == Postgres {
+		return renderStatement(query, args)
+	}
+	var b strings.Builder
+	i := 0
+	for _, r := range query {
+		if r == '?' && i < len(args) {
+			b.WriteString(sqlLiteral(args[i]))
+			i++
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package pglogrus
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A Driver writes batches of entries to a database, for the AsyncHook.
+// SQLDriver is the implementation for database/sql, used by default.
+// Other implementations can target other client libraries (see the pgxdriver
+// package) or PostgreSQL-compatible databases, without changing the hook.
+type Driver interface {
+	// BeginBatch starts a batch, usually a transaction.
+	BeginBatch(ctx context.Context) (Batch, error)
+}
+
+// A Batch is a set of statements committed together.
+type Batch interface {
+	// Insert executes a statement of the batch: statements inserting
+	// entries, but also savepoints and upserts of the hook tables (see
+	// CheckpointTable). Placeholders are those of the Dialect of the hook
+	// Table: $1, $2, ... for PostgreSQL, and ? for MySQL and SQLite.
+	Insert(ctx context.Context, query string, args ...interface{}) error
+	// Copy inserts rows in the columns of table, using the bulk copy
+	// protocol of PostgreSQL (see AsyncHook.Copy).
+	Copy(ctx context.Context, table string, columns []string, rows [][]interface{}) error
+	Commit() error
+	Rollback() error
+}
+
+// SQLDriver returns a Driver writing batches to db, in transactions.
+// With this driver, the AsyncHook inserts entries with its InsertFunc.
+// Copy uses the COPY support of github.com/lib/pq.
+func SQLDriver(db *sql.DB) Driver {
+	return sqlDriver{db: db}
+}
+
+type sqlDriver struct {
+	db *sql.DB
+}
+
+func (d sqlDriver) BeginBatch(ctx context.Context) (Batch, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlBatch{tx: tx}, nil
+}
+
+type sqlBatch struct {
+	tx *sql.Tx
+}
+
+func (b *sqlBatch) Insert(ctx context.Context, query string, args ...interface{}) error {
+	_, err := b.tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Copy executes a COPY FROM STDIN statement, like pq.CopyIn: each row is sent
+// by an Exec of the statement, and the data is flushed by a last Exec without
+// arguments.
+func (b *sqlBatch) Copy(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = `"` + strings.Replace(c, `"`, `""`, -1) + `"`
+	}
+	stmt, err := b.tx.PrepareContext(ctx, fmt.Sprintf("COPY %s (%s) FROM STDIN", table, strings.Join(quoted, ", ")))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
+
+func (b *sqlBatch) Commit() error {
+	return b.tx.Commit()
+}
+
+func (b *sqlBatch) Rollback() error {
+	return b.tx.Rollback()
+}
+
+// insertEntry inserts entry within batch, with the InsertFunc of the hook for
+// database/sql batches.
+func (hook *AsyncHook) insertEntry(ctx context.Context, batch Batch, entry *logrus.Entry) error {
+	if b, ok := batch.(*sqlBatch); ok {
+		return hook.InsertFunc(b.tx, entry)
+	}
+	start := time.Now()
+	query, args, err := hook.table().insertStatement(entry)
+	if err != nil {
+		return err
+	}
+	hook.observe(stageMarshal, start)
+	hook.observeSize(args)
+	defer hook.observe(stageInsert, time.Now())
+	return batch.Insert(ctx, query, args...)
+}
@@ -0,0 +1,68 @@
+package pglogrus
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dryRun writes the statement inserting entry to the DryRun writer.
+func (hook *Hook) dryRun(entry *logrus.Entry) error {
+	query, args, err := hook.table().insertStatement(entry)
+	if err != nil {
+		return err
+	}
+	hook.dryRunMu.Lock()
+	defer hook.dryRunMu.Unlock()
+	_, err = io.WriteString(hook.DryRun, hook.Table.Dialect.renderStatement(query, args)+"\n")
+	return err
+}
+
+// renderStatement returns query with its $N placeholders replaced by the SQL
+// literals of args. The query is scanned once, so the literals aren't
+// replaced again, even if they contain $N.
+func renderStatement(query string, args []interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		j := i + 1
+		for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+			j++
+		}
+		if query[i] == '$' && j > i+1 {
+			if n, err := strconv.Atoi(query[i+1 : j]); err == nil && n >= 1 && n <= len(args) {
+				b.WriteString(sqlLiteral(args[n-1]))
+				i = j - 1
+				continue
+			}
+		}
+		b.WriteByte(query[i])
+	}
+	return b.String()
+}
+
+// sqlLiteral returns the SQL literal of v, for display only.
+func sqlLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case logrus.Level:
+		return fmt.Sprint(uint32(v))
+	case string:
+		return quoteLiteral(v)
+	case []byte:
+		return quoteLiteral(string(v))
+	case time.Time:
+		return quoteLiteral(v.Format(time.RFC3339Nano))
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprint(v)
+	}
+	return quoteLiteral(fmt.Sprint(v))
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
@@ -0,0 +1,153 @@
+package pglogrus
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/url"
+	"strings"
+)
+
+// Credentials are the user and password used to connect to the database.
+type Credentials struct {
+	User, Password string
+}
+
+// WithDriver sets the name of the database/sql driver used by NewHookDSN and
+// NewAsyncHookDSN, "postgres" (github.com/lib/pq) by default.
+func WithDriver(name string) Option {
+	return func(o *options) error {
+		o.driverName = name
+		return nil
+	}
+}
+
+// WithCredentials sets a provider of the credentials used by NewHookDSN and
+// NewAsyncHookDSN. It's called for each new connection, so credentials can be
+// rotated without restarting the application. The returned credentials
+// override those of the DSN (an empty User keeps the DSN one).
+func WithCredentials(provider func(ctx context.Context) (Credentials, error)) Option {
+	return func(o *options) error {
+		o.credentials = provider
+		return nil
+	}
+}
+
+// NewHookDSN creates a hook connecting to the database of dsn, configured
+// with opts (see New).
+// The hook owns its connections: they're opened when needed, and reopened
+// after failures by database/sql. Call Close to close them.
+// The driver must be registered, eg. by importing github.com/lib/pq.
+func NewHookDSN(dsn string, opts ...Option) (*Hook, error) {
+	hook := NewHook(nil, nil)
+	if err := hook.openDSN(dsn, opts); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// NewAsyncHookDSN creates an asynchronous hook connecting to the database of
+// dsn, configured with opts (see NewHookDSN and NewAsync).
+func NewAsyncHookDSN(dsn string, opts ...Option) (*AsyncHook, error) {
+	hook := NewHook(nil, nil)
+	if err := hook.openDSN(dsn, opts); err != nil {
+		return nil, err
+	}
+	return newAsyncHook(hook), nil
+}
+
+// openDSN applies opts to hook, opens the database of dsn, and runs the
+// startup checks.
+func (hook *Hook) openDSN(dsn string, opts []Option) error {
+	o, err := hook.applyOptions(opts)
+	if err != nil {
+		return err
+	}
+	if o.driverName == "" {
+		o.driverName = "postgres"
+	}
+	// sql.Open fails if the driver isn't registered, and doesn't connect
+	db, err := sql.Open(o.driverName, dsn)
+	if err != nil {
+		return err
+	}
+	drv := db.Driver()
+	db.Close()
+
+	if o.tls != nil {
+		if dsn, err = withSettings(dsn, o.tls.settings()); err != nil {
+			return err
+		}
+	}
+
+	hook.db = sql.OpenDB(&dsnConnector{driver: drv, dsn: dsn, credentials: o.credentials})
+	if err := hook.startup(o); err != nil {
+		hook.db.Close()
+		return err
+	}
+	return nil
+}
+
+// dsnConnector opens connections to dsn, with the current credentials
+type dsnConnector struct {
+	driver      driver.Driver
+	dsn         string
+	credentials func(ctx context.Context) (Credentials, error)
+}
+
+func (c *dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsn := c.dsn
+	if c.credentials != nil {
+		creds, err := c.credentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if dsn, err = withCredentials(dsn, creds); err != nil {
+			return nil, err
+		}
+	}
+	if dc, ok := c.driver.(driver.DriverContext); ok {
+		connector, err := dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return connector.Connect(ctx)
+	}
+	return c.driver.Open(dsn)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// withCredentials returns dsn with the user and password of creds.
+func withCredentials(dsn string, creds Credentials) (string, error) {
+	if isURL(dsn) {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		user := creds.User
+		if user == "" && u.User != nil {
+			user = u.User.Username()
+		}
+		u.User = url.UserPassword(user, creds.Password)
+		return u.String(), nil
+	}
+	var settings [][2]string
+	if creds.User != "" {
+		settings = append(settings, [2]string{"user", creds.User})
+	}
+	return withSettings(dsn, append(settings, [2]string{"password", creds.Password}))
+}
+
+// isURL reports whether dsn is an URL, rather than a list of key=value
+// settings.
+func isURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// quoteSetting quotes the value of a key=value setting.
+func quoteSetting(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
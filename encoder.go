@@ -0,0 +1,79 @@
+package pglogrus
+
+import (
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A FieldEncoder converts a field value into the representation stored in
+// PostgreSQL.
+// It returns false if it doesn't handle the value, so the next encoder (or the
+// default JSON encoding) is used instead.
+type FieldEncoder func(v interface{}) (interface{}, bool)
+
+// DurationEncoder encodes time.Duration values as an integer number of unit,
+// ie. DurationEncoder(time.Millisecond) stores durations in milliseconds
+// instead of nanoseconds.
+func DurationEncoder(unit time.Duration) FieldEncoder {
+	return func(v interface{}) (interface{}, bool) {
+		d, ok := v.(time.Duration)
+		if !ok {
+			return nil, false
+		}
+		return int64(d / unit), true
+	}
+}
+
+// TimeEncoder encodes time.Time values in UTC using layout.
+// time.RFC3339Nano produces values that can be cast to a timestamptz column.
+func TimeEncoder(layout string) FieldEncoder {
+	return func(v interface{}) (interface{}, bool) {
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, false
+		}
+		return t.UTC().Format(layout), true
+	}
+}
+
+// IPEncoder encodes net.IP, net.IPAddr and net.IPNet values using the text
+// representation expected by the PostgreSQL inet type.
+func IPEncoder() FieldEncoder {
+	return func(v interface{}) (interface{}, bool) {
+		switch ip := v.(type) {
+		case net.IP:
+			return ip.String(), true
+		case net.IPAddr:
+			return ip.IP.String(), true
+		case *net.IPAddr:
+			return ip.IP.String(), true
+		case net.IPNet:
+			return ip.String(), true
+		case *net.IPNet:
+			return ip.String(), true
+		}
+		return nil, false
+	}
+}
+
+// AddFieldEncoder adds encoders applied to every field value of the entries.
+// For each value, the first encoder handling it is used.
+func (hook *Hook) AddFieldEncoder(encoders ...FieldEncoder) {
+	hook.AddFilter(encoderFilter(encoders))
+}
+
+func encoderFilter(encoders []FieldEncoder) filter {
+	return func(entry *logrus.Entry) *logrus.Entry {
+		for k, v := range entry.Data {
+			for _, enc := range encoders {
+				if encoded, ok := enc(v); ok {
+					entry.Data[k] = encoded
+					break
+				}
+			}
+		}
+		return entry
+	}
+}
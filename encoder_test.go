@@ -0,0 +1,151 @@
+package pglogrus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFieldEncoders(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	hook.AddFieldEncoder(
+		DurationEncoder(time.Millisecond),
+		TimeEncoder(time.RFC3339),
+		IPEncoder(),
+	)
+
+	at := time.Date(2019, 3, 18, 10, 0, 0, 0, time.FixedZone("CET", 3600))
+	entry := hook.newEntry(&logrus.Entry{
+		Data: logrus.Fields{
+			"latency":   1500 * time.Millisecond,
+			"at":        at,
+			"client_ip": net.ParseIP("10.0.0.1"),
+			"other":     "unchanged",
+		},
+	})
+
+	expected := logrus.Fields{
+		"latency":   int64(1500),
+		"at":        "2019-03-18T09:00:00Z",
+		"client_ip": "10.0.0.1",
+		"other":     "unchanged",
+	}
+	if !reflect.DeepEqual(expected, entry.Data) {
+		t.Errorf("Expected data to be %v, got %v\n", expected, entry.Data)
+	}
+}
+
+type money struct {
+	Amount   string
+	Currency string
+}
+
+type accountID int
+
+func TestRegisterFieldEncoder(t *testing.T) {
+	RegisterFieldEncoder(reflect.TypeOf(money{}), func(v interface{}) (interface{}, error) {
+		m := v.(money)
+		return json.RawMessage(fmt.Sprintf(`{"amount":%s,"currency":%q}`, m.Amount, m.Currency)), nil
+	})
+	RegisterFieldEncoder(reflect.TypeOf(accountID(0)), func(v interface{}) (interface{}, error) {
+		if v.(accountID) < 0 {
+			return nil, errors.New("negative ID")
+		}
+		return fmt.Sprintf("acct_%d", v), nil
+	})
+	defer RegisterFieldEncoder(reflect.TypeOf(money{}), nil)
+	defer RegisterFieldEncoder(reflect.TypeOf(accountID(0)), nil)
+
+	hook := NewHook(nil, map[string]interface{}{})
+	var errs []error
+	hook.ErrorHandler = func(event *ErrorEvent) {
+		errs = append(errs, event.Err)
+	}
+	hook.Table.Columns = []Column{{Name: "account", Field: "account", Type: "text"}}
+
+	entry := hook.newEntry(&logrus.Entry{Data: logrus.Fields{
+		"price":   money{Amount: "12345678901234567890.01", Currency: "EUR"},
+		"account": accountID(42),
+	}})
+	_, args, err := hook.table().insertStatement(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"price":{"amount":12345678901234567890.01,"currency":"EUR"}}`; string(args[2].([]byte)) != expected {
+		t.Errorf("Expected payload to be %s, got %s\n", expected, args[2])
+	}
+	if args[4] != "acct_42" {
+		t.Errorf("Expected account column to be %q, got %v\n", "acct_42", args[4])
+	}
+
+	hook.newEntry(&logrus.Entry{Data: logrus.Fields{"account": accountID(-1)}})
+	if len(errs) != 1 {
+		t.Errorf("Expected the encoding error to be reported, got %v\n", errs)
+	}
+}
+
+// decimal is a decimal type like shopspring/decimal.Decimal
+type decimal string
+
+func (d decimal) String() string {
+	return string(d)
+}
+
+func TestNumericEncoder(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	tests := map[string]struct {
+		value    interface{}
+		expected string
+	}{
+		"int":       {value: huge, expected: "123456789012345678901234567890"},
+		"rat":       {value: big.NewRat(12345, 8), expected: "1543.125"},
+		"rat_int":   {value: big.NewRat(10, 2), expected: "5"},
+		"rat_third": {value: big.NewRat(1, 3)},
+		"float":     {value: big.NewFloat(0.5), expected: "0.5"},
+		"float_inf": {value: new(big.Float).SetInf(false)},
+		"nil_int":   {value: (*big.Int)(nil)},
+		"nil_float": {value: (*big.Float)(nil)},
+		"nil_rat":   {value: (*big.Rat)(nil)},
+		"decimal":   {value: decimal("-19.99"), expected: "-19.99"},
+		"not_num":   {value: decimal("NaN")},
+		"other":     {value: 1.5},
+	}
+	for name, test := range tests {
+		encoded, err := NumericEncoder(test.value)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("%s: Expected an error, got %s\n", name, encoded)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw, ok := encoded.(json.RawMessage); !ok || string(raw) != test.expected {
+			t.Errorf("%s: Expected value to be %s, got %v\n", name, test.expected, encoded)
+		}
+	}
+
+	RegisterFieldEncoder(reflect.TypeOf(decimal("")), NumericEncoder)
+	defer RegisterFieldEncoder(reflect.TypeOf(decimal("")), nil)
+	table := TableConfig{Name: "logs", Columns: []Column{{Name: "amount", Field: "amount", Type: "numeric"}}}
+	hook := NewHook(nil, nil)
+	entry := hook.newEntry(&logrus.Entry{Data: logrus.Fields{"amount": decimal("1234567890123456789.99"), "fee": decimal("0.10")}})
+	_, args, err := table.insertStatement(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[4] != "1234567890123456789.99" {
+		t.Errorf("Expected amount column to be %q, got %v\n", "1234567890123456789.99", args[4])
+	}
+	if expected := `{"fee":0.10}`; string(args[2].([]byte)) != expected {
+		t.Errorf("Expected payload to be %s, got %s\n", expected, args[2])
+	}
+}
@@ -0,0 +1,160 @@
+package pglogrus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// An Encoding encodes the payload stored in message_data, instead of JSON
+// (see TableConfig.Encoding).
+// message_data is then a binary column (bytea), which is more compact and
+// faster to decode, but can't be queried by PostgreSQL: the entries can't be
+// read back by the hook (Export, Archive, Replay...).
+//
+// For protobuf, use the Marshal function of the protobuf package, with a
+// Payload returning the message of your descriptor:
+//
+//	hook.Table.Payload = func(entry *logrus.Entry, data logrus.Fields) interface{} {
+//		return &pb.Entry{Message: entry.Message, Fields: ...}
+//	}
+//	hook.Table.Encoding = func(v interface{}) ([]byte, error) {
+//		return proto.Marshal(v.(proto.Message))
+//	}
+type Encoding func(v interface{}) ([]byte, error)
+
+// binaryType returns the type of message_data when payloads are stored with
+// an Encoding.
+func (d Dialect) binaryType() string {
+	switch d {
+	case MySQL:
+		return "longblob"
+	case SQLite:
+		return "blob"
+	}
+	return "bytea"
+}
+
+// MarshalCBOR is an Encoding storing payloads in CBOR (RFC 8949).
+// Values are encoded like their JSON encoding (eg. times are RFC 3339 strings,
+// and structs use their json tags), and map keys are sorted, following the
+// deterministic encoding of CBOR.
+func MarshalCBOR(v interface{}) ([]byte, error) {
+	// Encode the JSON representation of v, to honor the json tags and
+	// Marshalers of the values
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var generic interface{}
+	if err := d.Decode(&generic); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeCBOR(&buf, generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+)
+
+// writeCBOR writes v, decoded by encoding/json with UseNumber, to buf.
+func writeCBOR(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if i < 0 {
+				writeCBORHead(buf, cborNegInt, uint64(-1-i))
+			} else {
+				writeCBORHead(buf, cborUint, uint64(i))
+			}
+			return nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			writeCBORHead(buf, cborUint, u)
+			return nil
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xfb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		writeCBORHead(buf, cborText, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		writeCBORHead(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if err := writeCBOR(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		// Deterministic order: shorter keys first, then bytewise
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		writeCBORHead(buf, cborMap, uint64(len(v)))
+		for _, k := range keys {
+			writeCBORHead(buf, cborText, uint64(len(k)))
+			buf.WriteString(k)
+			if err := writeCBOR(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("pglogrus: can't encode %T in CBOR", v)
+	}
+	return nil
+}
+
+// writeCBORHead writes the head of a data item of type major, with argument n.
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
@@ -0,0 +1,115 @@
+package pglogrus
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// workerKey is the context key of worker labels
+type workerKey struct{}
+
+// WithWorker returns a copy of ctx labelled with a logical worker name, so
+// entries logged by concurrent pipelines can be distinguished.
+// See Hook.AddWorkerLabel.
+func WithWorker(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, workerKey{}, label)
+}
+
+// Worker returns the worker label of ctx, if any.
+func Worker(ctx context.Context) (string, bool) {
+	label, ok := ctx.Value(workerKey{}).(string)
+	return label, ok
+}
+
+// AddWorkerLabel adds the worker label of the entry context (see WithWorker)
+// to the entry fields, as field.
+func (hook *Hook) AddWorkerLabel(field string) {
+	hook.AddContextField(field, workerKey{})
+}
+
+// AddContextField adds the value stored in the entry context under key to
+// the entry fields, as field.
+// The field isn't overwritten if it's already set on the entry.
+// Use a Column of the hook Table to store it in a dedicated column.
+func (hook *Hook) AddContextField(field string, key interface{}) {
+	hook.AddFilter(contextFieldFilter(field, key))
+}
+
+func contextFieldFilter(field string, key interface{}) filter {
+	return func(entry *logrus.Entry) *logrus.Entry {
+		if entry.Context == nil {
+			return entry
+		}
+		if _, ok := entry.Data[field]; ok {
+			return entry
+		}
+		if v := entry.Context.Value(key); v != nil {
+			entry.Data[field] = v
+		}
+		return entry
+	}
+}
+
+// LoggerField is the field holding the label of the logger of entries (see
+// LabelLogger).
+const LoggerField = "logger"
+
+// LabelLogger labels the entries of logger, when the hook is added to
+// several loggers of a process (eg. one per service or component).
+// The label is stored in the LoggerField: use a Column of the hook Table to
+// store it in a dedicated column.
+func (hook *Hook) LabelLogger(logger *logrus.Logger, label string) {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if hook.loggerLabels == nil {
+		hook.loggerLabels = map[*logrus.Logger]string{}
+		hook.filters = append(hook.filters, hook.loggerLabelFilter)
+	}
+	hook.loggerLabels[logger] = label
+}
+
+func (hook *Hook) loggerLabelFilter(entry *logrus.Entry) *logrus.Entry {
+	hook.mu.RLock()
+	label, ok := hook.loggerLabels[entry.Logger]
+	hook.mu.RUnlock()
+	if ok {
+		entry.Data[LoggerField] = label
+	}
+	return entry
+}
+
+// SessionField is the field holding the ID of the session of entries (see
+// NewSession).
+const SessionField = "session_id"
+
+// sessionKey is the context key of sessions
+type sessionKey struct{}
+
+// A Session groups the entries of a logical session, like a run of a batch
+// job or a CLI command, under the same ID (a uuid).
+type Session string
+
+// NewSession returns a new session. Entries logged with its Context (see
+// logrus.WithContext), or from its Entry, are stored with its ID in the
+// SessionField.
+// Use UUIDColumn(SessionField) to store it in a dedicated column.
+func (hook *Hook) NewSession() Session {
+	hook.mu.Lock()
+	if !hook.sessions {
+		hook.sessions = true
+		hook.filters = append(hook.filters, contextFieldFilter(SessionField, sessionKey{}))
+	}
+	hook.mu.Unlock()
+	return Session(newUUID())
+}
+
+// Context returns a copy of ctx carrying the session.
+func (s Session) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, string(s))
+}
+
+// Entry returns an entry of logger with the session ID.
+func (s Session) Entry(logger logrus.FieldLogger) *logrus.Entry {
+	return logger.WithField(SessionField, string(s))
+}
@@ -0,0 +1,297 @@
+package pglogrus
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type ctxKey string
+
+func TestAddContextField(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	hook.AddContextField("request_id", ctxKey("request_id"))
+
+	ctx := context.WithValue(context.Background(), ctxKey("request_id"), "abc")
+	tests := map[string]struct {
+		entry    *logrus.Entry
+		expected interface{}
+	}{
+		"from context": {
+			entry:    &logrus.Entry{Context: ctx, Data: logrus.Fields{}},
+			expected: "abc",
+		},
+		"explicit field": {
+			entry:    &logrus.Entry{Context: ctx, Data: logrus.Fields{"request_id": "def"}},
+			expected: "def",
+		},
+		"no context": {
+			entry:    &logrus.Entry{Data: logrus.Fields{}},
+			expected: nil,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			entry := hook.newEntry(test.entry)
+			if v := entry.Data["request_id"]; v != test.expected {
+				t.Errorf("Expected request_id to be %v, got %v\n", test.expected, v)
+			}
+		})
+	}
+}
+
+func TestAddWorkerLabel(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	hook.AddWorkerLabel("worker")
+
+	ctx := WithWorker(context.Background(), "importer-1")
+	if label, ok := Worker(ctx); !ok || label != "importer-1" {
+		t.Errorf("Expected worker label to be %q, got %q\n", "importer-1", label)
+	}
+
+	entry := hook.newEntry(&logrus.Entry{Context: ctx, Data: logrus.Fields{}})
+	if v := entry.Data["worker"]; v != "importer-1" {
+		t.Errorf("Expected worker to be %q, got %v\n", "importer-1", v)
+	}
+}
+
+func TestAddStackTrace(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	hook.AddStackTrace(logrus.ErrorLevel, "stack", time.Hour)
+
+	tests := []struct {
+		level    logrus.Level
+		expected bool
+	}{
+		{logrus.InfoLevel, false},
+		{logrus.ErrorLevel, true},
+		// Throttled
+		{logrus.ErrorLevel, false},
+	}
+	for i, test := range tests {
+		entry := hook.newEntry(&logrus.Entry{Level: test.level, Data: logrus.Fields{}})
+		s, ok := entry.Data["stack"].(string)
+		if ok != test.expected {
+			t.Errorf("Expected entry %d to have a stack: %v, got %v\n", i, test.expected, ok)
+		}
+		if ok && !strings.Contains(s, "TestAddStackTrace") {
+			t.Errorf("Expected stack to contain the caller, got %s\n", s)
+		}
+	}
+}
+
+func TestBuildInfoFields(t *testing.T) {
+	info := &debug.BuildInfo{
+		Main: debug.Module{Path: "example.com/app", Version: "v1.2.3"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: "4f3c2a1"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	expected := logrus.Fields{
+		BuildVersionField:  "v1.2.3",
+		BuildRevisionField: "4f3c2a1",
+		BuildModifiedField: true,
+	}
+	if fields := buildInfoFields(info); !reflect.DeepEqual(expected, fields) {
+		t.Errorf("Expected fields to be %v, got %v\n", expected, fields)
+	}
+
+	info = &debug.BuildInfo{Main: debug.Module{Path: "example.com/app", Version: "(devel)"}}
+	if fields := buildInfoFields(info); len(fields) != 0 {
+		t.Errorf("Expected no fields for a development build, got %v\n", fields)
+	}
+}
+
+func TestKubernetesFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pglogrus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	namespaceFile := filepath.Join(dir, "namespace")
+	if err := ioutil.WriteFile(namespaceFile, []byte("billing\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		env      map[string]string
+		expected logrus.Fields
+	}{
+		"downward API": {
+			env: map[string]string{
+				"KUBERNETES_SERVICE_HOST": "10.0.0.1",
+				"POD_NAMESPACE":           "payments",
+				"POD_NAME":                "api-7d9f",
+				"NODE_NAME":               "node-1",
+				"CONTAINER_NAME":          "api",
+			},
+			expected: logrus.Fields{
+				KubernetesNamespaceField: "payments",
+				KubernetesPodField:       "api-7d9f",
+				KubernetesNodeField:      "node-1",
+				KubernetesContainerField: "api",
+			},
+		},
+		"defaults": {
+			env: map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1", "HOSTNAME": "api-7d9f"},
+			expected: logrus.Fields{
+				KubernetesNamespaceField: "billing",
+				KubernetesPodField:       "api-7d9f",
+			},
+		},
+		"outside kubernetes": {
+			env: map[string]string{"HOSTNAME": "laptop"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			getenv := func(k string) string { return test.env[k] }
+			if fields := kubernetesFields(getenv, namespaceFile); !reflect.DeepEqual(test.expected, fields) {
+				t.Errorf("Expected fields to be %v, got %v\n", test.expected, fields)
+			}
+		})
+	}
+}
+
+func TestLabelLogger(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	billing, shipping, other := logrus.New(), logrus.New(), logrus.New()
+	hook.LabelLogger(billing, "billing")
+	hook.LabelLogger(shipping, "shipping")
+
+	tests := map[*logrus.Logger]interface{}{
+		billing:  "billing",
+		shipping: "shipping",
+		other:    nil,
+	}
+	for logger, expected := range tests {
+		entry := hook.newEntry(&logrus.Entry{Logger: logger, Data: logrus.Fields{}})
+		if label := entry.Data[LoggerField]; label != expected {
+			t.Errorf("Expected label to be %v, got %v\n", expected, label)
+		}
+	}
+}
+
+func TestLogID(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	hook.AddLogID()
+
+	parentID := NewLogID()
+	parent := hook.newEntry(logrus.WithField(LogIDField, parentID))
+	if parent.Data[LogIDField] != parentID {
+		t.Errorf("Expected log ID to be kept, got %v\n", parent.Data[LogIDField])
+	}
+
+	child := hook.newEntry(WithParent(logrus.StandardLogger(), parentID))
+	id, _ := child.Data[LogIDField].(string)
+	if _, ok := uuidValue(id); !ok || id == parentID {
+		t.Errorf("Expected a new log ID, got %q\n", id)
+	}
+	if child.Data[ParentLogIDField] != parentID {
+		t.Errorf("Expected parent log ID to be %v, got %v\n", parentID, child.Data[ParentLogIDField])
+	}
+}
+
+func TestNewSession(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	session := hook.NewSession()
+	if other := hook.NewSession(); other == session {
+		t.Error("Expected sessions to have different IDs")
+	}
+	if len(hook.filters) != 1 {
+		t.Errorf("Expected a single session filter, got %d filters\n", len(hook.filters))
+	}
+
+	entries := map[string]*logrus.Entry{
+		"context": logrus.WithContext(session.Context(context.Background())),
+		"entry":   session.Entry(logrus.StandardLogger()),
+	}
+	for name, e := range entries {
+		entry := hook.newEntry(e)
+		if id := entry.Data[SessionField]; id != string(session) {
+			t.Errorf("%s: Expected session ID to be %v, got %v\n", name, session, id)
+		}
+	}
+}
+
+func TestFiltersAddedWhileLogging(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	started, stop, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			hook.newEntry(&logrus.Entry{Data: logrus.Fields{}})
+			if i == 0 {
+				close(started)
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+	<-started
+	session := hook.NewSession()
+	hook.LabelLogger(logrus.StandardLogger(), "main")
+	close(stop)
+	<-done
+
+	entry := hook.newEntry(session.Entry(logrus.StandardLogger()))
+	if entry.Data[SessionField] != string(session) || entry.Data[LoggerField] != "main" {
+		t.Errorf("Expected the session and logger filters to be applied, got %v\n", entry.Data)
+	}
+}
+
+func TestAddCaller(t *testing.T) {
+	defer func(module string, prefixes []string) {
+		mainModule, gopathPrefixes = module, prefixes
+	}(mainModule, gopathPrefixes)
+	mainModule = "github.com/org/app"
+	gopathPrefixes = []string{"/home/me/go/src/", "/home/me/go/pkg/mod/"}
+
+	tests := []struct {
+		path     string
+		function string
+		opts     CallerOptions
+		expected string
+	}{
+		{"/home/me/go/src/github.com/org/app/pkg/file.go", "github.com/org/app/pkg.F", CallerOptions{}, "github.com/org/app/pkg/file.go"},
+		{"/home/me/go/src/github.com/x/y/src/pkg/file.go", "github.com/x/y/src/pkg.(*T).F", CallerOptions{}, "github.com/x/y/src/pkg/file.go"},
+		{"/home/me/go/src/github.com/x/y/src/pkg/file.go", "", CallerOptions{}, "github.com/x/y/src/pkg/file.go"},
+		{"/home/me/go/pkg/mod/github.com/org/lib@v1.0.0/file.go", "github.com/org/lib.F", CallerOptions{}, "github.com/org/lib@v1.0.0/file.go"},
+		{"/home/me/go/src/example.com/a.b/file.go", "example.com/a%2eb.F", CallerOptions{}, "example.com/a.b/file.go"},
+		{"/build/app/src/pkg/file.go", "github.com/org/app/src/pkg.F", CallerOptions{}, "github.com/org/app/src/pkg/file.go"},
+		{"/build/app/main.go", "main.main", CallerOptions{}, "/build/app/main.go"},
+		{"/build/app/pkg/file.go", "github.com/org/app/pkg.F", CallerOptions{TrimPrefixes: []string{"/build/app/"}}, "pkg/file.go"},
+		{"/build/app/pkg/file.go", "", CallerOptions{PathElements: 2}, "pkg/file.go"},
+		{"file.go", "", CallerOptions{PathElements: 2}, "file.go"},
+	}
+	for _, test := range tests {
+		if path := trimPath(test.path, test.function, test.opts); path != test.expected {
+			t.Errorf("Expected %s to be trimmed to %s, got %s\n", test.path, test.expected, path)
+		}
+	}
+
+	hook := NewHook(nil, map[string]interface{}{})
+	hook.AddCaller(CallerOptions{PathElements: 1})
+	entry := hook.newEntry(&logrus.Entry{
+		Data:   logrus.Fields{},
+		Caller: &runtime.Frame{File: "/build/app/main.go", Line: 12, Function: "main.main"},
+	})
+	expected := logrus.Fields{"file": "main.go:12", "func": "main.main"}
+	if !reflect.DeepEqual(expected, entry.Data) {
+		t.Errorf("Expected data to be %v, got %v\n", expected, entry.Data)
+	}
+}
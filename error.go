@@ -1,12 +1,72 @@
 package pglogrus
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
 
 // newMarshalableError builds an error which encodes its error message into JSON
 func newMarshalableError(err error) *marshalableError {
 	return &marshalableError{err}
 }
 
+// marshalableErrors returns v with its errors converted to marshalableError,
+// including errors nested in slices, arrays and maps up to depth levels.
+// v is returned as is if it doesn't contain any error.
+func marshalableErrors(v interface{}, depth int) interface{} {
+	switch v := v.(type) {
+	case nil, json.Marshaler:
+		return v
+	case error:
+		return newMarshalableError(v)
+	}
+	if depth <= 0 {
+		return v
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if !mayContainErrors(rv.Type().Elem()) {
+			return v
+		}
+		converted := make([]interface{}, rv.Len())
+		for i := range converted {
+			converted[i] = marshalableErrors(rv.Index(i).Interface(), depth-1)
+		}
+		return converted
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String || !mayContainErrors(rv.Type().Elem()) {
+			return v
+		}
+		converted := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			converted[iter.Key().String()] = marshalableErrors(iter.Value().Interface(), depth-1)
+		}
+		return converted
+	}
+	return v
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// mayContainErrors reports whether values of type t may be or contain errors.
+func mayContainErrors(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface, reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	}
+	return t.Implements(errorType)
+}
+
 // a marshalableError is an error that can be encoded into JSON
 type marshalableError struct {
 	err error
@@ -16,3 +76,135 @@ type marshalableError struct {
 func (m *marshalableError) MarshalJSON() ([]byte, error) {
 	return json.Marshal(m.err.Error())
 }
+
+var (
+	// ErrQueueFull is returned by AsyncHook.Fire when the queue is full,
+	// and the hook is NonBlocking.
+	ErrQueueFull = errors.New("pglogrus: queue is full, entry dropped")
+	// ErrFlushTimeout is returned by AsyncHook.FlushTimeout.
+	ErrFlushTimeout = errors.New("pglogrus: flush timed out")
+	// ErrFlushed is returned by AsyncHook.Sync and EndGroup once the hook
+	// is flushed.
+	ErrFlushed = errors.New("pglogrus: hook is flushed")
+)
+
+// An EntryError is the error of an entry which couldn't be written.
+type EntryError struct {
+	Entry *logrus.Entry
+	Err   error
+}
+
+func (e EntryError) Error() string {
+	return fmt.Sprintf("can't write entry (%v): %v", e.Entry, e.Err)
+}
+
+// Unwrap returns the error of the entry.
+func (e EntryError) Unwrap() error {
+	return e.Err
+}
+
+// A BatchError lists the entries of a transaction which couldn't be written.
+type BatchError struct {
+	Failed []EntryError
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Failed) == 1 {
+		return "pglogrus: " + e.Failed[0].Error()
+	}
+	return fmt.Sprintf("pglogrus: %d entries couldn't be written, first error: %v", len(e.Failed), e.Failed[0].Err)
+}
+
+// InternalField marks entries logged about the hook itself, for example from
+// an ErrorHandler. These entries are never stored by the hook, to avoid loops
+// when the hook diagnostics are logged with a logger using the hook.
+const InternalField = "pglogrus.internal"
+
+// Internal returns an entry of logger marked with InternalField.
+func Internal(logger logrus.FieldLogger) *logrus.Entry {
+	return logger.WithField(InternalField, true)
+}
+
+// An ErrorEvent describes an error which occurred in the hook, and is passed to
+// the hook ErrorHandler.
+type ErrorEvent struct {
+	Time time.Time
+	// Op is the operation which failed: "filter", "insert", "begin" (of a
+	// transaction), "batch", "checkpoint", "commit", "archive", "prune",
+	// "relay" (see RelayOutbox), "sink", "config" (see WatchConfig), "remote"
+	// (see RemoteHook) or "lag" (see AsyncHook.MaxLag).
+	Op string
+	// Err is the error. Errors of DB operations are *DBError.
+	Err error
+	// Entry is the entry concerned by the error, if any.
+	Entry *logrus.Entry
+}
+
+func (e *ErrorEvent) Error() string {
+	switch e.Op {
+	case "begin":
+		return fmt.Sprint("Can't create db transaction: ", e.Err)
+	case "commit":
+		return fmt.Sprint("Can't commit transaction: ", e.Err)
+	case "archive":
+		return fmt.Sprint("Can't archive entries: ", e.Err)
+	case "config":
+		return fmt.Sprint("Can't reload config: ", e.Err)
+	case "remote":
+		return fmt.Sprint("Can't ship entries: ", e.Err)
+	case "relay":
+		return fmt.Sprint("Can't relay outbox entries: ", e.Err)
+	case "prune":
+		return fmt.Sprint("Can't prune entries: ", e.Err)
+	case "batch":
+		return fmt.Sprint("Can't save batch: ", e.Err)
+	case "checkpoint":
+		return fmt.Sprint("Can't save checkpoint: ", e.Err)
+	case "sink":
+		return fmt.Sprint("Can't write entries to sink: ", e.Err)
+	case "lag":
+		return fmt.Sprint("Writing entries is late: ", e.Err)
+	case "insert":
+		return fmt.Sprintf("Can't insert entry (%v): %v", e.Entry, e.Err)
+	case "annotation":
+		return fmt.Sprint("Can't save annotation: ", e.Err)
+	case "ddl":
+		return fmt.Sprint("Can't add column: ", e.Err)
+	case "validate":
+		return fmt.Sprintf("Invalid entry (%v): %v", e.Entry, e.Err)
+	}
+	return fmt.Sprintf("Can't %s entry: %v", e.Op, e.Err)
+}
+
+// handleError reports event to the ErrorHandler, or to stderr if the hook
+// doesn't have any. The event is also stored in the ErrorTable, if any.
+func (hook *Hook) handleError(event *ErrorEvent) {
+	atomic.AddUint64(&hook.stats.errors, 1)
+	// Only errors of DB operations are classified
+	switch event.Op {
+	case "filter", "sink", "lag", "validate", "config":
+	default:
+		event.Err = newDBError(event.Err)
+	}
+	if hook.ErrorTable != "" {
+		hook.storeError(event)
+	}
+	if hook.ErrorHandler != nil {
+		hook.ErrorHandler(event)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "[pglogrus]", event)
+}
+
+// storeError inserts event in the ErrorTable.
+func (hook *Hook) storeError(event *ErrorEvent) {
+	var level interface{}
+	var message interface{}
+	if event.Entry != nil {
+		level, message = event.Entry.Level, event.Entry.Message
+	}
+	_, err := hook.db.Exec("INSERT INTO "+hook.ErrorTable+"(op, error, level, message, created_at) VALUES ($1,$2,$3,$4,$5);", event.Op, event.Err.Error(), level, message, event.Time)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[pglogrus] Can't store error:", err)
+	}
+}
@@ -0,0 +1,195 @@
+package pglogrus
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "error " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected ErrorClass
+	}{
+		{sqlStateError("40001"), RetryableError},
+		{sqlStateError("08006"), RetryableError},
+		{fmt.Errorf("wrapped: %w", sqlStateError("40P01")), RetryableError},
+		{sqlStateError("23505"), PermanentError},
+		{sqlStateError("42P01"), PermanentError},
+		{sqlStateError("XX000"), UnknownError},
+		{driver.ErrBadConn, RetryableError},
+		{errors.New("oops"), UnknownError},
+	}
+	for _, test := range tests {
+		if class := Classify(test.err); class != test.expected {
+			t.Errorf("Expected %v to be %v, got %v\n", test.err, test.expected, class)
+		}
+	}
+}
+
+func TestDBError(t *testing.T) {
+	cause := sqlStateError("23505")
+	err := newDBError(cause)
+
+	var dbErr *DBError
+	if !errors.As(err, &dbErr) {
+		t.Fatalf("Expected a *DBError, got %T\n", err)
+	}
+	if dbErr.Code != "23505" || dbErr.Class != PermanentError {
+		t.Errorf("Expected a permanent error 23505, got %v error %s\n", dbErr.Class, dbErr.Code)
+	}
+	if !errors.Is(err, cause) {
+		t.Error("Expected the DB error to wrap its cause")
+	}
+	if newDBError(err) != err {
+		t.Error("Expected DB errors not to be wrapped twice")
+	}
+}
+
+func TestErrQueueFull(t *testing.T) {
+	// The worker isn't started, so the queue is never emptied
+	hook := newTestAsyncHook(nil, 1)
+	hook.NonBlocking = true
+
+	if err := hook.Fire(&logrus.Entry{Data: logrus.Fields{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Fire(&logrus.Entry{Data: logrus.Fields{}}); err != ErrQueueFull {
+		t.Errorf("Expected error to be ErrQueueFull, got %v\n", err)
+	}
+	if expected := (Stats{Fired: 2, Queued: 1, Dropped: 1}); hook.Stats() != expected {
+		t.Errorf("Expected stats to be %+v, got %+v\n", expected, hook.Stats())
+	}
+}
+
+func TestShedLevels(t *testing.T) {
+	// The worker isn't started, so the queue is never emptied
+	hook := &AsyncHook{Hook: NewHook(nil, map[string]interface{}{}), buf: make(chan *logrus.Entry, 10), running: true}
+	hook.ShedLevels = DefaultShedLevels()
+
+	for i := 0; i < 10; i++ {
+		for _, level := range []logrus.Level{logrus.TraceLevel, logrus.DebugLevel, logrus.InfoLevel, logrus.WarnLevel} {
+			if len(hook.buf) == cap(hook.buf) {
+				break
+			}
+			if err := hook.Fire(&logrus.Entry{Level: level, Data: logrus.Fields{}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	queued := map[logrus.Level]int{}
+	for len(hook.buf) > 0 {
+		queued[(<-hook.buf).Level]++
+	}
+	// Trace entries are shed from 5 queued entries, Debug from 7 and Info
+	// from 9
+	expected := map[logrus.Level]int{logrus.TraceLevel: 2, logrus.DebugLevel: 2, logrus.InfoLevel: 3, logrus.WarnLevel: 3}
+	if !reflect.DeepEqual(expected, queued) {
+		t.Errorf("Expected queued entries to be %v, got %v\n", expected, queued)
+	}
+	stats := hook.Stats()
+	if stats.Shed[logrus.TraceLevel] == 0 || stats.Shed[logrus.WarnLevel] != 0 {
+		t.Errorf("Expected Trace entries to be shed, and Warning entries not to be, got %v\n", stats.Shed)
+	}
+}
+
+func TestQueueTTL(t *testing.T) {
+	hook := &AsyncHook{Hook: NewHook(nil, map[string]interface{}{})}
+	hook.QueueTTL = map[logrus.Level]time.Duration{logrus.DebugLevel: time.Minute}
+
+	now := time.Now()
+	batch := []*logrus.Entry{
+		{Level: logrus.DebugLevel, Message: "stale", Time: now.Add(-2 * time.Minute)},
+		{Level: logrus.DebugLevel, Message: "fresh", Time: now},
+		{Level: logrus.ErrorLevel, Message: "error", Time: now.Add(-time.Hour)},
+	}
+	var messages []string
+	for _, entry := range hook.dropExpired(batch) {
+		messages = append(messages, entry.Message)
+	}
+	if expected := []string{"fresh", "error"}; !reflect.DeepEqual(expected, messages) {
+		t.Errorf("Expected kept entries to be %v, got %v\n", expected, messages)
+	}
+	if stats := hook.Stats(); stats.Expired != 1 || stats.Dropped != 1 {
+		t.Errorf("Expected 1 entry to be expired and dropped, got %+v\n", stats)
+	}
+	if batch[0].Message != "stale" {
+		t.Error("Expected batch not to be modified")
+	}
+}
+
+func TestLag(t *testing.T) {
+	var events []*ErrorEvent
+	hook := newTestAsyncHook(nil, 1)
+	hook.MaxLag = time.Minute
+	hook.ErrorHandler = func(event *ErrorEvent) {
+		events = append(events, event)
+	}
+
+	hook.setOldestQueued(&logrus.Entry{Time: time.Now().Add(-time.Hour)})
+	if lag := hook.Stats().Lag; lag < time.Hour {
+		t.Errorf("Expected lag to be at least 1h, got %v\n", lag)
+	}
+	hook.checkLag()
+	hook.checkLag()
+	if len(events) != 1 || events[0].Op != "lag" {
+		t.Fatalf("Expected a lag event, got %v\n", events)
+	}
+
+	// Caught up
+	atomic.StoreInt64(&hook.queue.oldestQueued, 0)
+	hook.checkLag()
+	hook.setOldestQueued(&logrus.Entry{Time: time.Now().Add(-time.Hour)})
+	hook.checkLag()
+	if len(events) != 2 {
+		t.Errorf("Expected lag to be reported again, got %d events\n", len(events))
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	cause := errors.New("oops")
+	err := &BatchError{Failed: []EntryError{
+		{Entry: &logrus.Entry{Message: "1"}, Err: cause},
+		{Entry: &logrus.Entry{Message: "2"}, Err: cause},
+	}}
+	if expected := "pglogrus: 2 entries couldn't be written, first error: oops"; err.Error() != expected {
+		t.Errorf("Expected error to be %q, got %q\n", expected, err.Error())
+	}
+	if !errors.Is(err.Failed[0], cause) {
+		t.Error("Expected entry errors to wrap their cause")
+	}
+}
+
+func TestNestedErrors(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	oops := errors.New("oops")
+	entry := hook.newEntry(&logrus.Entry{Data: logrus.Fields{
+		logrus.ErrorKey: oops,
+		"errors":        []error{oops, nil},
+		"byField":       map[string]error{"name": oops},
+		"nested":        map[string][]error{"name": {oops}},
+		"tooDeep":       [][][][]error{{{{oops}}}},
+		"bytes":         []byte("ab"),
+	}})
+
+	data, err := json.Marshal(entry.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"byField":{"name":"oops"},"bytes":"YWI=","error":"oops","errors":["oops",null],"nested":{"name":["oops"]},"tooDeep":[[[[{}]]]]}`
+	if string(data) != expected {
+		t.Errorf("Expected data to be %s, got %s\n", expected, data)
+	}
+}
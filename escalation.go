@@ -0,0 +1,164 @@
+package pglogrus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// An Escalation is raised by an EscalationSink when too many severe entries
+// were committed.
+type Escalation struct {
+	// Count is the number of severe entries during Window.
+	Count  int
+	Window time.Duration
+	// Last is the last severe entry.
+	Last *logrus.Entry
+}
+
+// A WebhookPayload returns the JSON payload of the webhook called for an
+// escalation.
+type WebhookPayload func(Escalation) interface{}
+
+// SlackPayload is the payload of Slack incoming webhooks.
+func SlackPayload(e Escalation) interface{} {
+	return map[string]interface{}{
+		"text": fmt.Sprintf(":rotating_light: %d %s entries in the last %s. Last one: %s", e.Count, e.Last.Level, e.Window, e.Last.Message),
+	}
+}
+
+// PagerDutyPayload returns the payload of the PagerDuty Events API (v2),
+// triggering an alert for the integration of routingKey.
+func PagerDutyPayload(routingKey string) WebhookPayload {
+	return func(e Escalation) interface{} {
+		return map[string]interface{}{
+			"routing_key":  routingKey,
+			"event_action": "trigger",
+			"payload": map[string]interface{}{
+				"summary":        fmt.Sprintf("%d %s entries in the last %s: %s", e.Count, e.Last.Level, e.Window, e.Last.Message),
+				"source":         "pglogrus",
+				"severity":       pagerDutySeverity(e.Last.Level),
+				"timestamp":      e.Last.Time.Format(time.RFC3339),
+				"custom_details": e.Last.Data,
+			},
+		}
+	}
+}
+
+func pagerDutySeverity(level logrus.Level) string {
+	if level <= logrus.FatalLevel {
+		return "critical"
+	}
+	return "error"
+}
+
+// EscalationConfig configures an EscalationSink.
+type EscalationConfig struct {
+	// URL of the webhook, called with a POST request.
+	URL string
+	// Payload is the payload of the webhook, SlackPayload by default.
+	Payload WebhookPayload
+	// Level is the least severe level of the entries counted, ErrorLevel if
+	// nil. It's a pointer so that PanicLevel, the zero Level, can be set.
+	Level *logrus.Level
+	// The webhook is called when Threshold entries were committed during
+	// Window (according to the time of the entries).
+	Threshold int
+	Window    time.Duration
+	// Cooldown is the minimum time between two calls, Window by default.
+	Cooldown time.Duration
+	// Client is http.DefaultClient by default.
+	Client *http.Client
+}
+
+// EscalationSink returns a sink calling a webhook when severe entries exceed
+// a rate threshold, so basic alerting works with only the hook and
+// PostgreSQL:
+//
+//	hook.AddSink(pglogrus.EscalationSink(pglogrus.EscalationConfig{
+//		URL:       slackWebhookURL,
+//		Threshold: 10,
+//		Window:    time.Minute,
+//	}))
+//
+// Failing calls are reported like other sink errors.
+func EscalationSink(config EscalationConfig) SecondarySink {
+	if config.Payload == nil {
+		config.Payload = SlackPayload
+	}
+	level := logrus.ErrorLevel
+	if config.Level != nil {
+		level = *config.Level
+	}
+	if config.Cooldown == 0 {
+		config.Cooldown = config.Window
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	return &escalationSink{config: config, level: level}
+}
+
+type escalationSink struct {
+	config EscalationConfig
+	// level is the least severe level of the entries counted
+	level logrus.Level
+
+	mu sync.Mutex
+	// times of the severe entries of the window
+	times    []time.Time
+	notified time.Time
+}
+
+func (s *escalationSink) Write(entries []*logrus.Entry) error {
+	escalation, ok := s.escalate(entries)
+	if !ok {
+		return nil
+	}
+	body, err := json.Marshal(s.config.Payload(escalation))
+	if err != nil {
+		return err
+	}
+	resp, err := s.config.Client.Post(s.config.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pglogrus: escalation webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// escalate counts the severe entries, and returns the escalation to notify,
+// if any.
+func (s *escalationSink) escalate(entries []*logrus.Entry) (Escalation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last *logrus.Entry
+	for _, entry := range entries {
+		if entry.Level <= s.level {
+			s.times = append(s.times, entry.Time)
+			last = entry
+		}
+	}
+	if last == nil {
+		return Escalation{}, false
+	}
+	start := last.Time.Add(-s.config.Window)
+	i := 0
+	for i < len(s.times) && s.times[i].Before(start) {
+		i++
+	}
+	s.times = s.times[i:]
+	if len(s.times) < s.config.Threshold || last.Time.Sub(s.notified) < s.config.Cooldown {
+		return Escalation{}, false
+	}
+	s.notified = last.Time
+	return Escalation{Count: len(s.times), Window: s.config.Window, Last: last}, true
+}
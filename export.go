@@ -0,0 +1,115 @@
+package pglogrus
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ExportFormat is the file format of exported entries.
+type ExportFormat int
+
+const (
+	// NDJSON exports one JSON object per line, like Archive.
+	NDJSON ExportFormat = iota
+	// CSV exports the created_at, level, message and message_data columns,
+	// with a header.
+	CSV
+	// RFC5424 exports syslog lines, see RFC5424Formatter.
+	RFC5424
+	// Journald exports the journal export format, see JournaldFormatter.
+	Journald
+	// Parquet exports the created_at, level, message and message_data
+	// columns, like CSV, in a Parquet file (uncompressed). Entries are
+	// written by row groups of 10000 entries, buffered in memory.
+	Parquet
+)
+
+// ExportOptions configure Hook.Export.
+type ExportOptions struct {
+	Format ExportFormat
+	// From and To restrict the export to entries created in [From, To), if
+	// set.
+	From, To time.Time
+	// Formatter writes the entries, instead of Format, if set.
+	Formatter logrus.Formatter
+}
+
+// Export writes the entries stored in the table to w, ordered by time.
+func (hook *Hook) Export(ctx context.Context, opts ExportOptions, w io.Writer) error {
+	var write func(*logrus.Entry) error
+	done := func() error { return nil }
+	formatter := opts.Formatter
+	if formatter == nil {
+		switch opts.Format {
+		case RFC5424:
+			formatter = &RFC5424Formatter{}
+		case Journald:
+			formatter = &JournaldFormatter{}
+		}
+	}
+	switch {
+	case formatter != nil:
+		write = func(entry *logrus.Entry) error {
+			line, err := formatter.Format(entry)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(line)
+			return err
+		}
+	case opts.Format == NDJSON:
+		write = func(entry *logrus.Entry) error {
+			return WriteNDJSON(w, entry)
+		}
+	case opts.Format == CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"created_at", "level", "message", "message_data"}); err != nil {
+			return err
+		}
+		write = func(entry *logrus.Entry) error {
+			data, err := json.Marshal(entry.Data)
+			if err != nil {
+				return err
+			}
+			return cw.Write([]string{entry.Time.Format(time.RFC3339Nano), entry.Level.String(), entry.Message, string(data)})
+		}
+		done = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case opts.Format == Parquet:
+		pw := newParquetWriter(w)
+		write = pw.write
+		done = pw.close
+	default:
+		return fmt.Errorf("pglogrus: unsupported export format %d", opts.Format)
+	}
+
+	table := hook.table()
+	c := conditions{dialect: table.Dialect}
+	c.timeRange(opts.From, opts.To)
+	rows, err := hook.db.QueryContext(ctx, table.SelectQuery(c.where()+" ORDER BY created_at"), c.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry, err := table.scanEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := write(entry); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return done()
+}
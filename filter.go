@@ -0,0 +1,155 @@
+package pglogrus
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A Filter selects stored entries by their fields, for Query, Aggregate and
+// Prune, with the PostgreSQL Dialect only. Filters are built with Field:
+//
+//	pglogrus.Field("user.id").Eq("123")
+//	pglogrus.Field("latency_ms").Gt(500)
+type Filter struct {
+	// sql returns the SQL condition of the filter, adding its arguments to c
+	sql func(t *TableConfig, c *conditions) string
+}
+
+// FieldPath is a field of the stored entries: a column, or a key of
+// message_data. Dots separate the keys of nested objects (eg. "user.id").
+type FieldPath string
+
+// Field returns the field path, to build filters.
+func Field(path string) FieldPath {
+	return FieldPath(path)
+}
+
+// Eq selects the entries whose field is equal to v.
+func (f FieldPath) Eq(v interface{}) Filter {
+	return f.compare("=", v, false)
+}
+
+// Ne selects the entries whose field is set and not equal to v.
+func (f FieldPath) Ne(v interface{}) Filter {
+	return f.compare("<>", v, false)
+}
+
+// Gt selects the entries whose field is greater than the number n.
+// Like the other numeric comparisons, it skips the entries whose field isn't
+// a number.
+func (f FieldPath) Gt(n float64) Filter {
+	return f.compare(">", n, true)
+}
+
+// Gte selects the entries whose field is greater than or equal to the number
+// n.
+func (f FieldPath) Gte(n float64) Filter {
+	return f.compare(">=", n, true)
+}
+
+// Lt selects the entries whose field is less than the number n.
+func (f FieldPath) Lt(n float64) Filter {
+	return f.compare("<", n, true)
+}
+
+// Lte selects the entries whose field is less than or equal to the number n.
+func (f FieldPath) Lte(n float64) Filter {
+	return f.compare("<=", n, true)
+}
+
+// In selects the entries whose field is equal to one of values.
+func (f FieldPath) In(values ...interface{}) Filter {
+	return Filter{sql: func(t *TableConfig, c *conditions) string {
+		if len(values) == 0 {
+			return "FALSE"
+		}
+		expr, column := f.expression(t)
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			placeholders[i] = c.arg(f.value(v, column))
+		}
+		return fmt.Sprintf("%s IN (%s)", expr, strings.Join(placeholders, ", "))
+	}}
+}
+
+// Exists selects the entries having the field, with a non-null value.
+func (f FieldPath) Exists() Filter {
+	return Filter{sql: func(t *TableConfig, c *conditions) string {
+		expr, _ := f.expression(t)
+		return expr + " IS NOT NULL"
+	}}
+}
+
+// Or selects the entries matching any of filters.
+func Or(filters ...Filter) Filter {
+	return Filter{sql: func(t *TableConfig, c *conditions) string {
+		if len(filters) == 0 {
+			return "FALSE"
+		}
+		sql := make([]string, len(filters))
+		for i, filter := range filters {
+			sql[i] = "(" + filter.sql(t, c) + ")"
+		}
+		return "(" + strings.Join(sql, " OR ") + ")"
+	}}
+}
+
+// compare returns the filter comparing the field to v with op, as numbers if
+// numeric.
+func (f FieldPath) compare(op string, v interface{}, numeric bool) Filter {
+	return Filter{sql: func(t *TableConfig, c *conditions) string {
+		expr, column := f.expression(t)
+		if numeric && !column {
+			// Values of message_data are text, and may not be numbers
+			return fmt.Sprintf("%s %s %s", numericExpression(expr), op, c.arg(v))
+		}
+		return fmt.Sprintf("%s %s %s", expr, op, c.arg(f.value(v, column)))
+	}}
+}
+
+// numberPattern matches the text of the numbers of message_data.
+const numberPattern = `^[-+]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?$`
+
+// numericExpression returns the SQL expression of the text expr as a number,
+// or NULL if it isn't one, instead of failing the whole query.
+func numericExpression(expr string) string {
+	return fmt.Sprintf("CASE WHEN (%s) ~ '%s' THEN (%[1]s)::double precision END", expr, numberPattern)
+}
+
+// expression returns the SQL expression of the field, and whether it's stored
+// in its own column.
+func (f FieldPath) expression(t *TableConfig) (string, bool) {
+	expr := t.fieldExpression(string(f))
+	for _, c := range t.Columns {
+		if c.Name == expr {
+			return expr, true
+		}
+	}
+	return expr, false
+}
+
+// value returns the argument comparing v to the field: the text of v in
+// message_data (eg. "123" for 123), or v for columns.
+func (f FieldPath) value(v interface{}, column bool) interface{} {
+	if column {
+		return v
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// filter adds the conditions of filters to c.
+func (c *conditions) filter(t *TableConfig, filters []Filter) {
+	for _, f := range filters {
+		if f.sql != nil {
+			c.add(f.sql(t, c))
+		}
+	}
+}
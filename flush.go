@@ -0,0 +1,166 @@
+package pglogrus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// completion tracks the entries queued by an AsyncHook, until the batch
+// taking them is acknowledged, whether they were written or not.
+// Its zero value is ready to use.
+type completion struct {
+	mu        sync.Mutex
+	queued    uint64
+	acked     uint64
+	persisted uint64
+	failed    uint64
+	// changed is closed when entries are acknowledged
+	changed chan struct{}
+}
+
+// add records a queued entry.
+func (c *completion) add() {
+	c.mu.Lock()
+	c.queued++
+	c.mu.Unlock()
+}
+
+// cancel records that an entry recorded by add wasn't queued after all.
+func (c *completion) cancel() {
+	c.mu.Lock()
+	c.queued--
+	c.mu.Unlock()
+}
+
+// ack acknowledges a batch of n entries taken from the queue, with the number
+// of entries persisted, and which failed to be written. The other entries
+// were ignored (see AsyncHook.OnBatch) or dropped (see RateLimit).
+func (c *completion) ack(n, persisted, failed int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.acked += uint64(n)
+	c.persisted += uint64(persisted)
+	c.failed += uint64(failed)
+	if c.changed != nil {
+		close(c.changed)
+		c.changed = nil
+	}
+}
+
+// snapshot returns the number of queued entries, and the result of the
+// acknowledged ones.
+func (c *completion) snapshot() (uint64, FlushResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.queued, FlushResult{Persisted: int(c.persisted), Failed: int(c.failed)}
+}
+
+// wait waits for the first target queued entries to be acknowledged.
+func (c *completion) wait(ctx context.Context, target uint64) error {
+	for {
+		c.mu.Lock()
+		if c.acked >= target {
+			c.mu.Unlock()
+			return nil
+		}
+		if c.changed == nil {
+			c.changed = make(chan struct{})
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// FlushResult reports the entries written during a flush.
+type FlushResult struct {
+	// Persisted is the number of entries committed to the DB.
+	Persisted int
+	// Failed is the number of entries which couldn't be written.
+	Failed int
+}
+
+// FlushContext is like Flush, and reports how many entries were persisted
+// while flushing, including by the destinations and pipelines of the hook.
+// If ctx is done before the queue is empty, it returns the result so far and
+// the error of ctx: the hook keeps logging, and can be flushed again.
+func (hook *AsyncHook) FlushContext(ctx context.Context) (FlushResult, error) {
+	var result FlushResult
+	for _, dest := range hook.children() {
+		destResult, err := dest.FlushContext(ctx)
+		result.Persisted += destResult.Persisted
+		result.Failed += destResult.Failed
+		if err != nil {
+			return result, err
+		}
+	}
+	if !hook.active() {
+		return result, nil
+	}
+	end := hook.trace("flush")
+	target, start := hook.pending.snapshot()
+	// Write the queued entries without waiting for the flush interval
+	var fast bool
+	select {
+	case hook.fast <- true:
+		fast = true
+	case <-hook.stopped:
+	case <-ctx.Done():
+	}
+	err := hook.pending.wait(ctx, target)
+	if err != nil && fast {
+		// The hook keeps logging: restore the flush interval, without
+		// waiting for the worker
+		go func() {
+			select {
+			case hook.fast <- false:
+			case <-hook.stopped:
+			}
+		}()
+	}
+	if err == nil {
+		select {
+		case hook.flush <- true:
+			<-hook.flush
+		case <-hook.stopped:
+			// Flushed concurrently
+		}
+		hook.waitSinks()
+	}
+	_, now := hook.pending.snapshot()
+	result.Persisted += now.Persisted - start.Persisted
+	result.Failed += now.Failed - start.Failed
+	end(TraceInfo{Entries: now.Persisted - start.Persisted, Failed: now.Failed - start.Failed, Err: err})
+	return result, err
+}
+
+// fastFlush starts (or ends, if start is false) ticking every 100ms, until
+// the last FlushContext in progress ends.
+func (hook *AsyncHook) fastFlush(start bool) {
+	if start {
+		hook.fastFlushes++
+	} else {
+		hook.fastFlushes--
+	}
+	switch {
+	case hook.fastFlushes > 0 && hook.fastTicker == nil:
+		hook.fastTicker = time.NewTicker(100 * time.Millisecond)
+	case hook.fastFlushes == 0 && hook.fastTicker != nil:
+		hook.fastTicker.Stop()
+		hook.fastTicker = nil
+	}
+}
+
+// tick returns the channel of the ticker of the worker.
+func (hook *AsyncHook) tick() <-chan time.Time {
+	if hook.fastTicker != nil {
+		return hook.fastTicker.C
+	}
+	return hook.ticker.C
+}
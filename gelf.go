@@ -0,0 +1,53 @@
+package pglogrus
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A Payload returns the value stored in the message_data column for entry.
+// data are the entry fields, without the fields stored in dedicated columns.
+// See TableConfig.Payload.
+type Payload func(entry *logrus.Entry, data logrus.Fields) interface{}
+
+// GELFPayload stores entries shaped as GELF 1.1 messages (Graylog Extended
+// Log Format): the first line of the message is the short_message, the whole
+// message is the full_message (if it has several lines), and fields are
+// prefixed with an underscore. host is the host of the messages.
+func GELFPayload(host string) Payload {
+	return func(entry *logrus.Entry, data logrus.Fields) interface{} {
+		msg := map[string]interface{}{
+			"version":       "1.1",
+			"host":          host,
+			"short_message": entry.Message,
+			"timestamp":     float64(entry.Time.UnixNano()/1000) / 1e6,
+			"level":         SyslogSeverity[entry.Level],
+		}
+		if i := strings.IndexByte(entry.Message, '\n'); i >= 0 {
+			msg["short_message"] = entry.Message[:i]
+			msg["full_message"] = entry.Message
+		}
+		for k, v := range data {
+			msg[gelfFieldName(k)] = v
+		}
+		return msg
+	}
+}
+
+// gelfFieldName returns the name of the GELF additional field storing the
+// field k: k prefixed with an underscore, and containing only letters,
+// digits, underscores, dashes and dots. "_id" is reserved.
+func gelfFieldName(k string) string {
+	k = "_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, k)
+	if k == "_id" {
+		k = "_id_"
+	}
+	return k
+}
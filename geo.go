@@ -0,0 +1,168 @@
+package pglogrus
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// geoType matches the PostGIS types of columns, like "geography(Point,4326)"
+var geoType = regexp.MustCompile(`^(geometry|geography)\((\w+),\s*(\d+)\)$`)
+
+// PointColumn returns an indexed PostGIS column storing the point of the lat
+// and lon fields (numbers), in the spatial reference system srid (4326 for
+// GPS coordinates). typ is "geometry" or "geography".
+// The fields are kept in message_data if they aren't numbers.
+func PointColumn(name, typ string, srid int, lat, lon string) Column {
+	return Column{Name: name, Type: fmt.Sprintf("%s(Point,%d)", typ, srid), Fields: []string{lat, lon}, Index: true}
+}
+
+// GeoJSONColumn returns an indexed PostGIS column storing the GeoJSON
+// geometry of field (a Point, LineString, Polygon, their Multi variants, or a
+// Feature with one of them), in the spatial reference system srid. typ is
+// "geometry" or "geography".
+// The field is kept in message_data if it isn't a valid geometry.
+func GeoJSONColumn(name, typ string, srid int, field string) Column {
+	return Column{Name: name, Type: fmt.Sprintf("%s(Geometry,%d)", typ, srid), Field: field, Index: true}
+}
+
+// srid returns the SRID of a PostGIS column, or false if it's not one.
+func (c Column) srid() (string, bool) {
+	m := geoType.FindStringSubmatch(c.Type)
+	if m == nil {
+		return "", false
+	}
+	return m[3], true
+}
+
+// indexMethod returns the method of the index of the column: GiST for
+// PostGIS columns, the default one otherwise.
+func (c Column) indexMethod() string {
+	if _, ok := c.srid(); ok {
+		return "gist"
+	}
+	return ""
+}
+
+// point returns the EWKT of the point of the lat and lon fields of data, and
+// removes them from data. It returns nil if they aren't valid.
+func (c Column) point(data logrus.Fields, srid string) interface{} {
+	lat, ok := coordinate(data[c.Fields[0]])
+	if !ok {
+		return nil
+	}
+	lon, ok := coordinate(data[c.Fields[1]])
+	if !ok {
+		return nil
+	}
+	delete(data, c.Fields[0])
+	delete(data, c.Fields[1])
+	return fmt.Sprintf("SRID=%s;POINT(%s %s)", srid, lon, lat)
+}
+
+// coordinate formats v, if it's a number.
+func coordinate(v interface{}) (string, bool) {
+	var f float64
+	switch n := v.(type) {
+	case float64:
+		f = n
+	case float32:
+		f = float64(n)
+	case int:
+		f = float64(n)
+	case int64:
+		f = float64(n)
+	case json.Number:
+		var err error
+		if f, err = n.Float64(); err != nil {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), true
+}
+
+// geoJSONValue returns the EWKT of the GeoJSON geometry v, and whether it's
+// valid.
+func geoJSONValue(v interface{}, srid string) (interface{}, bool) {
+	var b []byte
+	switch g := v.(type) {
+	case string:
+		b = []byte(g)
+	case []byte:
+		b = g
+	case json.RawMessage:
+		b = g
+	default:
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			return nil, false
+		}
+	}
+	var geometry struct {
+		Type        string
+		Coordinates interface{}
+		Geometry    *json.RawMessage
+	}
+	if err := json.Unmarshal(b, &geometry); err != nil {
+		return nil, false
+	}
+	if geometry.Type == "Feature" && geometry.Geometry != nil {
+		return geoJSONValue(*geometry.Geometry, srid)
+	}
+	depths := map[string]int{
+		"Point":           0,
+		"LineString":      1,
+		"MultiPoint":      1,
+		"Polygon":         2,
+		"MultiLineString": 2,
+		"MultiPolygon":    3,
+	}
+	depth, ok := depths[geometry.Type]
+	if !ok {
+		return nil, false
+	}
+	wkt, ok := wktCoordinates(geometry.Coordinates, depth)
+	if !ok {
+		return nil, false
+	}
+	if depth == 0 {
+		wkt = "(" + wkt + ")"
+	}
+	return fmt.Sprintf("SRID=%s;%s%s", srid, strings.ToUpper(geometry.Type), wkt), true
+}
+
+// wktCoordinates returns the WKT of the GeoJSON coordinates v: a position
+// ("x y") at depth 0, and lists of coordinates of depth-1 otherwise.
+func wktCoordinates(v interface{}, depth int) (string, bool) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return "", false
+	}
+	parts := make([]string, len(list))
+	for i, item := range list {
+		if depth == 0 {
+			n, ok := coordinate(item)
+			if !ok {
+				return "", false
+			}
+			parts[i] = n
+			continue
+		}
+		if parts[i], ok = wktCoordinates(item, depth-1); !ok {
+			return "", false
+		}
+	}
+	if depth == 0 {
+		if len(parts) < 2 {
+			return "", false
+		}
+		return strings.Join(parts, " "), true
+	}
+	return "(" + strings.Join(parts, ",") + ")", true
+}
@@ -0,0 +1,115 @@
+package pglogrus
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GroupIDField is the field holding the ID of the group of an entry (see
+// BeginGroup).
+const GroupIDField = "pglogrus.group_id"
+
+// GroupIDColumn returns an indexed uuid column storing the ID of the group of
+// each entry (see BeginGroup).
+func GroupIDColumn(name string) Column {
+	return Column{Name: name, Field: GroupIDField, Type: "uuid", Index: true}
+}
+
+// ErrNoGroup is returned by EndGroup when the context doesn't have a group,
+// or when the group already ended.
+var ErrNoGroup = errors.New("pglogrus: no group in context")
+
+// group is a group of entries written in the same transaction.
+type group struct {
+	id string
+
+	mu      sync.Mutex
+	entries []*logrus.Entry
+	ended   bool
+}
+
+type groupKey struct{}
+
+// groupWrite is a group of entries sent to the worker of the AsyncHook.
+type groupWrite struct {
+	entries []*logrus.Entry
+	done    chan error
+}
+
+// BeginGroup returns a context grouping the entries logged with it (with
+// logrus.WithContext) until EndGroup is called: the entries of a group are
+// kept aside, and then written in the same transaction, all or nothing, with
+// the same GroupIDField (see GroupIDColumn).
+// It's useful to write all the entries of a request or job atomically, for
+// audit logs.
+//
+//	ctx = hook.BeginGroup(ctx)
+//	log.WithContext(ctx).Info("Order created")
+//	log.WithContext(ctx).Info("Payment captured")
+//	err := hook.EndGroup(ctx)
+func (hook *AsyncHook) BeginGroup(ctx context.Context) context.Context {
+	return context.WithValue(ctx, groupKey{}, &group{id: newUUID()})
+}
+
+// EndGroup writes the entries of the group of ctx in a transaction, and waits
+// for it to be committed. The transaction is rolled back if an entry can't be
+// inserted: it returns a *BatchError listing all the entries of the group,
+// and ErrFlushed once the hook is flushed.
+// Entries logged with ctx after EndGroup are written like other entries.
+func (hook *AsyncHook) EndGroup(ctx context.Context) error {
+	g, _ := ctx.Value(groupKey{}).(*group)
+	if g == nil {
+		return ErrNoGroup
+	}
+	g.mu.Lock()
+	entries, ended := g.entries, g.ended
+	g.entries, g.ended = nil, true
+	g.mu.Unlock()
+	if ended {
+		return ErrNoGroup
+	}
+	if len(entries) == 0 || !hook.running {
+		return nil
+	}
+	write := groupWrite{entries: entries, done: make(chan error, 1)}
+	select {
+	case hook.groups <- write:
+	case <-hook.stopped:
+		return ErrFlushed
+	}
+	return <-write.done
+}
+
+// grouped keeps entry aside if it belongs to a group which didn't end, and
+// reports whether it did.
+func grouped(entry *logrus.Entry) bool {
+	if entry.Context == nil {
+		return false
+	}
+	g, _ := entry.Context.Value(groupKey{}).(*group)
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.ended {
+		return false
+	}
+	entry.Data[GroupIDField] = g.id
+	g.entries = append(g.entries, entry)
+	return true
+}
+
+// writeGroup writes the entries of a group, and reports the result to
+// EndGroup.
+func (hook *AsyncHook) writeGroup(write groupWrite) {
+	failures := hook.writeTx(write.entries, 0, true)
+	if len(failures) > 0 {
+		write.done <- &BatchError{Failed: failures}
+		return
+	}
+	write.done <- nil
+}
@@ -0,0 +1,125 @@
+package pglogrus
+
+import (
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ignoreCause is the cause of ignored entries, counted in Stats.IgnoredBy.
+type ignoreCause int
+
+const (
+	ignoredSkip ignoreCause = iota
+	ignoredLevel
+	ignoredSampling
+	ignoredPredicate
+	ignoredFilter
+	ignoredBatch
+	ignoreCauseCount
+)
+
+// ignoreCauseNames are the labels of the causes in PrometheusHandler.
+var ignoreCauseNames = [ignoreCauseCount]string{"skip", "level", "sampling", "predicate", "filter", "batch"}
+
+// IgnoredCounts are the numbers of entries ignored by the hook, by cause.
+type IgnoredCounts struct {
+	// Skipped is the number of entries about the hook itself, or marked with
+	// Skip.
+	Skipped uint64
+	// Level is the number of entries less severe than the level of the hook
+	// (see WithLevel).
+	Level uint64
+	// Sampling is the number of entries sampled out (see Config.Sampling).
+	Sampling uint64
+	// Predicates is the number of entries ignored by predicates.
+	Predicates uint64
+	// Filters is the number of entries ignored by filters, including the
+	// filters panicking.
+	Filters uint64
+	// Batches is the number of entries ignored by AsyncHook.OnBatch.
+	Batches uint64
+}
+
+// ignore counts an entry ignored because of cause, and returns nil.
+func (hook *Hook) ignore(cause ignoreCause) *logrus.Entry {
+	atomic.AddUint64(&hook.stats.ignoredBy[cause], 1)
+	return nil
+}
+
+// ignoredBy returns the numbers of entries ignored by cause.
+func (hook *Hook) ignoredBy() IgnoredCounts {
+	load := func(cause ignoreCause) uint64 {
+		return atomic.LoadUint64(&hook.stats.ignoredBy[cause])
+	}
+	return IgnoredCounts{
+		Skipped:    load(ignoredSkip),
+		Level:      load(ignoredLevel),
+		Sampling:   load(ignoredSampling),
+		Predicates: load(ignoredPredicate),
+		Filters:    load(ignoredFilter),
+		Batches:    load(ignoredBatch),
+	}
+}
+
+// namedCount counts the entries ignored by a named filter or predicate.
+type namedCount struct {
+	name    string
+	ignored uint64
+}
+
+// namedCount returns the counter of the filters and predicates named name.
+func (hook *Hook) namedCount(name string) *namedCount {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	for _, c := range hook.stats.named {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &namedCount{name: name}
+	hook.stats.named = append(hook.stats.named, c)
+	return c
+}
+
+// AddNamedFilter adds a filter like AddFilter, counting the entries it
+// ignores under name (see IgnoredByFilter).
+// Filters and predicates with the same name share their counter.
+func (hook *Hook) AddNamedFilter(name string, fn filter) {
+	c := hook.namedCount(name)
+	hook.AddFilter(func(entry *logrus.Entry) *logrus.Entry {
+		newEntry := fn(entry)
+		if newEntry == nil {
+			atomic.AddUint64(&c.ignored, 1)
+		}
+		return newEntry
+	})
+}
+
+// AddNamedPredicate adds a predicate like AddPredicate, counting the entries
+// it ignores under name (see IgnoredByFilter), eg. to check ignore rules in
+// production:
+//
+//	hook.AddNamedPredicate("probes", pglogrus.Ignore(pglogrus.FieldEquals("probe", "liveness")))
+func (hook *Hook) AddNamedPredicate(name string, fn Predicate) {
+	c := hook.namedCount(name)
+	hook.AddPredicate(func(entry *logrus.Entry) bool {
+		if fn(entry) {
+			return true
+		}
+		atomic.AddUint64(&c.ignored, 1)
+		return false
+	})
+}
+
+// IgnoredByFilter returns the number of entries ignored by each named filter
+// and predicate, by name. ValidateFields is named "validate".
+func (hook *Hook) IgnoredByFilter() map[string]uint64 {
+	hook.mu.RLock()
+	defer hook.mu.RUnlock()
+	ignored := make(map[string]uint64, len(hook.stats.named))
+	for _, c := range hook.stats.named {
+		ignored[c.name] = atomic.LoadUint64(&c.ignored)
+	}
+	return ignored
+}
@@ -0,0 +1,93 @@
+package pglogrus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JournaldFormatter formats entries in the journal export format of systemd,
+// which can be imported with systemd-journal-remote. The entry fields are
+// exported as journal fields, with upper case names.
+type JournaldFormatter struct {
+	// Identifier is the SYSLOG_IDENTIFIER of the entries, if set.
+	Identifier string
+	// Severities maps levels to the PRIORITY of the entries,
+	// SyslogSeverity if nil.
+	Severities map[logrus.Level]int
+}
+
+// Format implements logrus.Formatter.
+func (f *JournaldFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	severities := f.Severities
+	if severities == nil {
+		severities = SyslogSeverity
+	}
+	priority, ok := severities[entry.Level]
+	if !ok {
+		priority = 7
+	}
+
+	var b bytes.Buffer
+	writeJournalField(&b, "__REALTIME_TIMESTAMP", fmt.Sprint(entry.Time.UnixNano()/1000))
+	writeJournalField(&b, "MESSAGE", entry.Message)
+	writeJournalField(&b, "PRIORITY", fmt.Sprint(priority))
+	if f.Identifier != "" {
+		writeJournalField(&b, "SYSLOG_IDENTIFIER", f.Identifier)
+	}
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := fieldString(entry.Data[k])
+		if err != nil {
+			return nil, err
+		}
+		writeJournalField(&b, journalFieldName(k), v)
+	}
+	// Entries are separated by an empty line
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// writeJournalField writes a field in the journal export format.
+// Values with line breaks are written in the binary form: the field name, a
+// line break, the value length as a little endian uint64, and the value.
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if strings.IndexByte(value, '\n') < 0 {
+		b.WriteString("=" + value + "\n")
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalFieldName returns k as a journal field name: upper case letters,
+// digits and underscores, not starting with an underscore (reserved to
+// trusted fields) or a digit, and at most 64 characters.
+func journalFieldName(k string) string {
+	k = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, k)
+	if k == "" || k[0] == '_' || (k[0] >= '0' && k[0] <= '9') {
+		k = "F" + k
+	}
+	if len(k) > 64 {
+		k = k[:64]
+	}
+	return k
+}
@@ -0,0 +1,269 @@
+package pglogrus
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JSONSchema is a JSON Schema the fields of entries must satisfy.
+// Only a subset of the specification is supported: the "type", "enum",
+// "properties", "required", "additionalProperties" (as a boolean), "items",
+// "minimum", "maximum", "minLength", "maxLength" and "pattern" keywords.
+// Other keywords are ignored.
+type JSONSchema struct {
+	Type                 interface{}            `json:"type,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+}
+
+// ParseJSONSchema parses the JSON Schema b.
+func ParseJSONSchema(b []byte) (*JSONSchema, error) {
+	var s JSONSchema
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("pglogrus: invalid JSON schema: %v", err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// patterns caches the compiled patterns of the schemas, so the schemas built
+// as struct literals compile their patterns once too.
+var patterns sync.Map
+
+// compilePattern returns the compiled pattern.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+// compile compiles the patterns of the schema and its subschemas, to report
+// invalid patterns when the schema is parsed.
+func (s *JSONSchema) compile() error {
+	if s.Pattern != "" {
+		if _, err := compilePattern(s.Pattern); err != nil {
+			return fmt.Errorf("pglogrus: invalid JSON schema pattern %q: %v", s.Pattern, err)
+		}
+	}
+	for _, p := range s.Properties {
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// A ValidationError lists the violations of the schema by an entry, with the
+// JSON pointer of the invalid values (eg. "/user/id: expected integer").
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Violations, "; ")
+}
+
+// Validate returns a *ValidationError if fields don't satisfy the schema.
+func (s *JSONSchema) Validate(fields logrus.Fields) error {
+	// Validate the values as stored in message_data
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	violations := s.validate("", v, nil)
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validate(path string, v interface{}, violations []string) []string {
+	fail := func(format string, args ...interface{}) {
+		p := path
+		if p == "" {
+			p = "/"
+		}
+		violations = append(violations, p+": "+fmt.Sprintf(format, args...))
+	}
+
+	if s.Type != nil && !s.hasType(v) {
+		fail("expected %v, got %s", s.Type, jsonType(v))
+		return violations
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(normalizeJSON(e), v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("%v is not one of %v", v, s.Enum)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, k := range s.Required {
+			if _, ok := v[k]; !ok {
+				fail("missing required property %q", k)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p, ok := s.Properties[k]
+			switch {
+			case ok:
+				violations = p.validate(path+"/"+k, v[k], violations)
+			case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+				fail("unexpected property %q", k)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				violations = s.Items.validate(fmt.Sprintf("%s/%d", path, i), item, violations)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("%v is less than %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("%v is greater than %v", v, *s.Maximum)
+		}
+	case string:
+		n := len([]rune(v))
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("length %d is less than %d", n, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("length %d is greater than %d", n, *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			re, err := compilePattern(s.Pattern)
+			switch {
+			case err != nil:
+				fail("invalid pattern %q: %v", s.Pattern, err)
+			case !re.MatchString(v):
+				fail("%q doesn't match %q", v, s.Pattern)
+			}
+		}
+	}
+	return violations
+}
+
+// hasType reports whether v is of one of the types of the schema.
+func (s *JSONSchema) hasType(v interface{}) bool {
+	var types []interface{}
+	switch t := s.Type.(type) {
+	case string:
+		types = []interface{}{t}
+	case []interface{}:
+		types = t
+	}
+	actual := jsonType(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type of v, as decoded by encoding/json.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// normalizeJSON returns v as decoded by encoding/json.
+func normalizeJSON(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var n interface{}
+	if err := json.Unmarshal(b, &n); err != nil {
+		return v
+	}
+	return n
+}
+
+// ValidateFields drops the entries whose fields (including the extra fields)
+// don't satisfy schema. The violations are reported to the ErrorHandler with
+// the "validate" Op and a *ValidationError, and the entries are written to
+// deadLetter, if not nil, to be fixed and replayed.
+// Like other sinks, deadLetter is best-effort, and flushing an AsyncHook waits
+// for it to receive the dropped entries. The dropped entries are
+// counted under "validate" by IgnoredByFilter.
+func (hook *Hook) ValidateFields(schema *JSONSchema, deadLetter SecondarySink) {
+	var q *sinkQueue
+	if deadLetter != nil {
+		q = hook.newSinkQueue(deadLetter)
+		hook.mu.Lock()
+		hook.deadLetters = append(hook.deadLetters, q)
+		hook.mu.Unlock()
+	}
+	hook.AddNamedFilter("validate", func(entry *logrus.Entry) *logrus.Entry {
+		err := schema.Validate(entry.Data)
+		if err == nil {
+			return entry
+		}
+		hook.handleError(&ErrorEvent{Time: time.Now(), Op: "validate", Err: err, Entry: entry})
+		if q != nil {
+			hook.queue(q, []*logrus.Entry{entry})
+		}
+		return nil
+	})
+}
@@ -0,0 +1,103 @@
+package pglogrus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestJSONSchema(t *testing.T) {
+	schema, err := ParseJSONSchema([]byte(`{
+		"type": "object",
+		"required": ["user_id"],
+		"additionalProperties": false,
+		"properties": {
+			"user_id": {"type": "integer", "minimum": 1},
+			"env": {"enum": ["prod", "staging"]},
+			"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fields     logrus.Fields
+		violations []string
+	}{
+		{
+			fields: logrus.Fields{"user_id": 12, "env": "prod", "tags": []string{"api"}},
+		},
+		{
+			fields:     logrus.Fields{"env": "dev", "other": true},
+			violations: []string{`/: missing required property "user_id"`, `/env: dev is not one of [prod staging]`, `/: unexpected property "other"`},
+		},
+		{
+			fields:     logrus.Fields{"user_id": 1.5, "tags": []string{"api", "V2"}},
+			violations: []string{"/tags/1: \"V2\" doesn't match \"^[a-z]+$\"", "/user_id: expected integer, got number"},
+		},
+	}
+	for i, test := range tests {
+		var violations []string
+		if err := schema.Validate(test.fields); err != nil {
+			violations = err.(*ValidationError).Violations
+		}
+		if !reflect.DeepEqual(test.violations, violations) {
+			t.Errorf("Expected violations of fields %d to be %q, got %q\n", i, test.violations, violations)
+		}
+	}
+
+	if _, err := ParseJSONSchema([]byte(`{"pattern": "("}`)); err == nil {
+		t.Errorf("Expected invalid pattern to be rejected\n")
+	}
+
+	// Patterns of schemas built as struct literals are compiled too
+	literal := &JSONSchema{Properties: map[string]*JSONSchema{
+		"env":  {Pattern: "^[a-z]+$"},
+		"user": {Pattern: "("},
+	}}
+	err = literal.Validate(logrus.Fields{"env": "PROD", "user": "alice"})
+	if err == nil || len(err.(*ValidationError).Violations) != 2 {
+		t.Errorf("Expected the patterns to be checked, got %v\n", err)
+	}
+}
+
+func TestValidateFields(t *testing.T) {
+	schema, err := ParseJSONSchema([]byte(`{"required": ["user_id"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := NewHook(nil, map[string]interface{}{})
+	var events []*ErrorEvent
+	hook.ErrorHandler = func(event *ErrorEvent) {
+		events = append(events, event)
+	}
+	hook.ValidateFields(schema, nil)
+
+	if entry := hook.newEntry(&logrus.Entry{Data: logrus.Fields{"user_id": 1}}); entry == nil {
+		t.Errorf("Expected valid entry to be kept\n")
+	}
+	if entry := hook.newEntry(&logrus.Entry{Data: logrus.Fields{}}); entry != nil {
+		t.Errorf("Expected invalid entry to be dropped, got %v\n", entry)
+	}
+	if len(events) != 1 || events[0].Op != "validate" {
+		t.Errorf("Expected a validate error, got %v\n", events)
+	}
+
+	// Waiting for the sinks waits for the dead letter sink
+	hook = NewHook(nil, map[string]interface{}{})
+	hook.ErrorHandler = func(*ErrorEvent) {}
+	var dead []*logrus.Entry
+	hook.ValidateFields(schema, sinkFunc(func(entries []*logrus.Entry) error {
+		time.Sleep(10 * time.Millisecond)
+		dead = append(dead, entries...)
+		return nil
+	}))
+	hook.newEntry(&logrus.Entry{Data: logrus.Fields{}})
+	hook.waitSinks()
+	if len(dead) != 1 {
+		t.Errorf("Expected the invalid entry to be written to the dead letter sink, got %v\n", dead)
+	}
+}
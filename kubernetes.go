@@ -0,0 +1,73 @@
+package pglogrus
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fields added by AddKubernetesMetadata, named like the fields of the Elastic
+// add_kubernetes_metadata processor.
+const (
+	KubernetesNamespaceField = "kubernetes.namespace"
+	KubernetesPodField       = "kubernetes.pod.name"
+	KubernetesNodeField      = "kubernetes.node.name"
+	KubernetesContainerField = "kubernetes.container.name"
+)
+
+// serviceAccountNamespace is the file where Kubernetes mounts the namespace
+// of the pod service account
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// AddKubernetesMetadata adds the namespace, pod, node and container of the
+// application to the entry fields.
+// They're read from the POD_NAMESPACE, POD_NAME, NODE_NAME and CONTAINER_NAME
+// environment variables, which must be set with the downward API:
+//
+//	env:
+//	- name: POD_NAME
+//	  valueFrom:
+//	    fieldRef:
+//	      fieldPath: metadata.name
+//
+// When they're not set, the namespace is read from the service account, and
+// the pod name is the hostname. Nothing is added outside of Kubernetes.
+func (hook *Hook) AddKubernetesMetadata() {
+	fields := kubernetesFields(os.Getenv, serviceAccountNamespace)
+	if len(fields) > 0 {
+		hook.AddFilter(fieldsFilter(fields))
+	}
+}
+
+// kubernetesFields returns the fields describing the pod, using getenv to
+// read environment variables.
+func kubernetesFields(getenv func(string) string, namespaceFile string) logrus.Fields {
+	if getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return nil
+	}
+	fields := logrus.Fields{}
+	for field, name := range map[string]string{
+		KubernetesNamespaceField: "POD_NAMESPACE",
+		KubernetesPodField:       "POD_NAME",
+		KubernetesNodeField:      "NODE_NAME",
+		KubernetesContainerField: "CONTAINER_NAME",
+	} {
+		if v := getenv(name); v != "" {
+			fields[field] = v
+		}
+	}
+	if _, ok := fields[KubernetesNamespaceField]; !ok {
+		if ns, err := ioutil.ReadFile(namespaceFile); err == nil {
+			fields[KubernetesNamespaceField] = strings.TrimSpace(string(ns))
+		}
+	}
+	if _, ok := fields[KubernetesPodField]; !ok {
+		// The hostname of a pod is its name by default
+		if v := getenv("HOSTNAME"); v != "" {
+			fields[KubernetesPodField] = v
+		}
+	}
+	return fields
+}
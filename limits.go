@@ -0,0 +1,103 @@
+package pglogrus
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OverflowField is the field where the fields exceeding the limits of
+// LimitFields are folded.
+const OverflowField = "_extra"
+
+// FieldLimits configure LimitFields.
+type FieldLimits struct {
+	// MaxFields is the maximum number of fields of an entry. 0 means no
+	// limit.
+	MaxFields int
+	// MaxKeys is the maximum number of distinct keys stored at the top level
+	// of message_data, since the hook creation. 0 means no limit.
+	MaxKeys int
+}
+
+// LimitFields protects message_data from entries with too many fields, and
+// from an unbounded number of distinct keys (eg. keys containing IDs).
+// Fields exceeding the limits are folded into the OverflowField object,
+// instead of being dropped. The fields stored in columns of the hook Table are
+// never folded. When the entry already has an OverflowField, its fields are
+// merged, or its value is kept under the OverflowField key of the object if
+// it isn't an object.
+func (hook *Hook) LimitFields(limits FieldLimits) {
+	var (
+		mu   sync.Mutex
+		seen = map[string]bool{}
+	)
+	hook.AddFilter(func(entry *logrus.Entry) *logrus.Entry {
+		keys := make([]string, 0, len(entry.Data))
+		for k := range entry.Data {
+			if k != OverflowField && !hook.Table.hasField(k) {
+				keys = append(keys, k)
+			}
+		}
+		// Keep the same fields for entries with the same keys
+		sort.Strings(keys)
+
+		var overflow logrus.Fields
+		fold := func(k string) {
+			if overflow == nil {
+				overflow = logrus.Fields{}
+			}
+			overflow[k] = entry.Data[k]
+			delete(entry.Data, k)
+		}
+		if limits.MaxFields > 0 && len(keys) > limits.MaxFields {
+			for _, k := range keys[limits.MaxFields:] {
+				fold(k)
+			}
+			keys = keys[:limits.MaxFields]
+		}
+		if limits.MaxKeys > 0 {
+			mu.Lock()
+			for _, k := range keys {
+				if !seen[k] {
+					if len(seen) >= limits.MaxKeys {
+						fold(k)
+						continue
+					}
+					seen[k] = true
+				}
+			}
+			mu.Unlock()
+		}
+
+		if overflow != nil {
+			if existing, ok := entry.Data[OverflowField]; ok {
+				mergeOverflow(overflow, existing)
+			}
+			entry.Data[OverflowField] = overflow
+		}
+		return entry
+	})
+}
+
+// mergeOverflow merges the existing value of the OverflowField of an entry
+// into overflow: its fields if it's an object, or the value itself under the
+// OverflowField key otherwise, so it isn't lost.
+func mergeOverflow(overflow logrus.Fields, existing interface{}) {
+	var fields map[string]interface{}
+	switch v := existing.(type) {
+	case logrus.Fields:
+		fields = v
+	case map[string]interface{}:
+		fields = v
+	default:
+		overflow[OverflowField] = existing
+		return
+	}
+	for k, v := range fields {
+		if _, ok := overflow[k]; !ok {
+			overflow[k] = v
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package pglogrus
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Fields linking entries, see AddLogID and WithParent.
+const (
+	LogIDField       = "log_id"
+	ParentLogIDField = "parent_log_id"
+)
+
+// NewLogID returns a new entry ID (a random uuid), to log an entry which
+// other entries can reference with WithParent.
+//
+//	id := pglogrus.NewLogID()
+//	log.WithField(pglogrus.LogIDField, id).Info("request started")
+//	pglogrus.WithParent(log, id).Warn("retrying")
+func NewLogID() string {
+	return newUUID()
+}
+
+// WithParent returns an entry of logger linked to the entry with the
+// parentID LogIDField.
+func WithParent(logger logrus.FieldLogger, parentID string) *logrus.Entry {
+	return logger.WithField(ParentLogIDField, parentID)
+}
+
+// AddLogID adds a new LogIDField to the entries which don't have one, so
+// every stored entry can be referenced.
+// Use UUIDColumn(LogIDField) and UUIDColumn(ParentLogIDField) to store the
+// links in indexed columns.
+func (hook *Hook) AddLogID() {
+	hook.AddFilter(func(entry *logrus.Entry) *logrus.Entry {
+		if _, ok := entry.Data[LogIDField]; !ok {
+			entry.Data[LogIDField] = newUUID()
+		}
+		return entry
+	})
+}
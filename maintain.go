@@ -0,0 +1,131 @@
+package pglogrus
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// MaintenanceOps are the operations run by Hook.Maintain.
+type MaintenanceOps struct {
+	// ReindexConcurrently rebuilds the indexes of the tables, without
+	// locking writes (PostgreSQL 12 or later), to remove their bloat.
+	ReindexConcurrently bool
+	// AnalyzeOnly updates the statistics of the tables used by the query
+	// planner, without vacuuming them.
+	AnalyzeOnly bool
+	// DetachOldPartitions detaches the partitions of the tables (when
+	// they're partitioned by range of created_at) whose entries were all
+	// created before PartitionsBefore. Detached partitions can then be
+	// archived or dropped.
+	DetachOldPartitions bool
+	PartitionsBefore    time.Time
+}
+
+// Maintain runs maintenance operations on the hook table (or its shards) and
+// its PayloadTable, so routine maintenance can be scheduled by the code owning
+// the schema (PostgreSQL only):
+//
+//	detached, err := hook.Maintain(ctx, pglogrus.MaintenanceOps{
+//		AnalyzeOnly:         true,
+//		DetachOldPartitions: true,
+//		PartitionsBefore:    time.Now().AddDate(0, -3, 0),
+//	})
+//
+// It returns the names of the detached partitions.
+func (hook *Hook) Maintain(ctx context.Context, ops MaintenanceOps) ([]string, error) {
+	table := hook.table()
+	if table.Dialect != Postgres {
+		return nil, fmt.Errorf("pglogrus: maintenance needs PostgreSQL")
+	}
+	var detached []string
+	for _, name := range table.deletedTables() {
+		if ops.DetachOldPartitions {
+			partitions, err := hook.detachPartitions(ctx, name, ops.PartitionsBefore)
+			detached = append(detached, partitions...)
+			if err != nil {
+				return detached, err
+			}
+		}
+		if ops.ReindexConcurrently {
+			// REINDEX CONCURRENTLY can't run in a transaction
+			if _, err := hook.db.ExecContext(ctx, fmt.Sprintf("REINDEX TABLE CONCURRENTLY %s;", name)); err != nil {
+				return detached, err
+			}
+		}
+		if ops.AnalyzeOnly {
+			if _, err := hook.db.ExecContext(ctx, fmt.Sprintf("ANALYZE %s;", name)); err != nil {
+				return detached, err
+			}
+		}
+	}
+	return detached, nil
+}
+
+// partitionUpperBound matches the upper bound of range partitions, in the
+// partition bounds returned by pg_get_expr.
+var partitionUpperBound = regexp.MustCompile(`TO \('([^']+)'\)`)
+
+// partitionBoundLayouts are the layouts of the bounds of partitions by time.
+var partitionBoundLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// detachPartitions detaches the partitions of table whose upper bound is
+// before before, and returns their names. Tables without partitions are
+// ignored.
+func (hook *Hook) detachPartitions(ctx context.Context, table string, before time.Time) ([]string, error) {
+	rows, err := hook.db.QueryContext(ctx, "SELECT i.inhrelid::regclass::text, pg_get_expr(c.relpartbound, c.oid) FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = $1::regclass ORDER BY 1;", table)
+	if err != nil {
+		return nil, err
+	}
+	var old []string
+	for rows.Next() {
+		var name string
+		var bound *string
+		if err := rows.Scan(&name, &bound); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if bound == nil {
+			// Inherited table, not a partition
+			continue
+		}
+		if upper, ok := partitionUpper(*bound); ok && !upper.After(before) {
+			old = append(old, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var detached []string
+	for _, name := range old {
+		if _, err := hook.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s;", table, name)); err != nil {
+			return detached, err
+		}
+		detached = append(detached, name)
+	}
+	return detached, nil
+}
+
+// partitionUpper returns the upper bound (exclusive) of a range partition by
+// time, from its bound expression (eg. "FOR VALUES FROM ('2024-01-01
+// 00:00:00+00') TO ('2024-02-01 00:00:00+00')").
+func partitionUpper(bound string) (time.Time, bool) {
+	m := partitionUpperBound.FindStringSubmatch(bound)
+	if m == nil {
+		return time.Time{}, false
+	}
+	for _, layout := range partitionBoundLayouts {
+		if t, err := time.Parse(layout, m[1]); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
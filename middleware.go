@@ -0,0 +1,53 @@
+package pglogrus
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// An InsertFunc inserts an entry, see InsertMiddleware.
+type InsertFunc func(entry *logrus.Entry) error
+
+// An InsertMiddleware wraps the insertion of each entry, to add cross-cutting
+// concerns like timing, retries, tracing or metrics. It returns an InsertFunc
+// calling next:
+//
+//	func(next pglogrus.InsertFunc) pglogrus.InsertFunc {
+//		return func(entry *logrus.Entry) error {
+//			start := time.Now()
+//			err := next(entry)
+//			insertDuration.Observe(time.Since(start).Seconds())
+//			return err
+//		}
+//	}
+//
+// With the AsyncHook, entries are inserted within the transaction of their
+// batch.
+type InsertMiddleware func(next InsertFunc) InsertFunc
+
+// AddInsertMiddleware adds middlewares around the insertion of entries.
+// The first middleware added is the outermost one.
+func (hook *Hook) AddInsertMiddleware(middlewares ...InsertMiddleware) {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	hook.middlewares = append(hook.middlewares, middlewares...)
+}
+
+// WithInsertMiddleware adds middlewares around the insertion of entries (see
+// AddInsertMiddleware).
+func WithInsertMiddleware(middlewares ...InsertMiddleware) Option {
+	return func(o *options) error {
+		o.hook.middlewares = append(o.hook.middlewares, middlewares...)
+		return nil
+	}
+}
+
+// wrapInsert returns insert wrapped by the middlewares of the hook.
+func (hook *Hook) wrapInsert(insert InsertFunc) InsertFunc {
+	hook.mu.RLock()
+	middlewares := hook.middlewares
+	hook.mu.RUnlock()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		insert = middlewares[i](insert)
+	}
+	return insert
+}
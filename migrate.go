@@ -0,0 +1,299 @@
+package pglogrus
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// SchemaTable stores the schema version of the tables created by the package
+// (see Hook.Migrate).
+const SchemaTable = "pglogrus_schema"
+
+// A migration is a versioned change of the schema of a hook table.
+// Up applies the change, and Down reverts it. Destructive migrations drop
+// data when reverted (see MigrateDown).
+// The statements of a released migration are frozen: they only depend on the
+// TableConfig, never on the current Schema of the package.
+type migration struct {
+	Version     int
+	Up, Down    func(t *TableConfig) []string
+	Destructive bool
+}
+
+// revertable returns an error if reverting m for t drops data, unless
+// destructive is true.
+func (m migration) revertable(t *TableConfig, destructive bool) error {
+	if m.Destructive && !destructive && len(m.Down(t)) > 0 {
+		return fmt.Errorf("pglogrus: reverting schema version %d drops data, use MigrateDown", m.Version)
+	}
+	return nil
+}
+
+// migrations is the registry of the schema changes, by increasing version.
+// New steps must be appended, never modified once released.
+var migrations = []migration{
+	{
+		// The table of the first version, with the configured columns
+		Version: 1,
+		Up: func(t *TableConfig) []string {
+			var stmts []string
+			for _, name := range t.tableNames() {
+				stmts = append(stmts, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id SERIAL,
+    level smallint NOT NULL,
+    message text NOT NULL,
+    message_data json NOT NULL,
+    created_at timestamp with time zone NOT NULL
+);`, name))
+				for _, c := range t.Columns {
+					stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s;", name, c.definition()))
+					if c.Index {
+						prefix := name[strings.LastIndex(name, ".")+1:]
+						stmts = append(stmts, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_%s_idx ON %s (%s);", prefix, c.Name, name, c.Name))
+					}
+				}
+			}
+			return stmts
+		},
+		Down: func(t *TableConfig) []string {
+			var stmts []string
+			for _, name := range t.tableNames() {
+				stmts = append(stmts, fmt.Sprintf("DROP TABLE IF EXISTS %s;", name))
+			}
+			return stmts
+		},
+		Destructive: true,
+	},
+	{
+		// Binary message_data, with an Encoding. Existing payloads are
+		// kept as JSON text.
+		Version: 2,
+		Up: func(t *TableConfig) []string {
+			if t.Encoding == nil || t.Wide {
+				return nil
+			}
+			var stmts []string
+			for _, name := range t.tableNames() {
+				stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN message_data TYPE bytea USING convert_to(message_data::text, 'UTF8');", name))
+			}
+			return stmts
+		},
+		Down: func(t *TableConfig) []string {
+			if t.Encoding == nil || t.Wide {
+				return nil
+			}
+			var stmts []string
+			for _, name := range t.tableNames() {
+				stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN message_data TYPE json USING convert_from(message_data, 'UTF8')::json;", name))
+			}
+			return stmts
+		},
+	},
+	{
+		// The PayloadTable, and the payload_id column referencing it
+		Version: 3,
+		Up: func(t *TableConfig) []string {
+			if !t.offloadsPayloads() {
+				return nil
+			}
+			typ := "json"
+			if t.Encoding != nil {
+				typ = "bytea"
+			}
+			stmts := []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id bigserial PRIMARY KEY,
+    message_data %s NOT NULL,
+    created_at timestamp with time zone NOT NULL
+);`, t.PayloadTable, typ)}
+			for _, name := range t.tableNames() {
+				stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s bigint;", name, PayloadIDColumn))
+			}
+			return stmts
+		},
+		Down: func(t *TableConfig) []string {
+			if !t.offloadsPayloads() {
+				return nil
+			}
+			var stmts []string
+			for _, name := range t.tableNames() {
+				stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s;", name, PayloadIDColumn))
+			}
+			return append(stmts, fmt.Sprintf("DROP TABLE IF EXISTS %s;", t.PayloadTable))
+		},
+		Destructive: true,
+	},
+	{
+		// Wide tables, storing the fields in their own columns: message_data
+		// is kept for the existing entries
+		Version: 4,
+		Up: func(t *TableConfig) []string {
+			if !t.Wide {
+				return nil
+			}
+			var stmts []string
+			for _, name := range t.tableNames() {
+				stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN message_data DROP NOT NULL;", name))
+				if t.UnknownColumn != "" {
+					stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s text;", name, t.UnknownColumn))
+				}
+			}
+			return stmts
+		},
+		Down: func(t *TableConfig) []string {
+			if !t.Wide {
+				return nil
+			}
+			var stmts []string
+			for _, name := range t.tableNames() {
+				if t.UnknownColumn != "" {
+					stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s;", name, t.UnknownColumn))
+				}
+				stmts = append(stmts,
+					fmt.Sprintf("UPDATE %s SET message_data = '{}' WHERE message_data IS NULL;", name),
+					fmt.Sprintf("ALTER TABLE %s ALTER COLUMN message_data SET NOT NULL;", name),
+				)
+			}
+			return stmts
+		},
+		Destructive: true,
+	},
+}
+
+// LatestSchemaVersion is the schema version of the tables created by this
+// version of the package.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrate applies the pending migrations to the hook table, up to
+// LatestSchemaVersion.
+// Tables created without Migrate are at version 0: migrating them is safe,
+// the first version only creates the table and its columns if they don't
+// exist.
+func (hook *Hook) Migrate(ctx context.Context) error {
+	return hook.MigrateTo(ctx, LatestSchemaVersion())
+}
+
+// MigrateTo applies (or reverts) migrations of the hook table, up to (or
+// down to) version.
+// Each migration is applied in its own transaction. Reverting a destructive
+// migration (like the first one, dropping the table) fails: use MigrateDown.
+func (hook *Hook) MigrateTo(ctx context.Context, version int) error {
+	return hook.migrateTo(ctx, version, false)
+}
+
+// MigrateDown reverts migrations of the hook table down to version, like
+// MigrateTo, including the destructive ones: the tables and columns they
+// added are dropped, with their data.
+func (hook *Hook) MigrateDown(ctx context.Context, version int) error {
+	return hook.migrateTo(ctx, version, true)
+}
+
+func (hook *Hook) migrateTo(ctx context.Context, version int, destructive bool) error {
+	if version < 0 || version > LatestSchemaVersion() {
+		return fmt.Errorf("pglogrus: unknown schema version %d", version)
+	}
+	_, err := hook.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    name text PRIMARY KEY,
+    schema_version integer NOT NULL
+);`, SchemaTable))
+	if err != nil {
+		return err
+	}
+
+	current, err := hook.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case current > LatestSchemaVersion():
+		return fmt.Errorf("pglogrus: schema version %d is newer than this package", current)
+	case destructive && version > current:
+		return fmt.Errorf("pglogrus: schema version %d is above the current version %d", version, current)
+	case !destructive:
+		// Check the migrations to revert before reverting any
+		for v := current; v > version; v-- {
+			if err := migrations[v-1].revertable(&hook.Table, false); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		done, err := hook.migrateStep(ctx, version, destructive)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// migrateStep applies the next migration toward version, and reports whether
+// version was already reached. Destructive migrations are only reverted if
+// destructive is true.
+func (hook *Hook) migrateStep(ctx context.Context, version int, destructive bool) (bool, error) {
+	txn, err := hook.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer txn.Rollback()
+
+	// Prevent concurrent migrations of the same table
+	if _, err := txn.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1));", SchemaTable+"/"+hook.Table.Name); err != nil {
+		return false, err
+	}
+	current, err := schemaVersion(ctx, txn, hook.Table.Name)
+	if err != nil {
+		return false, err
+	}
+
+	if current > LatestSchemaVersion() {
+		return false, fmt.Errorf("pglogrus: schema version %d is newer than this package", current)
+	}
+
+	var stmts []string
+	var next int
+	switch {
+	case current == version:
+		return true, nil
+	case current < version:
+		m := migrations[current] // versions start at 1
+		stmts, next = m.Up(&hook.Table), m.Version
+	default:
+		m := migrations[current-1]
+		if err := m.revertable(&hook.Table, destructive); err != nil {
+			return false, err
+		}
+		stmts, next = m.Down(&hook.Table), m.Version-1
+	}
+
+	for _, stmt := range stmts {
+		if _, err := txn.ExecContext(ctx, stmt); err != nil {
+			return false, fmt.Errorf("pglogrus: migration to schema version %d failed: %v", next, err)
+		}
+	}
+	_, err = txn.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s(name, schema_version) VALUES ($1,$2) ON CONFLICT (name) DO UPDATE SET schema_version = EXCLUDED.schema_version;", SchemaTable), hook.Table.Name, next)
+	if err != nil {
+		return false, err
+	}
+	return false, txn.Commit()
+}
+
+// SchemaVersion returns the schema version of the hook table, 0 if it wasn't
+// migrated yet.
+func (hook *Hook) SchemaVersion(ctx context.Context) (int, error) {
+	return schemaVersion(ctx, hook.db, hook.Table.Name)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func schemaVersion(ctx context.Context, db queryer, table string) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT schema_version FROM %s WHERE name = $1;", SchemaTable), table).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
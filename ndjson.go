@@ -0,0 +1,70 @@
+package pglogrus
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ndjsonEntry is the representation of an entry in NDJSON files, mirroring
+// the columns of the logs table.
+type ndjsonEntry struct {
+	Level   string        `json:"level"`
+	Message string        `json:"message"`
+	Data    logrus.Fields `json:"message_data"`
+	Time    time.Time     `json:"created_at"`
+}
+
+func newNDJSONEntry(entry *logrus.Entry) ndjsonEntry {
+	return ndjsonEntry{
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+		Data:    entry.Data,
+		Time:    entry.Time,
+	}
+}
+
+// MarshalNDJSON returns entry as a line of JSON, in the format of the files
+// written by Archive and Export:
+//
+//	{"level":"info","message":"...","message_data":{...},"created_at":"..."}
+func MarshalNDJSON(entry *logrus.Entry) ([]byte, error) {
+	data, err := json.Marshal(newNDJSONEntry(entry))
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// WriteNDJSON writes entries to w, one line of JSON per entry (see
+// MarshalNDJSON).
+func WriteNDJSON(w io.Writer, entries ...*logrus.Entry) error {
+	for _, entry := range entries {
+		line, err := MarshalNDJSON(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmarshalNDJSON parses a line written by MarshalNDJSON.
+func UnmarshalNDJSON(line []byte) (*logrus.Entry, error) {
+	var e ndjsonEntry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return nil, err
+	}
+	level, err := logrus.ParseLevel(e.Level)
+	if err != nil {
+		return nil, err
+	}
+	if e.Data == nil {
+		e.Data = logrus.Fields{}
+	}
+	return &logrus.Entry{Level: level, Message: e.Message, Data: e.Data, Time: e.Time}, nil
+}
@@ -0,0 +1,57 @@
+package pglogrus
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNDJSON(t *testing.T) {
+	entries := []*logrus.Entry{
+		{Level: logrus.InfoLevel, Message: "first", Data: logrus.Fields{"user": "12"}, Time: time.Date(2019, 3, 18, 10, 0, 0, 0, time.UTC)},
+		{Level: logrus.ErrorLevel, Message: "second\nline", Data: logrus.Fields{"status": float64(500)}, Time: time.Date(2019, 3, 18, 10, 0, 1, 0, time.UTC)},
+	}
+	var buf bytes.Buffer
+	if err := WriteNDJSON(&buf, entries...); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"level":"info","message":"first","message_data":{"user":"12"},"created_at":"2019-03-18T10:00:00Z"}` + "\n"
+	if line, _ := buf.ReadString('\n'); line != expected {
+		t.Errorf("Expected line to be %q, got %q\n", expected, line)
+	}
+
+	var buf2 bytes.Buffer
+	WriteNDJSON(&buf2, entries...)
+	scanner := bufio.NewScanner(&buf2)
+	for i := 0; scanner.Scan(); i++ {
+		entry, err := UnmarshalNDJSON(scanner.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(entries[i], entry) {
+			t.Errorf("Expected entry %d to be %v, got %v\n", i, entries[i], entry)
+		}
+	}
+}
+
+func TestReplayDelay(t *testing.T) {
+	tests := []struct {
+		replayed int
+		rate     float64
+		elapsed  time.Duration
+		expected time.Duration
+	}{
+		{1000, 0, time.Second, 0},
+		{1000, 500, time.Second, time.Second},
+		{1000, 500, 3 * time.Second, -time.Second},
+	}
+	for _, test := range tests {
+		if d := replayDelay(test.replayed, test.rate, test.elapsed); d != test.expected {
+			t.Errorf("Expected delay of %d entries at %v/s after %v to be %v, got %v\n", test.replayed, test.rate, test.elapsed, test.expected, d)
+		}
+	}
+}
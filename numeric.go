@@ -0,0 +1,85 @@
+package pglogrus
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+)
+
+// decimalNumber matches the decimal representation of numbers, as produced
+// by the String method of decimal types like github.com/shopspring/decimal.
+var decimalNumber = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// NumericEncoder is a TypeEncoder storing decimal numbers without precision
+// loss: they're embedded as JSON numbers with all their digits in the
+// payload, instead of being converted to float64, and can be stored in
+// numeric columns.
+// It supports *big.Int, *big.Float, *big.Rat (when its decimal
+// representation is finite, like 1/8 but unlike 1/3), and the types whose
+// String method returns a decimal number, like decimal.Decimal of
+// github.com/shopspring/decimal:
+//
+//	pglogrus.RegisterFieldEncoder(reflect.TypeOf(decimal.Decimal{}), pglogrus.NumericEncoder)
+//	pglogrus.RegisterFieldEncoder(reflect.TypeOf(&big.Rat{}), pglogrus.NumericEncoder)
+//
+// Note that the CBOR Encoding stores numbers as float64.
+func NumericEncoder(v interface{}) (interface{}, error) {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, fmt.Errorf("nil %T isn't a number", v)
+	}
+	var s string
+	switch n := v.(type) {
+	case *big.Int:
+		s = n.String()
+	case *big.Float:
+		s = n.Text('f', -1)
+	case *big.Rat:
+		digits, ok := decimalDigits(n)
+		if !ok {
+			return nil, fmt.Errorf("%s has no finite decimal representation", n)
+		}
+		s = n.FloatString(digits)
+	case fmt.Stringer:
+		s = n.String()
+	default:
+		return nil, fmt.Errorf("%T isn't a number", v)
+	}
+	// Infinities aren't valid JSON numbers
+	if !decimalNumber.MatchString(s) {
+		return nil, fmt.Errorf("%q isn't a decimal number", s)
+	}
+	return json.RawMessage(s), nil
+}
+
+// decimalDigits returns the number of digits after the decimal point of r,
+// or false if they're infinite: when the denominator of r has other prime
+// factors than 2 and 5.
+func decimalDigits(r *big.Rat) (int, bool) {
+	d := new(big.Int).Set(r.Denom())
+	two, five := big.NewInt(2), big.NewInt(5)
+	var twos, fives int
+	mod := new(big.Int)
+	for {
+		q, m := new(big.Int).QuoRem(d, two, mod)
+		if m.Sign() != 0 {
+			break
+		}
+		d, twos = q, twos+1
+	}
+	for {
+		q, m := new(big.Int).QuoRem(d, five, mod)
+		if m.Sign() != 0 {
+			break
+		}
+		d, fives = q, fives+1
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return 0, false
+	}
+	if twos > fives {
+		return twos, true
+	}
+	return fives, true
+}
@@ -0,0 +1,125 @@
+package pglogrus
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// An Option configures a hook created by New or NewAsync.
+type Option func(*options) error
+
+// options of a hook being created
+type options struct {
+	hook *Hook
+	// pingTimeout is the timeout of the startup checks, if enabled
+	pingTimeout time.Duration
+	// validateSchema makes the startup checks validate the schema too
+	validateSchema bool
+	// driverName, credentials and tls are used by NewHookDSN
+	driverName  string
+	credentials func(ctx context.Context) (Credentials, error)
+	tls         *TLSConfig
+}
+
+// WithExtra sets the extra fields added to all entries.
+func WithExtra(extra map[string]interface{}) Option {
+	return func(o *options) error {
+		o.hook.Extra = extra
+		return nil
+	}
+}
+
+// WithTable sets the table where entries are stored.
+func WithTable(table TableConfig) Option {
+	return func(o *options) error {
+		if table.Name == "" {
+			return fmt.Errorf("pglogrus: table name is empty")
+		}
+		o.hook.Table = table
+		return nil
+	}
+}
+
+// WithStartupPing makes New and NewAsync check that the database is
+// reachable, within timeout, and return an error if it's not, instead of
+// failing when the first entry is written.
+// The check is skipped when the hook is Disabled.
+func WithStartupPing(timeout time.Duration) Option {
+	return func(o *options) error {
+		o.pingTimeout = timeout
+		return nil
+	}
+}
+
+// WithSchemaValidation makes New and NewAsync validate the schema of the
+// table (see ValidateSchema), after checking that the database is reachable
+// like WithStartupPing (within 5 seconds, unless set by WithStartupPing).
+func WithSchemaValidation() Option {
+	return func(o *options) error {
+		o.validateSchema = true
+		return nil
+	}
+}
+
+// New creates a hook to be added to an instance of logger, configured with
+// opts. Unlike NewHook, it returns an error if an option is invalid, or if
+// the startup checks failed (see WithStartupPing).
+func New(db *sql.DB, opts ...Option) (*Hook, error) {
+	hook := NewHook(db, nil)
+	if err := hook.apply(opts); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// NewAsync creates an asynchronous hook (see NewAsyncHook), configured with
+// opts. Unlike NewAsyncHook, it returns an error if an option is invalid, or
+// if the startup checks failed (see WithStartupPing).
+func NewAsync(db *sql.DB, opts ...Option) (*AsyncHook, error) {
+	hook := NewHook(db, nil)
+	if err := hook.apply(opts); err != nil {
+		return nil, err
+	}
+	return newAsyncHook(hook), nil
+}
+
+// apply applies opts to hook, and runs the startup checks.
+func (hook *Hook) apply(opts []Option) error {
+	o, err := hook.applyOptions(opts)
+	if err != nil {
+		return err
+	}
+	return hook.startup(o)
+}
+
+// applyOptions applies opts to hook.
+func (hook *Hook) applyOptions(opts []Option) (*options, error) {
+	o := &options{hook: hook}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// startup runs the startup checks enabled by o.
+func (hook *Hook) startup(o *options) error {
+	if hook.Disabled || (o.pingTimeout <= 0 && !o.validateSchema) {
+		return nil
+	}
+	if o.pingTimeout <= 0 {
+		o.pingTimeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), o.pingTimeout)
+	defer cancel()
+	if err := hook.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("pglogrus: can't connect to the database: %v", err)
+	}
+	if o.validateSchema {
+		return hook.ValidateSchema(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package pglogrus
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OutagePolicy configures how an AsyncHook behaves when it can't create
+// transactions for a while, instead of retrying forever while the queue
+// fills up and logging blocks.
+type OutagePolicy struct {
+	// After is how long transactions can fail before the hook is degraded
+	// (see Stats.Degraded). 0 disables the policy.
+	After time.Duration
+	// Fallback receives the batches which can't be written while the hook is
+	// degraded (eg. a WriterSink to a spill file, to be replayed later),
+	// instead of retrying them. They're counted as dropped.
+	Fallback SecondarySink
+	// ShedLevels replace the ShedLevels of the hook while it's degraded (eg.
+	// DefaultShedLevels), to keep the queue for the most severe entries.
+	ShedLevels map[logrus.Level]float64
+}
+
+// degraded reports whether the hook is degraded by an outage.
+func (hook *Hook) degraded() bool {
+	return atomic.LoadInt32(&hook.stats.degraded) == 1
+}
+
+// recovered ends the outage of the hook, if any.
+func (hook *AsyncHook) recovered() {
+	hook.outageSince = time.Time{}
+	hook.setDegraded(false)
+}
+
+// setDegraded sets whether the hook is degraded by an outage.
+func (hook *Hook) setDegraded(degraded bool) {
+	var v int32
+	if degraded {
+		v = 1
+	}
+	atomic.StoreInt32(&hook.stats.degraded, v)
+}
+
+// shedLevels returns the ShedLevels currently applied.
+func (hook *AsyncHook) shedLevels() map[logrus.Level]float64 {
+	if hook.Outage.ShedLevels != nil && hook.degraded() {
+		return hook.Outage.ShedLevels
+	}
+	return hook.ShedLevels
+}
+
+// outage applies the Outage policy after a transaction failed. It returns
+// true when batch was written to the Fallback sink, and must not be retried.
+func (hook *AsyncHook) outage(batch []*logrus.Entry) bool {
+	if hook.outageSince.IsZero() {
+		hook.outageSince = time.Now()
+	}
+	if hook.Outage.After <= 0 || time.Since(hook.outageSince) < hook.Outage.After {
+		return false
+	}
+	hook.setDegraded(true)
+	if hook.Outage.Fallback == nil {
+		return false
+	}
+	if err := hook.Outage.Fallback.Write(batch); err != nil {
+		hook.handleError(&ErrorEvent{Time: time.Now(), Op: "sink", Err: err})
+	}
+	return true
+}
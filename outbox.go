@@ -0,0 +1,202 @@
+package pglogrus
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// outboxTableSchema returns the SQL statements creating the outbox table (see
+// Hook.OutboxTable). The failed_at and error columns are added to the tables
+// created by previous versions.
+func outboxTableSchema(name string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id bigserial PRIMARY KEY,
+    entry jsonb NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    failed_at timestamp with time zone,
+    error text
+);`, name),
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS failed_at timestamp with time zone, ADD COLUMN IF NOT EXISTS error text;", name),
+	}
+}
+
+// insertOutbox inserts entry in the OutboxTable, within tx.
+func (hook *Hook) insertOutbox(tx *sql.Tx, entry *logrus.Entry) error {
+	line, err := MarshalNDJSON(entry)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s(entry) VALUES ($1);", hook.OutboxTable), string(bytes.TrimSpace(line)))
+	return err
+}
+
+// RelayOptions configure Hook.RelayOutbox.
+type RelayOptions struct {
+	// BatchSize is the maximum number of entries moved in a transaction,
+	// 1000 by default.
+	BatchSize int
+	// Interval is the time between two checks of the outbox, when it's
+	// empty, a second by default.
+	Interval time.Duration
+}
+
+// RelayOutbox moves the entries of the OutboxTable to the hook table until
+// ctx is done. Entries are moved in batches, inserted and deleted from the
+// outbox in the same transaction: each entry is stored exactly once, even
+// with several relays running. The entries are then passed to the secondary
+// sinks of the hook, which are best-effort as usual.
+// Each entry is inserted within a savepoint: entries which can't be decoded
+// or inserted are kept in the outbox, with their failed_at and error set, and
+// aren't relayed again. Remove them (or reset failed_at) once handled.
+// Errors are reported to the ErrorHandler, with the "relay" Op.
+// The outbox needs PostgreSQL 9.5 or later.
+func (hook *Hook) RelayOutbox(ctx context.Context, opts RelayOptions) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	for {
+		n, err := hook.relayOutbox(ctx, opts.BatchSize)
+		if err != nil && ctx.Err() == nil {
+			hook.handleError(&ErrorEvent{Time: time.Now(), Op: "relay", Err: err})
+		}
+		if n == opts.BatchSize {
+			// The outbox may have more entries
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+// relayOutbox moves up to limit entries of the outbox to the hook table, and
+// returns the number of moved or failed entries.
+func (hook *Hook) relayOutbox(ctx context.Context, limit int) (int, error) {
+	txn, err := hook.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer txn.Rollback()
+
+	// Other relays skip the locked entries, instead of waiting for them
+	rows, err := txn.QueryContext(ctx, fmt.Sprintf("SELECT id, entry FROM %s WHERE failed_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED;", hook.OutboxTable), limit)
+	if err != nil {
+		return 0, err
+	}
+	var ids []interface{}
+	var lines [][]byte
+	for rows.Next() {
+		var id int64
+		var line []byte
+		if err := rows.Scan(&id, &line); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		lines = append(lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var moved []interface{}
+	var entries []*logrus.Entry
+	var failures []*ErrorEvent
+	var lastTime time.Time
+	for i, id := range ids {
+		entry, err := UnmarshalNDJSON(lines[i])
+		if err != nil {
+			err = fmt.Errorf("pglogrus: invalid outbox entry %d: %v", id, err)
+		} else if err = hook.relayEntry(ctx, txn, entry); err != nil && !entryError(err) {
+			// Other errors (missing table, permissions, canceled ctx...)
+			// aren't caused by the entry: the batch is relayed again later
+			return 0, err
+		}
+		if err != nil {
+			_, markErr := txn.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET failed_at = now(), error = $2 WHERE id = $1;", hook.OutboxTable), id, err.Error())
+			if markErr != nil {
+				return 0, markErr
+			}
+			failures = append(failures, &ErrorEvent{Time: time.Now(), Op: "relay", Err: err, Entry: entry})
+			continue
+		}
+		moved = append(moved, id)
+		entries = append(entries, entry)
+		if entry.Time.After(lastTime) {
+			lastTime = entry.Time
+		}
+	}
+	if len(moved) > 0 {
+		placeholders := make([]string, len(moved))
+		for i := range moved {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+		if _, err := txn.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id IN (%s);", hook.OutboxTable, strings.Join(placeholders, ",")), moved...); err != nil {
+			return 0, err
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, event := range failures {
+		hook.handleError(event)
+	}
+	if len(entries) > 0 {
+		atomic.AddUint64(&hook.stats.written, uint64(len(entries)))
+		hook.committed(lastTime)
+		hook.addRecent(entries...)
+		hook.sink(entries...)
+	}
+	return len(ids), nil
+}
+
+// entryError reports whether err is caused by the values of the entry (data
+// exceptions and integrity constraint violations), so relaying it again would
+// fail too.
+func entryError(err error) bool {
+	var s sqlStater
+	if !errors.As(err, &s) {
+		return false
+	}
+	code := s.SQLState()
+	return strings.HasPrefix(code, "22") || strings.HasPrefix(code, "23")
+}
+
+// relayEntry inserts entry (and its annotation) within a savepoint of txn,
+// rolled back if the insert fails.
+func (hook *Hook) relayEntry(ctx context.Context, txn *sql.Tx, entry *logrus.Entry) error {
+	if _, err := txn.ExecContext(ctx, "SAVEPOINT pglogrus_relay;"); err != nil {
+		return err
+	}
+	err := hook.insert(txn, entry)
+	if query, args, ok := hook.annotationStatement(entry); ok && err == nil {
+		_, err = txn.ExecContext(ctx, query, args...)
+	}
+	if err != nil {
+		if _, rbErr := txn.ExecContext(ctx, "ROLLBACK TO SAVEPOINT pglogrus_relay;"); rbErr != nil {
+			return fmt.Errorf("%v (rollback to savepoint failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	_, err = txn.ExecContext(ctx, "RELEASE SAVEPOINT pglogrus_relay;")
+	return err
+}
@@ -0,0 +1,284 @@
+package pglogrus
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// parquetRowGroupSize is the number of entries of each row group of Parquet
+// exports, buffered in memory before being written.
+const parquetRowGroupSize = 10000
+
+// parquetMagic starts and ends Parquet files.
+const parquetMagic = "PAR1"
+
+// Parquet physical types, encodings and converted types (see parquet.thrift)
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+	parquetJSON            = 19
+)
+
+// A parquetColumn is a required column of a Parquet export.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32
+	// values are the PLAIN encoded values of the current row group
+	values []byte
+}
+
+// parquetWriter writes entries in a Parquet file, with the created_at
+// (timestamp), level, message and message_data (JSON) columns, like the CSV
+// export.
+// Pages are PLAIN encoded, uncompressed: it keeps the package free of
+// dependencies, and the files are readable by any Parquet reader.
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	columns   []*parquetColumn
+	rows      int
+	total     int64
+	rowGroups [][]byte
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{
+		w: w,
+		columns: []*parquetColumn{
+			{name: "created_at", typ: parquetInt64, converted: parquetTimestampMicros},
+			{name: "level", typ: parquetByteArray, converted: parquetUTF8},
+			{name: "message", typ: parquetByteArray, converted: parquetUTF8},
+			{name: "message_data", typ: parquetByteArray, converted: parquetJSON},
+		},
+	}
+}
+
+// write adds entry to the current row group, written once full.
+func (p *parquetWriter) write(entry *logrus.Entry) error {
+	if p.offset == 0 {
+		if err := p.writeBytes([]byte(parquetMagic)); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(entry.Data)
+	if err != nil {
+		return err
+	}
+	var micros [8]byte
+	binary.LittleEndian.PutUint64(micros[:], uint64(entry.Time.UnixNano()/1000))
+	p.columns[0].values = append(p.columns[0].values, micros[:]...)
+	p.columns[1].values = appendByteArray(p.columns[1].values, []byte(entry.Level.String()))
+	p.columns[2].values = appendByteArray(p.columns[2].values, []byte(entry.Message))
+	p.columns[3].values = appendByteArray(p.columns[3].values, data)
+	p.rows++
+	if p.rows == parquetRowGroupSize {
+		return p.flushRowGroup()
+	}
+	return nil
+}
+
+// close writes the last row group and the footer of the file.
+func (p *parquetWriter) close() error {
+	if p.offset == 0 {
+		if err := p.writeBytes([]byte(parquetMagic)); err != nil {
+			return err
+		}
+	}
+	if err := p.flushRowGroup(); err != nil {
+		return err
+	}
+
+	// FileMetaData
+	var t thriftWriter
+	t.i32(1, 1)
+	t.listHeader(2, thriftStruct, len(p.columns)+1)
+	t.beginElement()
+	t.binary(4, []byte("schema"))
+	t.i32(5, int32(len(p.columns)))
+	t.endStruct()
+	for _, c := range p.columns {
+		t.beginElement()
+		t.i32(1, c.typ)
+		t.i32(3, 0) // REQUIRED
+		t.binary(4, []byte(c.name))
+		t.i32(6, c.converted)
+		t.endStruct()
+	}
+	t.i64(3, p.total)
+	t.listHeader(4, thriftStruct, len(p.rowGroups))
+	for _, rowGroup := range p.rowGroups {
+		t.b = append(t.b, rowGroup...)
+	}
+	t.binary(6, []byte("pglogrus"))
+	t.b = append(t.b, 0)
+
+	footer := t.b
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	footer = append(append(footer, size[:]...), parquetMagic...)
+	return p.writeBytes(footer)
+}
+
+// flushRowGroup writes a page for each column of the buffered rows, and
+// records the metadata of the row group.
+func (p *parquetWriter) flushRowGroup() error {
+	if p.rows == 0 {
+		return nil
+	}
+	var rowGroup thriftWriter
+	rowGroup.beginElement()
+	rowGroup.listHeader(1, thriftStruct, len(p.columns))
+	var groupSize int64
+	for _, c := range p.columns {
+		var header thriftWriter
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(c.values)))
+		header.i32(3, int32(len(c.values)))
+		header.beginStruct(5)
+		header.i32(1, int32(p.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.b = append(header.b, 0)
+
+		pageOffset := p.offset
+		if err := p.writeBytes(header.b); err != nil {
+			return err
+		}
+		if err := p.writeBytes(c.values); err != nil {
+			return err
+		}
+		size := int64(len(header.b) + len(c.values))
+		groupSize += size
+
+		// ColumnChunk
+		rowGroup.beginElement()
+		rowGroup.i64(2, pageOffset)
+		rowGroup.beginStruct(3)
+		rowGroup.i32(1, c.typ)
+		rowGroup.listHeader(2, thriftI32, 1)
+		rowGroup.b = appendZigzag(rowGroup.b, parquetPlain)
+		rowGroup.listHeader(3, thriftBinary, 1)
+		rowGroup.b = appendUvarint(rowGroup.b, uint64(len(c.name)))
+		rowGroup.b = append(rowGroup.b, c.name...)
+		rowGroup.i32(4, 0) // UNCOMPRESSED
+		rowGroup.i64(5, int64(p.rows))
+		rowGroup.i64(6, size)
+		rowGroup.i64(7, size)
+		rowGroup.i64(9, pageOffset)
+		rowGroup.endStruct()
+		rowGroup.endStruct()
+		c.values = c.values[:0]
+	}
+	rowGroup.i64(2, groupSize)
+	rowGroup.i64(3, int64(p.rows))
+	rowGroup.endStruct()
+
+	p.rowGroups = append(p.rowGroups, rowGroup.b)
+	p.total += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+func (p *parquetWriter) writeBytes(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// appendByteArray appends the PLAIN encoding of a BYTE_ARRAY value to b.
+func appendByteArray(b, v []byte) []byte {
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(v)))
+	return append(append(b, size[:]...), v...)
+}
+
+// Types of the Thrift compact protocol
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the structs of the Parquet metadata with the Thrift
+// compact protocol.
+type thriftWriter struct {
+	b []byte
+	// last is the id of the last field of the current struct, and stack
+	// those of the enclosing structs
+	last  int16
+	stack []int16
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.b = append(t.b, byte(delta)<<4|typ)
+	} else {
+		t.b = appendZigzag(append(t.b, typ), int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.b = appendZigzag(t.b, int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.b = appendZigzag(t.b, v)
+}
+
+func (t *thriftWriter) binary(id int16, v []byte) {
+	t.fieldHeader(id, thriftBinary)
+	t.b = append(appendUvarint(t.b, uint64(len(v))), v...)
+}
+
+func (t *thriftWriter) listHeader(id int16, elem byte, n int) {
+	t.fieldHeader(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|elem)
+		return
+	}
+	t.b = appendUvarint(append(t.b, 0xF0|elem), uint64(n))
+}
+
+// beginStruct starts the struct field id, ended by endStruct.
+func (t *thriftWriter) beginStruct(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginElement()
+}
+
+// beginElement starts a struct of a list, ended by endStruct.
+func (t *thriftWriter) beginElement() {
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.b = append(t.b, 0)
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendZigzag(b []byte, v int64) []byte {
+	return appendUvarint(b, uint64(v<<1^v>>63))
+}
@@ -0,0 +1,171 @@
+package pglogrus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// thriftReader decodes Thrift compact structs as maps of field ids
+type thriftReader struct {
+	b []byte
+	i int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.i:])
+	r.i += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.uvarint())
+		r.i += n
+		return string(r.b[r.i-n : r.i])
+	case thriftList:
+		header := r.b[r.i]
+		r.i++
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(header & 0x0F)
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	}
+	panic("unexpected thrift type")
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var last int16
+	for {
+		header := r.b[r.i]
+		r.i++
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		last = id
+		fields[id] = r.value(header & 0x0F)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	p := newParquetWriter(&buf)
+	t0 := time.Date(2019, 3, 18, 10, 0, 0, 0, time.UTC)
+	entries := []*logrus.Entry{
+		{Level: logrus.InfoLevel, Message: "first", Data: logrus.Fields{"a": 1}, Time: t0},
+		{Level: logrus.ErrorLevel, Message: "second", Data: logrus.Fields{}, Time: t0.Add(time.Millisecond)},
+	}
+	for _, entry := range entries {
+		if err := p.write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := buf.Bytes()
+	if !bytes.HasPrefix(file, []byte("PAR1")) || !bytes.HasSuffix(file, []byte("PAR1")) {
+		t.Fatalf("Expected file to start and end with PAR1, got %q\n", file)
+	}
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := &thriftReader{b: file[len(file)-8-size : len(file)-8]}
+	metadata := footer.structure()
+	if footer.i != size {
+		t.Errorf("Expected footer to be %d bytes, decoded %d\n", size, footer.i)
+	}
+	if metadata[3] != int64(2) {
+		t.Errorf("Expected 2 rows, got %v\n", metadata[3])
+	}
+	var names []interface{}
+	for _, element := range metadata[2].([]interface{}) {
+		names = append(names, element.(map[int16]interface{})[4])
+	}
+	if expected := []interface{}{"schema", "created_at", "level", "message", "message_data"}; !reflect.DeepEqual(expected, names) {
+		t.Errorf("Expected schema to be %v, got %v\n", expected, names)
+	}
+
+	// Read the values of each column chunk
+	rowGroups := metadata[4].([]interface{})
+	if len(rowGroups) != 1 {
+		t.Fatalf("Expected 1 row group, got %d\n", len(rowGroups))
+	}
+	var columns [][]interface{}
+	for _, chunk := range rowGroups[0].(map[int16]interface{})[1].([]interface{}) {
+		meta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+		page := &thriftReader{b: file, i: int(meta[9].(int64))}
+		header := page.structure()
+		if header[5].(map[int16]interface{})[1] != int64(2) {
+			t.Errorf("Expected page to have 2 values, got %v\n", header)
+		}
+		var values []interface{}
+		for i := 0; i < 2; i++ {
+			if meta[1] == int64(parquetInt64) {
+				values = append(values, time.Unix(0, int64(binary.LittleEndian.Uint64(file[page.i:]))*1000).UTC())
+				page.i += 8
+				continue
+			}
+			n := int(binary.LittleEndian.Uint32(file[page.i:]))
+			values = append(values, string(file[page.i+4:page.i+4+n]))
+			page.i += 4 + n
+		}
+		columns = append(columns, values)
+	}
+	expected := [][]interface{}{
+		{t0, t0.Add(time.Millisecond)},
+		{"info", "error"},
+		{"first", "second"},
+		{`{"a":1}`, `{}`},
+	}
+	if !reflect.DeepEqual(expected, columns) {
+		t.Errorf("Expected columns to be %v, got %v\n", expected, columns)
+	}
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	var buf bytes.Buffer
+	p := newParquetWriter(&buf)
+	for i := 0; i < parquetRowGroupSize+1; i++ {
+		if err := p.write(&logrus.Entry{Message: "m", Data: logrus.Fields{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := buf.Bytes()
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	metadata := (&thriftReader{b: file[len(file)-8-size : len(file)-8]}).structure()
+	var rows []interface{}
+	for _, rowGroup := range metadata[4].([]interface{}) {
+		rows = append(rows, rowGroup.(map[int16]interface{})[3])
+	}
+	if expected := []interface{}{int64(parquetRowGroupSize), int64(1)}; !reflect.DeepEqual(expected, rows) {
+		t.Errorf("Expected row groups to have %v rows, got %v\n", expected, rows)
+	}
+}
@@ -0,0 +1,79 @@
+package pglogrus
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PayloadIDColumn references the offloaded payload of an entry, in the
+// PayloadTable.
+const PayloadIDColumn = "payload_id"
+
+// payloadTableSchema returns the SQL statement creating the PayloadTable.
+func (t *TableConfig) payloadTableSchema() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id bigserial PRIMARY KEY,
+    message_data %s NOT NULL,
+    created_at timestamp with time zone NOT NULL
+);`, t.PayloadTable, t.payloadType())
+}
+
+// payloadType returns the type of message_data.
+func (t *TableConfig) payloadType() string {
+	if t.Encoding != nil {
+		return t.Dialect.binaryType()
+	}
+	return "json"
+}
+
+// offloadsPayloads reports whether large payloads are stored in the
+// PayloadTable.
+func (t *TableConfig) offloadsPayloads() bool {
+	return t.PayloadTable != "" && t.Dialect == Postgres && !t.Wide
+}
+
+// offloaded reports whether payload is stored in the PayloadTable.
+func (t *TableConfig) offloaded(payload interface{}) bool {
+	if !t.offloadsPayloads() {
+		return false
+	}
+	b, ok := payload.([]byte)
+	return ok && len(b) > t.PayloadThreshold
+}
+
+// offloadStatement returns the query inserting args in columns, for entry,
+// with its payload (args[2]) inserted in the PayloadTable.
+func (t *TableConfig) offloadStatement(entry *logrus.Entry, columns []string, args []interface{}) string {
+	values := make([]string, len(args))
+	for i := range args {
+		values[i] = t.Dialect.placeholder(i + 1)
+	}
+	values[2] = "'{}'"
+	if t.Encoding != nil {
+		values[2] = "''"
+	}
+	columns = append(columns[:len(columns):len(columns)], PayloadIDColumn)
+	values = append(values, "payload.id")
+	return fmt.Sprintf("WITH payload AS (INSERT INTO %s(message_data, created_at) VALUES (%s,%s) RETURNING id) INSERT INTO %s(%s) SELECT %s FROM payload;",
+		t.PayloadTable, t.Dialect.placeholder(3), t.Dialect.placeholder(4),
+		t.tableName(entry), strings.Join(columns, ", "), strings.Join(values, ","))
+}
+
+// payloadExpression returns the SQL expression selecting the payload of
+// entries, offloaded or not.
+func (t *TableConfig) payloadExpression() string {
+	return fmt.Sprintf("COALESCE((SELECT p.message_data FROM %s p WHERE p.id = %s), message_data) AS message_data", t.PayloadTable, PayloadIDColumn)
+}
+
+// deletedTables returns the tables whose rows are deleted with the entries:
+// the table (or its shards), and the PayloadTable. Payloads have the time of
+// their entry.
+func (t *TableConfig) deletedTables() []string {
+	names := t.tableNames()
+	if t.offloadsPayloads() {
+		names = append(names, t.PayloadTable)
+	}
+	return names
+}
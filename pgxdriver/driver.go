@@ -0,0 +1,60 @@
+// Package pgxdriver provides a pglogrus.Driver writing the batches of an
+// AsyncHook with pgx (github.com/jackc/pgx/v5), and copying entries with the
+// COPY protocol of pgx (see pglogrus.AsyncHook.Copy):
+//
+//	hook.Driver = pgxdriver.New(pool)
+package pgxdriver
+
+import (
+	"context"
+	"strings"
+
+	pglogrus "github.com/gemnasium/logrus-postgresql-hook"
+	"github.com/jackc/pgx/v5"
+)
+
+// A Beginner starts transactions, like *pgxpool.Pool and *pgx.Conn.
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// New returns a Driver writing batches to db, in transactions.
+func New(db Beginner) pglogrus.Driver {
+	return driver{db: db}
+}
+
+type driver struct {
+	db Beginner
+}
+
+func (d driver) BeginBatch(ctx context.Context) (pglogrus.Batch, error) {
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &batch{tx: tx}, nil
+}
+
+type batch struct {
+	tx pgx.Tx
+}
+
+func (b *batch) Insert(ctx context.Context, query string, args ...interface{}) error {
+	_, err := b.tx.Exec(ctx, query, args...)
+	return err
+}
+
+// Copy copies rows with pgx.Tx.CopyFrom. table can be qualified by its schema
+// (eg. "audit.logs").
+func (b *batch) Copy(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
+	_, err := b.tx.CopyFrom(ctx, pgx.Identifier(strings.Split(table, ".")), columns, pgx.CopyFromRows(rows))
+	return err
+}
+
+func (b *batch) Commit() error {
+	return b.tx.Commit(context.Background())
+}
+
+func (b *batch) Rollback() error {
+	return b.tx.Rollback(context.Background())
+}
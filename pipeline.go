@@ -0,0 +1,52 @@
+package pglogrus
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// pipeline writes the entries of some levels, with its own queue and worker.
+type pipeline struct {
+	levels []logrus.Level
+	hook   *AsyncHook
+}
+
+// AddPipeline adds a pipeline writing the entries of levels, instead of the
+// queue of hook. The pipeline returned is an AsyncHook with its own queue,
+// worker and flush policy, so Debug entries batched every 5 seconds and Error
+// entries flushed every 100ms can coexist:
+//
+//	hook.AddPipeline(logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel).FlushEvery(100 * time.Millisecond)
+//	hook.FlushEvery(5 * time.Second)
+//
+// The pipeline shares the config of hook (table, filters, errors handling,
+// sinks, stats...), except the settings of the AsyncHook, and the Driver, set
+// when the pipeline is added. Its queue is counted in the Queued and Lag of
+// the Stats of hook. It's flushed with hook.
+func (hook *AsyncHook) AddPipeline(levels ...logrus.Level) *AsyncHook {
+	p := newAsyncHook(hook.Hook)
+	p.Driver = hook.Driver
+	hook.pipelines = append(hook.pipelines, pipeline{levels: levels, hook: p})
+	return p
+}
+
+// queueFor returns the AsyncHook queuing entry: the pipeline of its level, if
+// any, or hook.
+func (hook *AsyncHook) queueFor(entry *logrus.Entry) *AsyncHook {
+	for _, p := range hook.pipelines {
+		for _, level := range p.levels {
+			if entry.Level == level {
+				return p.hook
+			}
+		}
+	}
+	return hook
+}
+
+// children returns the destinations and pipelines of hook.
+func (hook *AsyncHook) children() []*AsyncHook {
+	children := append([]*AsyncHook(nil), hook.destinations...)
+	for _, p := range hook.pipelines {
+		children = append(children, p.hook)
+	}
+	return children
+}
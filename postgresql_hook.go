@@ -1,11 +1,14 @@
 package pglogrus
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -18,117 +21,492 @@ var BufSize uint = 8192
 
 // Hook to send logs to a PostgreSQL database
 type Hook struct {
+	// Extra fields added to all entries.
+	//
+	// Deprecated: modifying Extra while entries are fired is racy, use
+	// ReplaceExtra instead.
 	Extra      map[string]interface{}
 	db         *sql.DB
 	mu         sync.RWMutex
 	InsertFunc func(*sql.DB, *logrus.Entry) error
+	// Table configures where the default InsertFunc stores the entries.
+	Table TableConfig
+	// ErrorHandler receives the errors occurring in the hook.
+	// By default, errors of the AsyncHook are printed to stderr, and errors
+	// of the Hook are returned by Fire.
+	ErrorHandler func(*ErrorEvent)
+	// ErrorTable is the table where errors are stored, if set (cf
+	// EnsureSchema).
+	ErrorTable string
+	// CheckpointTable is the table where the AsyncHook persists its
+	// Checkpoint: the time of the last committed entry, and the id of the
+	// last inserted row, if set (cf EnsureSchema).
+	CheckpointTable string
+	// BatchTable is the table where the AsyncHook stores the metadata of each
+	// batch (transaction) of entries, if set (cf EnsureSchema).
+	// See also BatchIDColumn.
+	BatchTable string
+	// AnnotationTable is the table where the entries with an AnnotationField
+	// are also stored, as annotations for Grafana, if set (cf EnsureSchema).
+	AnnotationTable string
+	// OutboxTable is the table where the entries logged within a transaction
+	// of the application (see WithTx) are inserted, if set, instead of the
+	// hook table. RelayOutbox then moves them to the hook table (cf
+	// EnsureSchema).
+	OutboxTable string
+	// ControlTables are the tables where entries can be stored with the
+	// TableControlField (eg. "audit.logs"). Entries naming other tables are
+	// stored in the hook table, and the error is reported.
+	ControlTables []string
+	// Retention is the maximum age of the entries, deleted by
+	// EnforceRetention. 0 keeps the entries forever.
+	Retention time.Duration
+	// Disabled makes the hook filter and transform entries as usual, without
+	// writing them to the DB. It's set by NewHook and NewAsyncHook when the
+	// PGLOGRUS_DISABLED environment variable is true, so tests and local
+	// environments can run without a database.
+	Disabled bool
+	// ErrorDepth is how deep errors nested in slices and maps of field values
+	// (like []error or map[string]error) are encoded with their message.
+	// Otherwise, most errors are encoded as {}. It's 3 by default.
+	ErrorDepth int
+	// Scrub makes the hook replace NUL bytes and invalid UTF-8 in messages and
+	// string field values with ScrubReplacement, so entries containing them
+	// are stored instead of being rejected by PostgreSQL. It's true by
+	// default.
+	Scrub bool
+	// ScrubReplacement is "\uFFFD" by default. Set it to "" to remove the
+	// invalid bytes.
+	ScrubReplacement string
+	// DryRun is a writer where the statements inserting entries are written
+	// (with their values), instead of being executed.
+	// Use it to check the Table config before pointing the hook at a real DB.
+	DryRun   io.Writer
+	dryRunMu sync.Mutex
+
 	filters    []filter
+	predicates []Predicate
+	stats      *counters
+	recent     *recentEntries
+	sinks      []*sinkQueue
+	// queues are the counters of the queues of the AsyncHook and its
+	// pipelines, guarded by mu
+	queues []*queueCounters
+	// deadLetters are the sinks of ValidateFields, guarded by mu
+	deadLetters []*sinkQueue
+	// loggerLabels are the labels of the loggers (see LabelLogger)
+	loggerLabels map[*logrus.Logger]string
+	// sessions is true once the session filter was added (see NewSession)
+	sessions    bool
+	middlewares []InsertMiddleware
+	// autoColumns are the columns added by AutoColumns
+	autoColumns []Column
+	// columnsMu serializes the statements adding autoColumns
+	columnsMu sync.Mutex
+	// level is the least severe level of the stored entries plus one, 0 for
+	// all levels (see WithLevel)
+	level uint32
+	// sampling holds the *sampleRates of the hook (see Config.Sampling)
+	sampling atomic.Value
+	// asyncOptions configure the AsyncHook created with the hook, by New or
+	// NewAsync
+	asyncOptions []func(*AsyncHook)
 }
 
 type AsyncHook struct {
+	// queue are the counters of the queue of the hook. Pipelines have their
+	// own, and share the other counters of Hook. It's the first field, to be
+	// 64-bit aligned.
+	queue queueCounters
 	*Hook
 	buf        chan *logrus.Entry
+	urgent     chan *logrus.Entry
 	flush      chan bool
-	wg         sync.WaitGroup
+	pending    completion
 	ticker     *time.Ticker
 	newTicker  chan *time.Ticker
+	syncNow    chan chan error
+	groups     chan groupWrite
 	InsertFunc func(*sql.Tx, *logrus.Entry) error
-}
-
-var insertFunc = func(db *sql.DB, entry *logrus.Entry) error {
-	jsonData, err := json.Marshal(entry.Data)
-	if err != nil {
-		return err
-	}
-
-	_, err = db.Exec("INSERT INTO logs(level, message, message_data, created_at) VALUES ($1,$2,$3,$4);", entry.Level, entry.Message, jsonData, entry.Time)
-	return err
-}
-
-var asyncInsertFunc = func(txn *sql.Tx, entry *logrus.Entry) error {
-	jsonData, err := json.Marshal(entry.Data)
-	if err != nil {
-		return err
-	}
-
-	_, err = txn.Exec("INSERT INTO logs(level, message, message_data, created_at) VALUES ($1,$2,$3,$4);", entry.Level, entry.Message, jsonData, entry.Time)
-	return err
+	// Trace is called when an operation of the hook starts: "batch" (the
+	// transaction writing a batch of entries) or "flush".
+	// The returned func is called when the operation ends.
+	// It can be used to create OpenTelemetry spans, for example.
+	Trace func(op string) func(TraceInfo)
+	// MaxBatchBytes is the estimated size of entries (see EntrySize) after
+	// which a transaction is committed, without waiting for the next tick.
+	// It prevents huge transactions after a burst of logs. 0 means no limit.
+	MaxBatchBytes int
+	// Savepoints makes the hook insert each entry within a savepoint, so an
+	// entry failing to be inserted doesn't abort its whole transaction.
+	// It costs two more statements per entry.
+	Savepoints bool
+	// Copy makes the hook insert the entries of each batch with the bulk
+	// copy protocol of PostgreSQL (see Batch.Copy), much faster for large
+	// batches. A batch is then written all or nothing, and the InsertFunc,
+	// insert middlewares and Savepoints aren't used.
+	Copy bool
+	// OnBatch is called with each batch of entries before it's written, and
+	// returns the entries to write. It allows cross-entry operations, like
+	// deduplication, sorting or aggregation.
+	// Entries must not be modified: return copies instead.
+	OnBatch func(batch []*logrus.Entry) []*logrus.Entry
+	// TargetSelector selects the database where a batch of entries is
+	// written, for example to send floods of Debug entries to a cheaper
+	// instance. When it's nil or returns nil, the hook Driver (or database)
+	// is used.
+	TargetSelector func(batch []*logrus.Entry) *sql.DB
+	// SortBatches makes the hook insert the entries of each batch by
+	// increasing time, keeping the order of entries with the same time.
+	// Inserting in index order reduces page splits and improves locality on
+	// large tables, especially with a fillfactor below 100 (see
+	// TableConfig.StorageParameters).
+	SortBatches bool
+	// Driver writes the batches of entries, SQLDriver(db) by default.
+	// InsertFunc is only used by SQLDriver.
+	Driver Driver
+	// NonBlocking makes Fire drop the entries and return ErrQueueFull when
+	// the queue is full, instead of waiting for the queue to have room.
+	NonBlocking bool
+	// MaxLag is the age of the oldest queued entry (see Stats.Lag) after
+	// which an ErrorEvent with the "lag" Op is reported, once until the hook
+	// catches up. 0 disables the check.
+	MaxLag time.Duration
+	// RateLimit caps the writes of the hook to the DB.
+	RateLimit RateLimit
+	// PriorityLevels are the levels of the entries skipping ahead of the
+	// queue (eg. logrus.ErrorLevel and above), so they don't wait behind
+	// thousands of Debug entries when the queue is backed up. They have their
+	// own queue, of BufSize entries too.
+	PriorityLevels []logrus.Level
+	// QueueTTL is the maximum age of queued entries by level, after which
+	// they're dropped instead of being written (eg. a minute for Debug
+	// entries), to catch up quickly after an outage. The age of entries is
+	// computed from their time. Expired entries are counted in Stats.
+	QueueTTL map[logrus.Level]time.Duration
+	// Outage configures the behavior of the hook when the DB is unavailable
+	// for a while.
+	Outage OutagePolicy
+	// ShedLevels drop the entries of a level when the queue is filling up,
+	// before it's full: the entries of a level are dropped while the queue
+	// is filled above the given ratio (eg. 0.5 for half full), and counted in
+	// Stats.Shed. See DefaultShedLevels.
+	ShedLevels map[logrus.Level]float64
+
+	// outageSince is when transactions started failing, zero if they don't
+	outageSince time.Time
+	// txBucket and rowBucket enforce the RateLimit
+	txBucket  *tokenBucket
+	rowBucket *tokenBucket
+	// destinations are the other hooks where entries are written
+	destinations []*AsyncHook
+	// pipelines write the entries of some levels
+	pipelines []pipeline
+	// running is false when the hook was created Disabled or without DB: it
+	// has no worker
+	running bool
+	// stopped is closed when the worker exits, once the hook is flushed
+	stopped chan struct{}
+	// fast starts (true) and ends (false) the fast ticks of a FlushContext
+	fast chan bool
+	// fastFlushes is the number of FlushContext in progress, ticking every
+	// fastTicker instead of ticker (only used by the worker)
+	fastFlushes int
+	fastTicker  *time.Ticker
+	// lagging is true when the lag exceeding MaxLag was reported
+	lagging bool
 }
 
 type filter func(*logrus.Entry) *logrus.Entry
 
+// A Predicate reports whether an entry must be logged.
+// Predicates only inspect the entry, and are evaluated before the entry is
+// copied by the hook: entries ignored by a predicate don't cost any allocation.
+type Predicate func(*logrus.Entry) bool
+
 // NewHook creates a PGHook to be added to an instance of logger.
 func NewHook(db *sql.DB, extra map[string]interface{}) *Hook {
-	return &Hook{
-		Extra:      extra,
-		db:         db,
-		InsertFunc: insertFunc,
-		filters:    []filter{},
+	hook := &Hook{
+		Extra:   extra,
+		db:      db,
+		Table:   TableConfig{Name: "logs"},
+		filters: []filter{},
+		stats:   &counters{},
+		// Deep enough for map[string][]error
+		ErrorDepth:       3,
+		Scrub:            true,
+		ScrubReplacement: "\uFFFD",
+	}
+	hook.Disabled, _ = strconv.ParseBool(os.Getenv("PGLOGRUS_DISABLED"))
+	hook.InsertFunc = func(db *sql.DB, entry *logrus.Entry) error {
+		return hook.insert(db, entry)
 	}
+	return hook
 }
 
 // NewAsyncHook creates a hook to be added to an instance of logger.
 // The hook created will be asynchronous, and it's the responsibility of the user to call the Flush method
 // before exiting to empty the log queue.
 func NewAsyncHook(db *sql.DB, extra map[string]interface{}) *AsyncHook {
+	return newAsyncHook(NewHook(db, extra))
+}
+
+// newAsyncHook creates an asynchronous hook wrapping h, and starts its
+// worker.
+func newAsyncHook(h *Hook) *AsyncHook {
 	hook := &AsyncHook{
-		Hook:       NewHook(db, extra),
-		buf:        make(chan *logrus.Entry, BufSize),
-		flush:      make(chan bool),
-		ticker:     time.NewTicker(time.Second),
-		newTicker:  make(chan *time.Ticker),
-		InsertFunc: asyncInsertFunc,
-	}
-	go hook.fire() // Log in background
+		Hook:      h,
+		buf:       make(chan *logrus.Entry, BufSize),
+		urgent:    make(chan *logrus.Entry, BufSize),
+		flush:     make(chan bool),
+		ticker:    time.NewTicker(time.Second),
+		newTicker: make(chan *time.Ticker),
+		fast:      make(chan bool),
+		syncNow:   make(chan chan error),
+		groups:    make(chan groupWrite),
+		stopped:   make(chan struct{}),
+	}
+	h.addQueue(&hook.queue)
+	hook.InsertFunc = func(txn *sql.Tx, entry *logrus.Entry) error {
+		return hook.insert(txn, entry)
+	}
+	// Apply the settings of the options, before the worker reads them
+	for _, configure := range h.asyncOptions {
+		configure(hook)
+	}
+	h.asyncOptions = nil
+	if !hook.Disabled && hook.db != nil {
+		hook.running = true
+		go hook.fire() // Log in background
+	}
 	return hook
 }
 
 func (hook *Hook) Fire(entry *logrus.Entry) error {
+	atomic.AddUint64(&hook.stats.fired, 1)
+	start := time.Now()
 	newEntry := hook.newEntry(entry)
+	hook.observe(stageFilter, start)
 	if newEntry == nil {
 		// entry is ignored.
+		atomic.AddUint64(&hook.stats.ignored, 1)
 		return nil
 	}
-	return hook.InsertFunc(hook.db, newEntry)
-
+	if hook.DryRun != nil {
+		return hook.dryRun(newEntry)
+	}
+	if hook.Disabled {
+		return nil
+	}
+	if tx := entryTx(newEntry); tx != nil {
+		return hook.writeInTx(tx, newEntry)
+	}
+	insert := hook.wrapInsert(func(entry *logrus.Entry) error {
+		return hook.InsertFunc(hook.db, entry)
+	})
+	err := newDBError(insert(newEntry))
+	if err == nil {
+		if query, args, ok := hook.annotationStatement(newEntry); ok {
+			if _, err := hook.db.Exec(query, args...); err != nil {
+				hook.handleError(&ErrorEvent{Time: time.Now(), Op: "annotation", Err: err, Entry: newEntry})
+			}
+		}
+		hook.committed(newEntry.Time)
+		hook.addRecent(newEntry)
+		hook.sink(newEntry)
+	}
+	if err != nil {
+		atomic.AddUint64(&hook.stats.dropped, 1)
+		if hook.ErrorHandler != nil || hook.ErrorTable != "" {
+			hook.handleError(&ErrorEvent{Time: time.Now(), Op: "insert", Err: err, Entry: newEntry})
+		} else {
+			atomic.AddUint64(&hook.stats.errors, 1)
+		}
+		return err
+	}
+	atomic.AddUint64(&hook.stats.written, 1)
+	return nil
 }
 
 // Fire is called when a log event is fired.
 // We assume the entry will be altered by another hook,
 // otherwise we might logging something wrong to PostgreSQL
+// If the queue is full, Fire blocks, unless the hook is NonBlocking.
 func (hook *AsyncHook) Fire(entry *logrus.Entry) error {
+	atomic.AddUint64(&hook.stats.fired, 1)
+	start := time.Now()
 	newEntry := hook.newEntry(entry)
+	hook.observe(stageFilter, start)
 	if newEntry == nil {
 		// entry is ignored.
+		atomic.AddUint64(&hook.stats.ignored, 1)
 		return nil
 	}
-	hook.wg.Add(1)
-	hook.buf <- newEntry
+	if hook.DryRun != nil {
+		return hook.dryRun(newEntry)
+	}
+	if tx := entryTx(newEntry); tx != nil {
+		return hook.writeInTx(tx, newEntry)
+	}
+	if grouped(newEntry) {
+		return nil
+	}
+	queue := hook.queueFor(newEntry)
+	err := queue.enqueue(newEntry)
+	for _, dest := range hook.destinations {
+		if destErr := dest.enqueue(newEntry); err == nil {
+			err = destErr
+		}
+	}
+	if err == nil && entryControls(newEntry).sync {
+		return queue.Sync()
+	}
+	return err
+}
+
+// enqueue adds entry to the queue of entries to write
+func (hook *AsyncHook) enqueue(newEntry *logrus.Entry) error {
+	if hook.Disabled || !hook.active() {
+		return nil
+	}
+	defer hook.observe(stageEnqueue, time.Now())
+	buf := hook.buf
+	if hook.prioritized(newEntry) {
+		buf = hook.urgent
+	}
+	if ratio, ok := hook.shedLevels()[newEntry.Level]; ok && float64(len(buf)) >= ratio*float64(cap(buf)) {
+		atomic.AddUint64(&hook.stats.dropped, 1)
+		if newEntry.Level <= logrus.TraceLevel {
+			atomic.AddUint64(&hook.stats.shed[newEntry.Level], 1)
+		}
+		return nil
+	}
+	if hook.NonBlocking {
+		hook.pending.add()
+		select {
+		case buf <- newEntry:
+			atomic.AddUint64(&hook.queue.queued, 1)
+			return nil
+		default:
+			hook.pending.cancel()
+			atomic.AddUint64(&hook.stats.dropped, 1)
+			return ErrQueueFull
+		}
+	}
+	atomic.AddUint64(&hook.queue.queued, 1)
+	hook.pending.add()
+	select {
+	case buf <- newEntry:
+	case <-hook.stopped:
+		// Flushed meanwhile
+		atomic.AddUint64(&hook.queue.queued, ^uint64(0))
+		hook.pending.cancel()
+	}
 	return nil
 }
 
+// active reports whether the worker of the hook writes the queued entries:
+// it stops once the hook is flushed.
+func (hook *AsyncHook) active() bool {
+	if !hook.running {
+		return false
+	}
+	select {
+	case <-hook.stopped:
+		return false
+	default:
+		return true
+	}
+}
+
+// dropExpired returns the entries of batch which didn't exceed their
+// QueueTTL.
+func (hook *AsyncHook) dropExpired(batch []*logrus.Entry) []*logrus.Entry {
+	if len(hook.QueueTTL) == 0 {
+		return batch
+	}
+	now := time.Now()
+	kept := batch[:0:0]
+	for _, entry := range batch {
+		if ttl, ok := hook.QueueTTL[entry.Level]; ok && now.Sub(entry.Time) > ttl {
+			atomic.AddUint64(&hook.stats.expired, 1)
+			atomic.AddUint64(&hook.stats.dropped, 1)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept
+}
+
+// DefaultShedLevels returns the ShedLevels dropping Trace entries once the
+// queue is half full, then Debug entries, then Info entries, preserving
+// Warnings and above.
+func DefaultShedLevels() map[logrus.Level]float64 {
+	return map[logrus.Level]float64{
+		logrus.TraceLevel: 0.5,
+		logrus.DebugLevel: 0.7,
+		logrus.InfoLevel:  0.9,
+	}
+}
+
+// prioritized reports whether entry is queued in the priority queue.
+func (hook *AsyncHook) prioritized(entry *logrus.Entry) bool {
+	if hook.urgent == nil {
+		return false
+	}
+	for _, level := range hook.PriorityLevels {
+		if entry.Level == level {
+			return true
+		}
+	}
+	return false
+}
+
 // newEntry will prepare a new logrus entry to be logged in the DB
 // the extra fields are added to entry Data
 func (hook *Hook) newEntry(entry *logrus.Entry) *logrus.Entry {
+	// Entries about the hook itself, or skipped, are never stored
+	if _, ok := entry.Data[InternalField]; ok {
+		return hook.ignore(ignoredSkip)
+	}
+	if _, ok := entry.Data[SkipControlField]; ok {
+		return hook.ignore(ignoredSkip)
+	}
+
+	// Apply predicates first, to avoid copying ignored entries
+	if hook.ignoredLevel(entry.Level) {
+		return hook.ignore(ignoredLevel)
+	}
+	if hook.sampledOut(entry) {
+		return hook.ignore(ignoredSampling)
+	}
+	for _, fn := range hook.predicates {
+		if !fn(entry) {
+			return hook.ignore(ignoredPredicate)
+		}
+	}
+
+	// Take a snapshot of the extra fields: ReplaceExtra swaps the map instead
+	// of modifying it. Filters are only appended (by LabelLogger and
+	// NewSession), the snapshot of the slice isn't modified either.
 	hook.mu.RLock() // Claim the mutex as a RLock - allowing multiple go routines to log simultaneously
-	defer hook.mu.RUnlock()
+	extra, filters := hook.Extra, hook.filters
+	hook.mu.RUnlock()
 
 	// Don't modify entry.Data directly, as the entry will used after this hook was fired
 	data := map[string]interface{}{}
 
 	// Merge extra fields
-	for k, v := range hook.Extra {
+	for k, v := range extra {
 		data[k] = v
 	}
 	for k, v := range entry.Data {
-		data[k] = v
-		if k == logrus.ErrorKey {
-			asError, isError := v.(error)
-			_, isMarshaler := v.(json.Marshaler)
-			if isError && !isMarshaler {
-				data[k] = newMarshalableError(asError)
-			}
+		encoded, err := encodeField(v)
+		if err != nil {
+			hook.handleError(&ErrorEvent{Time: time.Now(), Op: "filter", Err: fmt.Errorf("pglogrus: can't encode field %q: %v", k, err), Entry: entry})
+			encoded = v
 		}
+		data[k] = marshalableErrors(encoded, hook.ErrorDepth)
 	}
 
 	newEntry := &logrus.Entry{
@@ -138,18 +516,35 @@ func (hook *Hook) newEntry(entry *logrus.Entry) *logrus.Entry {
 		Level:   entry.Level,
 		Caller:  entry.Caller,
 		Message: entry.Message,
+		Context: entry.Context,
 	}
 
 	// Apply filters
-	for _, fn := range hook.filters {
-		newEntry = fn(newEntry)
+	for _, fn := range filters {
+		newEntry = hook.applyFilter(fn, newEntry)
 		if newEntry == nil {
-			break
+			return hook.ignore(ignoredFilter)
 		}
 	}
+	hook.stripControls(newEntry)
+	if hook.Scrub {
+		hook.scrubEntry(newEntry)
+	}
 	return newEntry
 }
 
+// applyFilter applies fn to entry.
+// If fn panics, the error is reported and the entry is ignored.
+func (hook *Hook) applyFilter(fn filter, entry *logrus.Entry) (newEntry *logrus.Entry) {
+	defer func() {
+		if r := recover(); r != nil {
+			hook.handleError(&ErrorEvent{Time: time.Now(), Op: "filter", Err: fmt.Errorf("%v", r), Entry: entry})
+			newEntry = nil
+		}
+	}()
+	return fn(entry)
+}
+
 // Levels returns the available logging levels.
 func (hook *Hook) Levels() []logrus.Level {
 	return []logrus.Level{
@@ -162,6 +557,18 @@ func (hook *Hook) Levels() []logrus.Level {
 	}
 }
 
+// ReplaceExtra replaces the extra fields of the hook with a copy of extra.
+// It's safe to call while entries are fired.
+func (hook *Hook) ReplaceExtra(extra map[string]interface{}) {
+	copied := make(map[string]interface{}, len(extra))
+	for k, v := range extra {
+		copied[k] = v
+	}
+	hook.mu.Lock()
+	hook.Extra = copied
+	hook.mu.Unlock()
+}
+
 // Blacklist filters entry field values.
 // This useful when you want your application to log extra fields locally
 // but don't want pg to store them.
@@ -173,66 +580,197 @@ func (hook *Hook) Blacklist(b []string) {
 // This func is meant to be used when the hook was created with NewAsyncHook,
 // and should be used when exiting a program to purge the logs without
 // restarting new DB transactions.
+// See FlushContext to know how many entries were persisted.
 func (hook *AsyncHook) Flush() {
-	hook.newTicker <- time.NewTicker(100 * time.Millisecond)
-	hook.wg.Wait()
-	hook.flush <- true
-	<-hook.flush
+	hook.FlushContext(context.Background())
+}
+
+// AddDestination adds a database where entries are written too.
+// Entries are filtered and transformed once by hook, and then queued to each
+// destination.
+// The destination returned is an AsyncHook with its own queue, ticker and
+// errors handling, using a copy of the hook Table config. It can be configured
+// independently, and is flushed with hook.
+// To keep logging when a destination is unavailable, make it NonBlocking.
+func (hook *AsyncHook) AddDestination(db *sql.DB) *AsyncHook {
+	dest := NewAsyncHook(db, nil)
+	dest.Table = hook.Table
+	dest.Table.Columns = append([]Column(nil), hook.Table.Columns...)
+	dest.Disabled = hook.Disabled
+	hook.destinations = append(hook.destinations, dest)
+	return dest
+}
+
+// FlushTimeout is like Flush, but returns ErrFlushTimeout if the queue
+// couldn't be flushed within timeout. The flush carries on in the background.
+func (hook *AsyncHook) FlushTimeout(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		hook.Flush()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return ErrFlushTimeout
+	}
+}
+
+// Sync writes the queued entries to the DB, and waits for their transaction
+// to be committed.
+// Unlike Flush, the hook keeps logging after Sync.
+// It returns a *BatchError if entries of the transaction couldn't be written,
+// and ErrFlushed once the hook is flushed.
+func (hook *AsyncHook) Sync() error {
+	var err error
+	for _, dest := range hook.children() {
+		if destErr := dest.Sync(); err == nil {
+			err = destErr
+		}
+	}
+	if !hook.running {
+		return err
+	}
+	synced := make(chan error, 1)
+	select {
+	case hook.syncNow <- synced:
+	case <-hook.stopped:
+		return ErrFlushed
+	}
+	if syncErr := <-synced; syncErr != nil {
+		return syncErr
+	}
+	return err
 }
 
 // LoopDuration sets the internal hook ticker.
 // Every duration d, the hook will send the queued logs to the DB.
 // The default loop duration is 1 second.
+// It has no effect once the hook is flushed.
 func (hook *AsyncHook) FlushEvery(d time.Duration) {
-	hook.newTicker <- time.NewTicker(d)
+	if !hook.running {
+		return
+	}
+	t := time.NewTicker(d)
+	select {
+	case hook.newTicker <- t:
+	case <-hook.stopped:
+		t.Stop()
+	}
 }
 
 // fire loops on the 'buf' channel, and writes entries to the DB
 func (hook *AsyncHook) fire() {
 	for {
-		var err error
-		txn, err := hook.db.Begin()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "[pglogrus] Can't create db transaction:", err)
-			// Don't create new transactions too fast, it will flood stderr
-			select {
-			case <-hook.ticker.C:
-				continue
+		var batch []*logrus.Entry
+		var bytes int
+		var flush bool
+		var synced chan error
+		var group *groupWrite
+		var started time.Time
+		add := func(entry *logrus.Entry) bool {
+			if len(batch) == 0 {
+				hook.setOldestQueued(entry)
+				started = time.Now()
+			}
+			batch = append(batch, entry)
+			if hook.MaxBatchBytes > 0 {
+				bytes += EntrySize(entry)
+				return bytes >= hook.MaxBatchBytes
 			}
+			return false
 		}
-
-		var numEntries int
-		var flush bool
 	Loop:
 		for {
+			// Priority entries skip ahead of the others
+			select {
+			case entry := <-hook.urgent:
+				if add(entry) {
+					break Loop
+				}
+				continue
+			default:
+			}
 			select {
 			case t := <-hook.newTicker:
+				hook.ticker.Stop()
 				hook.ticker = t
+			case fast := <-hook.fast:
+				hook.fastFlush(fast)
+			case entry := <-hook.urgent:
+				if add(entry) {
+					break Loop
+				}
 			case entry := <-hook.buf:
-				err = hook.InsertFunc(txn, entry)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "[pglogrus] Can't insert entry (%v): %v\n", entry, err)
+				if add(entry) {
+					break Loop
 				}
-				numEntries++
-			case <-hook.ticker.C:
-				if numEntries > 0 {
+			case <-hook.tick():
+				hook.checkLag()
+				if len(batch) > 0 {
 					break Loop
 				}
+			case synced = <-hook.syncNow:
+				// Write the entries queued before Sync was called
+				for len(hook.urgent) > 0 {
+					add(<-hook.urgent)
+				}
+				for len(hook.buf) > 0 {
+					add(<-hook.buf)
+				}
+				break Loop
+			case write := <-hook.groups:
+				group = &write
+				break Loop
 			case flush = <-hook.flush:
 				break Loop
 			}
 		}
 
-		err = txn.Commit()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "[pglogrus] Can't commit transaction:", err)
+		var failures []EntryError
+		var written int
+		if len(batch) > 0 {
+			hook.observe(stageBatchWait, started)
+			toWrite := batch
+			if hook.OnBatch != nil {
+				toWrite = hook.OnBatch(batch)
+				if len(toWrite) < len(batch) {
+					atomic.AddUint64(&hook.stats.ignored, uint64(len(batch)-len(toWrite)))
+					atomic.AddUint64(&hook.stats.ignoredBy[ignoredBatch], uint64(len(batch)-len(toWrite)))
+				}
+			}
+			toWrite = hook.dropExpired(toWrite)
+			if len(toWrite) > 0 {
+				toWrite = hook.limitRate(toWrite)
+			}
+			if len(toWrite) > 0 {
+				failures = hook.writeBatch(toWrite, bytes)
+				written = len(toWrite) - len(failures)
+			}
+			atomic.AddUint64(&hook.queue.queued, ^uint64(len(batch)-1))
+			atomic.StoreInt64(&hook.queue.oldestQueued, 0)
 		}
-
-		for i := 0; i < numEntries; i++ {
-			hook.wg.Done()
+		hook.pending.ack(len(batch), written, len(failures))
+		if synced != nil {
+			if len(failures) > 0 {
+				synced <- &BatchError{Failed: failures}
+			} else {
+				synced <- nil
+			}
+		}
+		if group != nil {
+			hook.writeGroup(*group)
 		}
 
 		if flush {
+			// Entries fired from now on are dropped
+			close(hook.stopped)
+			if hook.fastTicker != nil {
+				hook.fastTicker.Stop()
+			}
 			hook.flush <- true
 			// Exit the main loop to avoid creating new transactions
 			return
@@ -240,15 +778,45 @@ func (hook *AsyncHook) fire() {
 	}
 }
 
+// insertWithSavepoint inserts entry within a savepoint of txn, rolled back if
+// the insert fails.
+func (hook *AsyncHook) insertWithSavepoint(ctx context.Context, txn Batch, entry *logrus.Entry) error {
+	return withSavepoint(ctx, txn, "pglogrus_entry", func() error {
+		return hook.insertEntry(ctx, txn, entry)
+	})
+}
+
+// withSavepoint runs the statements of fn within the savepoint name of txn,
+// rolled back if fn fails, so the failure doesn't abort the transaction.
+func withSavepoint(ctx context.Context, txn Batch, name string, fn func() error) error {
+	if err := txn.Insert(ctx, "SAVEPOINT "+name+";"); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if rbErr := txn.Insert(ctx, "ROLLBACK TO SAVEPOINT "+name+";"); rbErr != nil {
+			return fmt.Errorf("%v (rollback to savepoint failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	return txn.Insert(ctx, "RELEASE SAVEPOINT "+name+";")
+}
+
 func (hook *Hook) Close() error {
 	return hook.db.Close()
 }
 
-//AddFilter adds filter that can modify or ignore entry.
+// AddFilter adds filter that can modify or ignore entry.
 func (hook *Hook) AddFilter(fn filter) {
 	hook.filters = append(hook.filters, fn)
 }
 
+// AddPredicate adds a predicate to ignore entries.
+// Predicates receive the entry as logged, without the hook extra fields, and
+// must not modify it. Use AddFilter to modify entries.
+func (hook *Hook) AddPredicate(fn Predicate) {
+	hook.predicates = append(hook.predicates, fn)
+}
+
 func blackListFilter(blacklist []string) filter {
 	return func(entry *logrus.Entry) *logrus.Entry {
 		for _, name := range blacklist {
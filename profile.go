@@ -0,0 +1,83 @@
+package pglogrus
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A Profile is a preset of options suited for an environment.
+type Profile int
+
+const (
+	// ProfileDevelopment writes entries quickly, keeps the recent entries
+	// in memory for the DebugHandler, and stores the caller of entries.
+	// Entries are kept for a week.
+	ProfileDevelopment Profile = iota
+	// ProfileHighThroughput favors the application over the completeness of
+	// logs: logging never blocks, the least severe entries are shed when the
+	// queue fills up, and stale Debug and Trace entries are dropped after an
+	// outage. Transactions are bounded, and the table is tuned for large
+	// append-only volumes. Entries are kept for 30 days.
+	ProfileHighThroughput
+	// ProfileAudit favors the durability and traceability of logs: logging
+	// blocks when the queue is full, each entry is inserted in a savepoint,
+	// batches and checkpoints are recorded (in the "pglogrus_batches" and
+	// "pglogrus_checkpoints" tables), entries have a log ID, and common
+	// secret fields are removed. Entries are kept for a year.
+	ProfileAudit
+)
+
+// Retention of the entries of each profile (see Hook.Retention)
+var profileRetention = map[Profile]time.Duration{
+	ProfileDevelopment:    7 * 24 * time.Hour,
+	ProfileHighThroughput: 30 * 24 * time.Hour,
+	ProfileAudit:          365 * 24 * time.Hour,
+}
+
+// auditRedactedFields are removed from the entries by ProfileAudit
+var auditRedactedFields = []string{"password", "passwd", "secret", "token", "api_key", "authorization", "cookie"}
+
+// WithProfile applies the preset options of profile. Options following it,
+// and settings changed on the hook returned, override the profile.
+// The settings of the AsyncHook are only applied by NewAsync and
+// NewAsyncHookDSN. The Retention of the profile is enforced by
+// EnforceRetention.
+func WithProfile(profile Profile) Option {
+	return func(o *options) error {
+		hook := o.hook
+		switch profile {
+		case ProfileDevelopment:
+			hook.KeepRecent(100)
+			hook.AddCaller(CallerOptions{PathElements: 2})
+			hook.asyncOptions = append(hook.asyncOptions, func(a *AsyncHook) {
+				a.ticker.Stop()
+				a.ticker = time.NewTicker(100 * time.Millisecond)
+			})
+		case ProfileHighThroughput:
+			hook.Table.TimeIndex = "brin"
+			hook.Table.StorageParameters = AppendOnlyStorage()
+			hook.asyncOptions = append(hook.asyncOptions, func(a *AsyncHook) {
+				a.NonBlocking = true
+				a.MaxBatchBytes = 8 << 20
+				a.SortBatches = true
+				a.PriorityLevels = []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+				a.ShedLevels = DefaultShedLevels()
+				a.QueueTTL = map[logrus.Level]time.Duration{logrus.DebugLevel: time.Minute, logrus.TraceLevel: time.Minute}
+			})
+		case ProfileAudit:
+			hook.Blacklist(auditRedactedFields)
+			hook.AddLogID()
+			hook.CheckpointTable = "pglogrus_checkpoints"
+			hook.BatchTable = "pglogrus_batches"
+			hook.asyncOptions = append(hook.asyncOptions, func(a *AsyncHook) {
+				a.Savepoints = true
+			})
+		default:
+			return fmt.Errorf("pglogrus: unknown profile %d", profile)
+		}
+		hook.Retention = profileRetention[profile]
+		return nil
+	}
+}
@@ -0,0 +1,128 @@
+package pglogrus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Cursor is the position of a stored entry in the table, to resume a query
+// after it (see QueryOptions.After).
+type Cursor struct {
+	Time time.Time
+	ID   int64
+	// Shard is the index of the shard of the entry, if the table is sharded.
+	Shard int
+}
+
+// Cursor returns the position of the entry.
+func (e *StoredEntry) Cursor() Cursor {
+	return Cursor{Time: e.CreatedAt.Time(), ID: e.ID, Shard: e.Shard}
+}
+
+// QueryOptions configure Hook.Query.
+type QueryOptions struct {
+	// From and To restrict the query to entries created in [From, To), if
+	// set.
+	From, To time.Time
+	// After restricts the query to the entries after the cursor, in the
+	// order of the query: the Cursor of the last entry of the previous page.
+	After *Cursor
+	// Limit is the maximum number of entries returned, 100 by default.
+	Limit int
+	// Descending returns the latest entries first.
+	Descending bool
+	// Where restricts the query to the entries matching all filters.
+	Where []Filter
+}
+
+// Query returns a page of the entries stored in the table, ordered by time
+// and id (and shard, for sharded tables). Pages are read with keyset pagination, rather than with an OFFSET,
+// so reading the next page is fast even on very large tables, and isn't
+// affected by new entries:
+//
+//	entries, err := hook.Query(ctx, pglogrus.QueryOptions{Limit: 50})
+//	next := entries[len(entries)-1].Cursor()
+//	entries, err = hook.Query(ctx, pglogrus.QueryOptions{Limit: 50, After: &next})
+func (hook *Hook) Query(ctx context.Context, opts QueryOptions) ([]*StoredEntry, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 100
+	}
+	table := hook.table()
+	if len(opts.Where) > 0 && table.Dialect != Postgres {
+		return nil, fmt.Errorf("pglogrus: filters need PostgreSQL")
+	}
+	c := conditions{dialect: table.Dialect}
+	c.timeRange(opts.From, opts.To)
+	c.filter(table, opts.Where)
+	order, direction := ">", "ASC"
+	if opts.Descending {
+		order, direction = "<", "DESC"
+	}
+	// The ids of sharded tables are only unique by shard
+	sharded := table.Shards > 1
+	if opts.After != nil {
+		if sharded {
+			c.add(fmt.Sprintf("(created_at, id, %s) %s (%s, %s, %s)", shardColumn, order, c.arg(opts.After.Time), c.arg(opts.After.ID), c.arg(opts.After.Shard)))
+		} else {
+			c.add(fmt.Sprintf("(created_at, id) %s (%s, %s)", order, c.arg(opts.After.Time), c.arg(opts.After.ID)))
+		}
+	}
+	clauses := fmt.Sprintf("%s ORDER BY created_at %s, id %[2]s", c.where(), direction)
+	if sharded {
+		clauses += fmt.Sprintf(", %s %s", shardColumn, direction)
+	}
+	clauses += fmt.Sprintf(" LIMIT %d", opts.Limit)
+
+	rows, err := hook.db.QueryContext(ctx, table.SelectQuery(clauses), c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []*StoredEntry
+	for rows.Next() {
+		entry, err := table.ScanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// conditions build the WHERE clause of a query, with its arguments.
+type conditions struct {
+	dialect    Dialect
+	conditions []string
+	args       []interface{}
+}
+
+// arg adds the argument v, and returns its placeholder.
+func (c *conditions) arg(v interface{}) string {
+	c.args = append(c.args, v)
+	return c.dialect.placeholder(len(c.args))
+}
+
+// add adds the SQL condition expr.
+func (c *conditions) add(expr string) {
+	c.conditions = append(c.conditions, expr)
+}
+
+// timeRange restricts the query to entries created in [from, to), if set.
+func (c *conditions) timeRange(from, to time.Time) {
+	if !from.IsZero() {
+		c.add("created_at >= " + c.arg(from))
+	}
+	if !to.IsZero() {
+		c.add("created_at < " + c.arg(to))
+	}
+}
+
+// where returns the WHERE clause, if any.
+func (c *conditions) where() string {
+	if len(c.conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(c.conditions, " AND ")
+}
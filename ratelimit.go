@@ -0,0 +1,101 @@
+package pglogrus
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RateLimit caps the writes of an AsyncHook to the DB, so a burst of logs
+// can't consume the IOPS budget of a shared database.
+// The limits are token buckets: they allow bursts of one second of writes.
+type RateLimit struct {
+	// RowsPerSec is the maximum number of entries inserted per second.
+	// 0 means no limit.
+	RowsPerSec float64
+	// TxPerSec is the maximum number of transactions per second. When it's
+	// reached, the entries are queued, and written in larger batches.
+	// 0 means no limit.
+	TxPerSec float64
+	// Sample drops the entries exceeding RowsPerSec (counted as dropped in
+	// Stats), instead of queuing them until they can be written.
+	Sample bool
+}
+
+// tokenBucket is a token bucket filled at rate tokens per second, holding up
+// to one second of tokens. It's used by a single goroutine.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: burst(rate), last: now}
+}
+
+// burst returns the size of a bucket filled at rate.
+func burst(rate float64) float64 {
+	if rate < 1 {
+		return 1
+	}
+	return rate
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if max := burst(b.rate); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+}
+
+// take takes n tokens, and returns how long to wait before using them.
+func (b *tokenBucket) take(n int, now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// available takes up to n available tokens, and returns how many were taken.
+func (b *tokenBucket) available(n int, now time.Time) int {
+	b.refill(now)
+	if b.tokens < 1 {
+		return 0
+	}
+	if float64(n) > b.tokens {
+		n = int(b.tokens)
+	}
+	b.tokens -= float64(n)
+	return n
+}
+
+// limitRate waits for the RateLimit of the hook to allow writing batch, and
+// returns the entries to write.
+func (hook *AsyncHook) limitRate(batch []*logrus.Entry) []*logrus.Entry {
+	limit := hook.RateLimit
+	now := time.Now()
+	if limit.TxPerSec > 0 {
+		if hook.txBucket == nil {
+			hook.txBucket = newTokenBucket(limit.TxPerSec, now)
+		}
+		time.Sleep(hook.txBucket.take(1, now))
+	}
+	if limit.RowsPerSec > 0 {
+		if hook.rowBucket == nil {
+			hook.rowBucket = newTokenBucket(limit.RowsPerSec, now)
+		}
+		if limit.Sample {
+			n := hook.rowBucket.available(len(batch), time.Now())
+			atomic.AddUint64(&hook.stats.dropped, uint64(len(batch)-n))
+			return batch[:n]
+		}
+		time.Sleep(hook.rowBucket.take(len(batch), time.Now()))
+	}
+	return batch
+}
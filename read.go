@@ -0,0 +1,170 @@
+package pglogrus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// shardColumn is the index of the shard of the entries selected from all the
+// shards of a table.
+const shardColumn = "pglogrus_shard"
+
+// SelectQuery returns a query selecting the entries of the table, or of all
+// its shards, with the given SQL clauses (eg. "WHERE created_at < $1").
+// The index of the shard of the entries is selected as pglogrus_shard.
+// Rows of the query can be read with ScanEntry.
+func (t *TableConfig) SelectQuery(clauses string) string {
+	columns := t.selectedColumns()
+	if t.Shards > 1 || t.offloadsPayloads() {
+		// The source already selects the payloads
+		columns[3] = "message_data"
+	}
+	if t.Shards > 1 {
+		columns = append(columns, shardColumn)
+	}
+	return strings.TrimSpace(fmt.Sprintf("SELECT %s FROM %s %s", strings.Join(columns, ", "), t.source(), strings.TrimSpace(clauses))) + ";"
+}
+
+// selectedColumns returns the columns of the entries selected from the table.
+func (t *TableConfig) selectedColumns() []string {
+	columns := []string{"id", "level", "message", "message_data", "created_at"}
+	for _, c := range t.Columns {
+		columns = append(columns, c.Name)
+	}
+	if t.Wide {
+		columns[3] = "NULL AS message_data"
+		if t.UnknownColumn != "" {
+			columns = append(columns, t.UnknownColumn)
+		}
+	}
+	if t.offloadsPayloads() {
+		columns[3] = t.payloadExpression()
+	}
+	return columns
+}
+
+// source returns the FROM item of the entries of the table, or of all its
+// shards, with their offloaded payloads in message_data.
+func (t *TableConfig) source() string {
+	switch {
+	case t.Shards > 1:
+		return t.from(t.selectedColumns(), true)
+	case t.offloadsPayloads():
+		return fmt.Sprintf("(SELECT %s FROM %s) AS %s", strings.Join(t.selectedColumns(), ", "), t.Name, t.indexPrefix())
+	}
+	return t.Name
+}
+
+// from returns the FROM item selecting columns from the table, or from all
+// its shards, with the index of their shard as pglogrus_shard if withShard.
+func (t *TableConfig) from(columns []string, withShard bool) string {
+	if t.Shards <= 1 {
+		return t.Name
+	}
+	shards := make([]string, t.Shards)
+	for i, name := range t.tableNames() {
+		selected := strings.Join(columns, ", ")
+		if withShard {
+			selected += fmt.Sprintf(", %d AS %s", i, shardColumn)
+		}
+		shards[i] = fmt.Sprintf("SELECT %s FROM %s", selected, name)
+	}
+	return fmt.Sprintf("(%s) AS %s", strings.Join(shards, " UNION ALL "), t.indexPrefix())
+}
+
+// ScanEntry reads an entry from rows of a query built with SelectQuery.
+func (t *TableConfig) ScanEntry(rows *sql.Rows) (*StoredEntry, error) {
+	stored := &StoredEntry{table: t}
+	dest := []interface{}{&stored.ID, &stored.Level, &stored.Message, &stored.Data, &stored.CreatedAt}
+	names := make([]string, 0, len(t.Columns)+1)
+	for _, c := range t.Columns {
+		names = append(names, c.Name)
+	}
+	if t.Wide && t.UnknownColumn != "" {
+		names = append(names, t.UnknownColumn)
+	}
+	values := make([]interface{}, len(names))
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	if t.Shards > 1 {
+		dest = append(dest, &stored.Shard)
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	stored.Columns = make(map[string]interface{}, len(names))
+	for i, name := range names {
+		if b, ok := values[i].([]byte); ok {
+			// The buffers of the driver may be reused by the next row
+			values[i] = string(b)
+		}
+		stored.Columns[name] = values[i]
+	}
+	return stored, nil
+}
+
+// scanEntry reads an entry from rows of a query built with SelectQuery, as a
+// logrus entry.
+func (t *TableConfig) scanEntry(rows *sql.Rows) (*logrus.Entry, error) {
+	stored, err := t.ScanEntry(rows)
+	if err != nil {
+		return nil, err
+	}
+	return stored.Entry()
+}
+
+// Entry returns the stored entry as a logrus entry. Values of the columns are
+// added back to the entry fields. The relocated fields of wide tables are
+// added as a text field named after the UnknownColumn.
+func (e *StoredEntry) Entry() (*logrus.Entry, error) {
+	entry := &logrus.Entry{
+		Level:   logrus.Level(e.Level),
+		Message: e.Message,
+		Time:    e.CreatedAt.Time(),
+		Data:    logrus.Fields{},
+	}
+	for k, v := range e.Data {
+		entry.Data[k] = v
+	}
+	if e.table == nil {
+		return entry, nil
+	}
+	if e.table.Wide && e.table.UnknownColumn != "" {
+		if unknown, ok := e.Columns[e.table.UnknownColumn].(string); ok {
+			entry.Data[e.table.UnknownColumn] = unknown
+		}
+	}
+	for _, c := range e.table.Columns {
+		v := e.Columns[c.Name]
+		if v == nil {
+			continue
+		}
+		if _, ok := c.srid(); ok && len(c.Fields) > 0 {
+			// Points can't be split back into their fields
+			entry.Data[c.Name] = v
+			continue
+		}
+		if len(c.Fields) > 0 {
+			var obj map[string]interface{}
+			if s, ok := v.(string); ok {
+				if err := json.Unmarshal([]byte(s), &obj); err != nil {
+					return nil, err
+				}
+			}
+			for k, v := range obj {
+				entry.Data[k] = v
+			}
+			continue
+		}
+		if c.Field != "" {
+			entry.Data[c.Field] = v
+		}
+	}
+	return entry, nil
+}
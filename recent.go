@@ -0,0 +1,65 @@
+package pglogrus
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recentEntries is a ring of the last committed entries.
+type recentEntries struct {
+	mu      sync.Mutex
+	entries []*logrus.Entry
+	next    int
+	full    bool
+}
+
+func (r *recentEntries) add(entries []*logrus.Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entry := range entries {
+		r.entries[r.next] = entry
+		r.next = (r.next + 1) % len(r.entries)
+		if r.next == 0 {
+			r.full = true
+		}
+	}
+}
+
+// KeepRecent makes the hook keep the last n committed entries in memory (see
+// Recent).
+// It must be called before the hook is used.
+func (hook *Hook) KeepRecent(n int) {
+	hook.recent = &recentEntries{entries: make([]*logrus.Entry, n)}
+}
+
+// Recent returns the last committed entries kept in memory (see KeepRecent)
+// matching filter, from the oldest to the newest.
+// filter can be nil to return all entries, and must not modify them.
+func (hook *Hook) Recent(filter func(*logrus.Entry) bool) []*logrus.Entry {
+	r := hook.recent
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ordered := r.entries[:r.next]
+	if r.full {
+		ordered = append(append([]*logrus.Entry{}, r.entries[r.next:]...), ordered...)
+	}
+	var entries []*logrus.Entry
+	for _, entry := range ordered {
+		if filter == nil || filter(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// addRecent records entries as committed, if the hook keeps recent entries.
+func (hook *Hook) addRecent(entries ...*logrus.Entry) {
+	if hook.recent != nil && len(hook.recent.entries) > 0 {
+		hook.recent.add(entries)
+	}
+}
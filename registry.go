@@ -0,0 +1,57 @@
+package pglogrus
+
+import (
+	"reflect"
+	"sync"
+)
+
+// A TypeEncoder encodes the field values of a type (see RegisterFieldEncoder).
+// It returns a driver.Value (int64, float64, bool, []byte, string or
+// time.Time), stored as is in columns and encoded as JSON in the payload, or
+// a json.RawMessage, embedded as is in the payload and stored as text in
+// columns.
+type TypeEncoder func(v interface{}) (interface{}, error)
+
+// registry holds the encoders registered by RegisterFieldEncoder
+var registry = struct {
+	sync.RWMutex
+	encoders map[reflect.Type]TypeEncoder
+}{encoders: map[reflect.Type]TypeEncoder{}}
+
+// RegisterFieldEncoder registers the encoder of the field values of type t,
+// used by all the hooks (including RemoteHook), so domain types (money,
+// decimals, custom IDs...) are stored consistently by the services sharing
+// the registration, for example in the init func of the package defining the
+// types:
+//
+//	pglogrus.RegisterFieldEncoder(reflect.TypeOf(Money{}), func(v interface{}) (interface{}, error) {
+//		m := v.(Money)
+//		return json.RawMessage(fmt.Sprintf(`{"amount":%q,"currency":%q}`, m.Amount, m.Currency)), nil
+//	})
+//
+// Encoders apply to field values, not to the values nested in them.
+// A nil encoder removes the encoder of t. When an encoder fails, the error
+// is reported to the ErrorHandler, and the value is stored as JSON.
+func RegisterFieldEncoder(t reflect.Type, encoder TypeEncoder) {
+	registry.Lock()
+	defer registry.Unlock()
+	if encoder == nil {
+		delete(registry.encoders, t)
+		return
+	}
+	registry.encoders[t] = encoder
+}
+
+// encodeField encodes v with the encoder registered for its type, if any.
+func encodeField(v interface{}) (interface{}, error) {
+	if v == nil {
+		return v, nil
+	}
+	registry.RLock()
+	encoder, ok := registry.encoders[reflect.TypeOf(v)]
+	registry.RUnlock()
+	if !ok {
+		return v, nil
+	}
+	return encoder(v)
+}
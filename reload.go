@@ -0,0 +1,132 @@
+package pglogrus
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ReloadConfig applies the settings of config which can be changed while the
+// hook is running: Level, Sampling and FlushInterval. The other settings
+// can't be changed without recreating the hook: ReloadConfig returns an error
+// if they differ from those of current, after applying the safe changes.
+func (hook *AsyncHook) ReloadConfig(current, config Config) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if config.Level == "" {
+		hook.resetLevel()
+	} else {
+		level, _ := logrus.ParseLevel(config.Level)
+		hook.setLevel(level)
+	}
+	rates, _ := config.sampling()
+	hook.setSampling(rates)
+	if config.FlushInterval != current.FlushInterval {
+		interval := time.Duration(config.FlushInterval)
+		if interval == 0 {
+			interval = time.Second
+		}
+		hook.FlushEvery(interval)
+	}
+
+	var unsafe []string
+	if config.DSN != current.DSN {
+		unsafe = append(unsafe, "dsn")
+	}
+	if config.Table != current.Table {
+		unsafe = append(unsafe, "table")
+	}
+	if config.MaxBatchBytes != current.MaxBatchBytes {
+		unsafe = append(unsafe, "max_batch_bytes")
+	}
+	if !reflect.DeepEqual(config.NonBlocking, current.NonBlocking) {
+		unsafe = append(unsafe, "non_blocking")
+	}
+	if config.Retention != current.Retention {
+		unsafe = append(unsafe, "retention")
+	}
+	if len(unsafe) > 0 {
+		return fmt.Errorf("pglogrus: can't change %s without restarting", strings.Join(unsafe, ", "))
+	}
+	return nil
+}
+
+// WatchConfig reloads the JSON config file at path (see ConfigFromJSON and
+// ReloadConfig) when the process receives SIGHUP, and when the file is
+// modified, checked every interval (0 disables the check), until ctx is
+// done.
+// Invalid configs and changes are reported to the ErrorHandler with the
+// "config" Op, once per modification of the file; the last valid config stays
+// in effect.
+func (hook *AsyncHook) WatchConfig(ctx context.Context, path string, interval time.Duration) error {
+	current, modified, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+		case <-tick:
+			// A missing file has a zero modification time
+			var mtime time.Time
+			if info, err := os.Stat(path); err == nil {
+				mtime = info.ModTime()
+			}
+			if mtime.Equal(modified) {
+				continue
+			}
+		}
+		config, mtime, err := readConfigFile(path)
+		// Invalid files are only reported again once modified
+		modified = mtime
+		if err == nil {
+			if reflect.DeepEqual(config, current) {
+				continue
+			}
+			err = hook.ReloadConfig(current, config)
+			// The safe changes were applied
+			current.Level, current.Sampling, current.FlushInterval = config.Level, config.Sampling, config.FlushInterval
+		}
+		if err != nil {
+			hook.handleError(&ErrorEvent{Time: time.Now(), Op: "config", Err: err})
+		}
+	}
+}
+
+// readConfigFile reads the JSON config file at path, and returns its
+// modification time, even if the config is invalid (zero if the file can't be
+// read).
+func readConfigFile(path string) (Config, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, time.Time{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Config{}, time.Time{}, err
+	}
+	config, err := ConfigFromJSON(f)
+	return config, info.ModTime(), err
+}
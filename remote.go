@@ -0,0 +1,242 @@
+package pglogrus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RemoteOptions configure a RemoteHook.
+type RemoteOptions struct {
+	// Client is http.DefaultClient by default.
+	Client *http.Client
+	// Header is added to the requests, for example for authentication.
+	Header http.Header
+	// BatchSize is the maximum number of entries of a request, 500 by
+	// default.
+	BatchSize int
+	// FlushInterval is the maximum time entries wait for their batch to be
+	// complete, a second by default.
+	FlushInterval time.Duration
+	// QueueSize is the number of entries which can be queued, BufSize by
+	// default.
+	QueueSize int
+	// NonBlocking makes Fire drop the entries and return ErrQueueFull when
+	// the queue is full, instead of waiting for the queue to have room.
+	NonBlocking bool
+	// MaxRetries is the number of retries of a batch when the server is
+	// unavailable, 5 by default. Retries are delayed by Backoff (100ms by
+	// default), doubled at each retry.
+	MaxRetries int
+	Backoff    time.Duration
+	// Timeout is the maximum duration of a request, 10 seconds by default.
+	Timeout time.Duration
+	// ErrorHandler receives the errors of the hook, with the "remote" Op.
+	// They are printed to stderr by default.
+	ErrorHandler func(*ErrorEvent)
+}
+
+// A RemoteHook ships entries to an ingestion server (see the server
+// package), which writes them to PostgreSQL, so services can log to
+// PostgreSQL without holding database credentials.
+// Entries are sent in gzipped batches, asynchronously: call Flush before
+// exiting the program.
+type RemoteHook struct {
+	url   string
+	opts  RemoteOptions
+	buf   chan *logrus.Entry
+	flush chan bool
+	// stopped is closed when the worker exits, once the hook is flushed
+	stopped chan struct{}
+}
+
+// NewRemoteHook creates a hook shipping entries to the ingestion server at
+// url (eg. "http://pglogrus.logging.svc/entries"), and starts its worker.
+func NewRemoteHook(url string, opts RemoteOptions) *RemoteHook {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = int(BufSize)
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 100 * time.Millisecond
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	hook := &RemoteHook{
+		url:     url,
+		opts:    opts,
+		buf:     make(chan *logrus.Entry, opts.QueueSize),
+		flush:   make(chan bool),
+		stopped: make(chan struct{}),
+	}
+	go hook.fire()
+	return hook
+}
+
+// Levels returns all the levels.
+func (hook *RemoteHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire queues a copy of entry. Entries fired once the hook is flushed are
+// dropped.
+func (hook *RemoteHook) Fire(entry *logrus.Entry) error {
+	select {
+	case <-hook.stopped:
+		return nil
+	default:
+	}
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		encoded, err := encodeField(v)
+		if err != nil {
+			hook.handleError(fmt.Errorf("pglogrus: can't encode field %q: %v", k, err))
+			encoded = v
+		}
+		data[k] = marshalableErrors(encoded, 3)
+	}
+	newEntry := &logrus.Entry{Data: data, Time: entry.Time, Level: entry.Level, Message: entry.Message}
+	if hook.opts.NonBlocking {
+		select {
+		case hook.buf <- newEntry:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+	select {
+	case hook.buf <- newEntry:
+	case <-hook.stopped:
+		// Flushed meanwhile
+	}
+	return nil
+}
+
+// Flush ships the queued entries, and then exits the worker of the hook.
+// It has no effect once the hook is flushed.
+func (hook *RemoteHook) Flush() {
+	select {
+	case hook.flush <- true:
+		<-hook.flush
+	case <-hook.stopped:
+	}
+}
+
+// fire ships the queued entries in batches.
+func (hook *RemoteHook) fire() {
+	ticker := time.NewTicker(hook.opts.FlushInterval)
+	defer ticker.Stop()
+	var batch []*logrus.Entry
+	for {
+		select {
+		case entry := <-hook.buf:
+			batch = append(batch, entry)
+			if len(batch) < hook.opts.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-hook.flush:
+			for len(hook.buf) > 0 {
+				batch = append(batch, <-hook.buf)
+				if len(batch) == hook.opts.BatchSize {
+					hook.send(batch)
+					batch = nil
+				}
+			}
+			hook.send(batch)
+			// Entries fired from now on are dropped
+			close(hook.stopped)
+			hook.flush <- true
+			return
+		}
+		hook.send(batch)
+		batch = nil
+	}
+}
+
+// send ships batch, retrying while the server is unavailable.
+func (hook *RemoteHook) send(batch []*logrus.Entry) {
+	for retry := 0; len(batch) > 0; retry++ {
+		accepted, retryable, err := hook.post(batch)
+		batch = batch[accepted:]
+		if err == nil {
+			return
+		}
+		if !retryable || retry >= hook.opts.MaxRetries {
+			hook.handleError(fmt.Errorf("%v, %d entries dropped", err, len(batch)))
+			return
+		}
+		time.Sleep(hook.opts.Backoff << uint(retry))
+	}
+}
+
+// post sends batch to the server, and returns the number of entries accepted,
+// and whether the request can be retried if it failed.
+func (hook *RemoteHook) post(batch []*logrus.Entry) (int, bool, error) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := WriteNDJSON(gz, batch...); err != nil {
+		return 0, false, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hook.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.url, &body)
+	if err != nil {
+		return 0, false, err
+	}
+	for k, v := range hook.opts.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := hook.opts.Client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return len(batch), false, nil
+	}
+	var result struct {
+		Accepted int    `json:"accepted"`
+		Error    string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if result.Accepted > len(batch) || result.Accepted < 0 {
+		result.Accepted = 0
+	}
+	err = fmt.Errorf("pglogrus: ingestion server returned %s: %s", resp.Status, result.Error)
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return result.Accepted, retryable, err
+}
+
+func (hook *RemoteHook) handleError(err error) {
+	event := &ErrorEvent{Time: time.Now(), Op: "remote", Err: err}
+	if hook.opts.ErrorHandler != nil {
+		hook.opts.ErrorHandler(event)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "[pglogrus]", event)
+}
@@ -0,0 +1,112 @@
+package pglogrus
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ReplayOptions configure Hook.Replay.
+type ReplayOptions struct {
+	// BatchSize is the number of entries inserted per transaction, 500 by
+	// default.
+	BatchSize int
+	// RatePerSec is the maximum number of entries inserted per second, to
+	// avoid overwhelming the database. 0 means no limit.
+	RatePerSec float64
+	// OnProgress is called after each transaction, with the number of
+	// entries replayed so far.
+	OnProgress func(replayed int)
+}
+
+// Replay inserts the entries of an NDJSON file (see WriteNDJSON), like the
+// files written by Archive and Export, in the hook table.
+// Entries are inserted in batches, as they are: they're not filtered by the
+// hook. Gzipped archives must be decompressed, with gzip.NewReader.
+// It returns the number of replayed entries.
+func (hook *Hook) Replay(ctx context.Context, r io.Reader, opts ReplayOptions) (int, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	start := time.Now()
+	replayed := 0
+	line := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+
+	var batch []*logrus.Entry
+	flush := func() error {
+		if err := hook.replayBatch(ctx, batch); err != nil {
+			return err
+		}
+		replayed += len(batch)
+		batch = batch[:0]
+		if opts.OnProgress != nil {
+			opts.OnProgress(replayed)
+		}
+		if d := replayDelay(replayed, opts.RatePerSec, time.Since(start)); d > 0 {
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		return nil
+	}
+
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry, err := UnmarshalNDJSON(scanner.Bytes())
+		if err != nil {
+			return replayed, fmt.Errorf("pglogrus: line %d: %v", line, err)
+		}
+		batch = append(batch, entry)
+		if len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return replayed, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return replayed, err
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return replayed, err
+		}
+	}
+	return replayed, nil
+}
+
+// replayBatch inserts batch in a transaction.
+func (hook *Hook) replayBatch(ctx context.Context, batch []*logrus.Entry) error {
+	txn, err := hook.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	for _, entry := range batch {
+		if err := hook.insert(txn, entry); err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// replayDelay returns how long to wait after replayed entries were inserted
+// in elapsed, to respect rate entries per second.
+func replayDelay(replayed int, rate float64, elapsed time.Duration) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(replayed)/rate*float64(time.Second)) - elapsed
+}
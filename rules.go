@@ -0,0 +1,85 @@
+package pglogrus
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// A Condition matches entries, to build ignore rules.
+type Condition func(*logrus.Entry) bool
+
+// Ignore returns a predicate ignoring the entries matching all conditions.
+// Use it with AddPredicate:
+//
+//	hook.AddPredicate(pglogrus.Ignore(pglogrus.Level(logrus.DebugLevel), pglogrus.FieldEquals("probe", "liveness")))
+func Ignore(conditions ...Condition) Predicate {
+	return func(entry *logrus.Entry) bool {
+		for _, match := range conditions {
+			if !match(entry) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Level matches entries logged at one of levels.
+func Level(levels ...logrus.Level) Condition {
+	var match [logrus.TraceLevel + 1]bool
+	for _, l := range levels {
+		if l <= logrus.TraceLevel {
+			match[l] = true
+		}
+	}
+	return func(entry *logrus.Entry) bool {
+		return entry.Level <= logrus.TraceLevel && match[entry.Level]
+	}
+}
+
+// FieldEquals matches entries with a field key equal to value.
+// value must be comparable.
+func FieldEquals(key string, value interface{}) Condition {
+	return func(entry *logrus.Entry) bool {
+		v, ok := entry.Data[key]
+		return ok && v == value
+	}
+}
+
+// FieldExists matches entries with a field key.
+func FieldExists(key string) Condition {
+	return func(entry *logrus.Entry) bool {
+		_, ok := entry.Data[key]
+		return ok
+	}
+}
+
+// OriginalLevelField is the field holding the level of entries demoted by
+// Hook.Demote, as logged.
+const OriginalLevelField = "original_level"
+
+// Demote stores the entries matching all conditions at level, when it's less
+// severe than their own, with their original level in the OriginalLevelField.
+// It de-noises entries without dropping them, eg. to store the errors of a
+// noisy library as warnings:
+//
+//	hook.Demote(logrus.WarnLevel, pglogrus.Level(logrus.ErrorLevel), pglogrus.FieldEquals("component", "kafka"))
+//
+// Other hooks of the logger still receive the entries as logged.
+func (hook *Hook) Demote(level logrus.Level, conditions ...Condition) {
+	hook.AddFilter(demoteFilter(level, conditions))
+}
+
+func demoteFilter(level logrus.Level, conditions []Condition) filter {
+	return func(entry *logrus.Entry) *logrus.Entry {
+		if entry.Level >= level {
+			return entry
+		}
+		for _, match := range conditions {
+			if !match(entry) {
+				return entry
+			}
+		}
+		entry.Data[OriginalLevelField] = entry.Level.String()
+		entry.Level = level
+		return entry
+	}
+}
@@ -0,0 +1,63 @@
+package pglogrus
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestIgnore(t *testing.T) {
+	keep := Ignore(Level(logrus.DebugLevel, logrus.TraceLevel), FieldEquals("probe", "liveness"))
+
+	tests := map[string]struct {
+		entry    *logrus.Entry
+		expected bool
+	}{
+		"matching":      {&logrus.Entry{Level: logrus.DebugLevel, Data: logrus.Fields{"probe": "liveness"}}, false},
+		"other level":   {&logrus.Entry{Level: logrus.InfoLevel, Data: logrus.Fields{"probe": "liveness"}}, true},
+		"other value":   {&logrus.Entry{Level: logrus.TraceLevel, Data: logrus.Fields{"probe": "readiness"}}, true},
+		"missing field": {&logrus.Entry{Level: logrus.TraceLevel, Data: logrus.Fields{}}, true},
+		"uncomparable":  {&logrus.Entry{Level: logrus.TraceLevel, Data: logrus.Fields{"probe": 1}}, true},
+		"other":         {&logrus.Entry{Level: logrus.ErrorLevel}, true},
+	}
+	for name, test := range tests {
+		if got := keep(test.entry); got != test.expected {
+			t.Errorf("%s: expected %v, got %v\n", name, test.expected, got)
+		}
+	}
+
+	if Ignore()(&logrus.Entry{}) {
+		t.Error("Expected a rule without conditions to ignore all entries")
+	}
+	if !Ignore(FieldExists("probe"))(&logrus.Entry{Data: logrus.Fields{}}) {
+		t.Error("Expected entry without field to be kept")
+	}
+}
+
+func TestDemote(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	hook.Demote(logrus.InfoLevel, FieldEquals("component", "kafka"))
+
+	tests := map[string]struct {
+		entry    *logrus.Entry
+		level    logrus.Level
+		original interface{}
+	}{
+		"matching":       {&logrus.Entry{Level: logrus.ErrorLevel, Data: logrus.Fields{"component": "kafka"}}, logrus.InfoLevel, "error"},
+		"less severe":    {&logrus.Entry{Level: logrus.DebugLevel, Data: logrus.Fields{"component": "kafka"}}, logrus.DebugLevel, nil},
+		"not matching":   {&logrus.Entry{Level: logrus.ErrorLevel, Data: logrus.Fields{"component": "api"}}, logrus.ErrorLevel, nil},
+		"already stored": {&logrus.Entry{Level: logrus.InfoLevel, Data: logrus.Fields{"component": "kafka"}}, logrus.InfoLevel, nil},
+	}
+	for name, test := range tests {
+		entry := hook.newEntry(test.entry)
+		if entry.Level != test.level {
+			t.Errorf("%s: Expected level to be %v, got %v\n", name, test.level, entry.Level)
+		}
+		if original := entry.Data[OriginalLevelField]; original != test.original {
+			t.Errorf("%s: Expected original level to be %v, got %v\n", name, test.original, original)
+		}
+	}
+	if level := tests["matching"].entry.Level; level != logrus.ErrorLevel {
+		t.Errorf("Expected logged entry not to be modified, got level %v\n", level)
+	}
+}
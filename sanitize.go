@@ -0,0 +1,88 @@
+package pglogrus
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// KeyOptions configure the sanitization of field keys, see SanitizeKeys.
+type KeyOptions struct {
+	// MaxLength is the maximum length of keys, in bytes. Longer keys are
+	// truncated. 0 means no limit.
+	MaxLength int
+	// Lowercase converts keys to lower case.
+	Lowercase bool
+}
+
+// SanitizeKeys sanitizes the keys of the entry fields before they're stored:
+// NUL bytes (rejected by PostgreSQL in JSON) are removed, invalid UTF-8 is
+// replaced by U+FFFD, and keys are truncated and converted to lower case
+// according to opts.
+// When a sanitized key collides with another key, it's suffixed with
+// underscores (keys are renamed in sorted order).
+func (hook *Hook) SanitizeKeys(opts KeyOptions) {
+	hook.AddFilter(func(entry *logrus.Entry) *logrus.Entry {
+		var renamed []string
+		for k := range entry.Data {
+			if opts.sanitize(k) != k {
+				renamed = append(renamed, k)
+			}
+		}
+		sort.Strings(renamed)
+		for _, k := range renamed {
+			v := entry.Data[k]
+			key := opts.sanitize(k)
+			delete(entry.Data, k)
+			for {
+				if _, ok := entry.Data[key]; !ok {
+					break
+				}
+				key += "_"
+			}
+			entry.Data[key] = v
+		}
+		return entry
+	})
+}
+
+// sanitize returns the sanitized key k.
+func (opts KeyOptions) sanitize(k string) string {
+	k = strings.Replace(k, "\x00", "", -1)
+	k = strings.ToValidUTF8(k, "�")
+	if opts.Lowercase {
+		k = strings.ToLower(k)
+	}
+	if opts.MaxLength > 0 && len(k) > opts.MaxLength {
+		k = k[:opts.MaxLength]
+		// Don't cut a rune
+		for len(k) > 0 && !utf8.ValidString(k) {
+			k = k[:len(k)-1]
+		}
+	}
+	return k
+}
+
+// scrub returns s without NUL bytes and invalid UTF-8, which PostgreSQL
+// rejects, replaced by replacement.
+func scrub(s, replacement string) string {
+	if strings.IndexByte(s, 0) >= 0 {
+		s = strings.Replace(s, "\x00", replacement, -1)
+	}
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, replacement)
+	}
+	return s
+}
+
+// scrubEntry scrubs the message and the string field values of entry.
+func (hook *Hook) scrubEntry(entry *logrus.Entry) {
+	entry.Message = scrub(entry.Message, hook.ScrubReplacement)
+	for k, v := range entry.Data {
+		if s, ok := v.(string); ok {
+			entry.Data[k] = scrub(s, hook.ScrubReplacement)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package pglogrus
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSanitizeKeys(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	hook.SanitizeKeys(KeyOptions{MaxLength: 8, Lowercase: true})
+
+	entry := hook.newEntry(&logrus.Entry{Data: logrus.Fields{
+		"user\x00id":  "1",
+		"UserID":      "2",
+		"bad\xffkey":  "3",
+		"request_id":  "4",
+		"request_idx": "5",
+		"ok":          "6",
+		"éééé€":       "7",
+	}})
+	expected := logrus.Fields{
+		// Keys are renamed in order
+		"userid":    "2",
+		"userid_":   "1",
+		"bad�ke":    "3",
+		"request_":  "4",
+		"request__": "5",
+		"ok":        "6",
+		"éééé":      "7",
+	}
+	if !reflect.DeepEqual(expected, entry.Data) {
+		t.Errorf("Expected data to be %q, got %q\n", expected, entry.Data)
+	}
+}
+
+func TestScrub(t *testing.T) {
+	tests := map[string]struct {
+		replacement string
+		message     string
+		field       string
+	}{
+		"default": {"�", "a�b�c", "d�"},
+		"removal": {"", "abc", "d"},
+	}
+	for name, test := range tests {
+		hook := NewHook(nil, map[string]interface{}{})
+		hook.ScrubReplacement = test.replacement
+		entry := hook.newEntry(&logrus.Entry{Message: "a\x00b\xffc", Data: logrus.Fields{"field": "d\x00", "other": 1}})
+		if entry.Message != test.message {
+			t.Errorf("%s: Expected message to be %q, got %q\n", name, test.message, entry.Message)
+		}
+		if entry.Data["field"] != test.field || entry.Data["other"] != 1 {
+			t.Errorf("%s: Expected field to be %q, got %q\n", name, test.field, entry.Data)
+		}
+	}
+
+	hook := NewHook(nil, map[string]interface{}{})
+	hook.Scrub = false
+	if entry := hook.newEntry(&logrus.Entry{Message: "a\x00", Data: logrus.Fields{}}); entry.Message != "a\x00" {
+		t.Errorf("Expected message not to be scrubbed, got %q\n", entry.Message)
+	}
+}
+
+func TestLimitFields(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	hook.Table.Columns = []Column{{Name: "user_id", Field: "user_id"}}
+	hook.LimitFields(FieldLimits{MaxFields: 2, MaxKeys: 3})
+
+	tests := []struct {
+		data     logrus.Fields
+		expected logrus.Fields
+	}{
+		{
+			data:     logrus.Fields{"a": 1, "b": 2, "c": 3, "user_id": "12"},
+			expected: logrus.Fields{"a": 1, "b": 2, "user_id": "12", OverflowField: logrus.Fields{"c": 3}},
+		},
+		{
+			data:     logrus.Fields{"d": 4, "a": 1},
+			expected: logrus.Fields{"a": 1, "d": 4},
+		},
+		{
+			// The 3 keys were seen
+			data:     logrus.Fields{"e": 5, "b": 2},
+			expected: logrus.Fields{"b": 2, OverflowField: logrus.Fields{"e": 5}},
+		},
+		{
+			// The fields of an existing overflow object are merged
+			data:     logrus.Fields{"e": 5, OverflowField: map[string]interface{}{"f": 6}},
+			expected: logrus.Fields{OverflowField: logrus.Fields{"e": 5, "f": 6}},
+		},
+		{
+			// Other values are kept under their own key
+			data:     logrus.Fields{"e": 5, OverflowField: "raw"},
+			expected: logrus.Fields{OverflowField: logrus.Fields{"e": 5, OverflowField: "raw"}},
+		},
+	}
+	for i, test := range tests {
+		entry := hook.newEntry(&logrus.Entry{Data: test.data})
+		if !reflect.DeepEqual(test.expected, entry.Data) {
+			t.Errorf("Expected data of entry %d to be %v, got %v\n", i, test.expected, entry.Data)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package pglogrus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Schema returns the SQL statements creating the table and its indexes, if
+// they don't exist yet.
+func (t *TableConfig) Schema() []string {
+	if t.Shards > 1 {
+		var stmts []string
+		for _, shard := range t.shardTables() {
+			stmts = append(stmts, shard.Schema()...)
+		}
+		return stmts
+	}
+	columns := t.Dialect.baseColumns()
+	switch {
+	case t.Wide:
+		columns = append(columns[:3], columns[4:]...)
+		if t.UnknownColumn != "" {
+			columns = append(columns, t.UnknownColumn+" text")
+		}
+	case t.Encoding != nil:
+		columns[3] = "message_data " + t.Dialect.binaryType() + " NOT NULL"
+	}
+	if t.offloadsPayloads() {
+		columns = append(columns, PayloadIDColumn+" bigint")
+	}
+	var indexes []string
+	if t.TimeIndex != "" {
+		indexes = append(indexes, t.indexStatement("created_at", t.TimeIndex))
+	}
+	for _, c := range t.Columns {
+		columns = append(columns, c.definition())
+		if c.Index {
+			indexes = append(indexes, t.indexStatement(c.Name, c.indexMethod()))
+		}
+	}
+
+	if t.Dialect == MySQL {
+		// MySQL doesn't support CREATE INDEX IF NOT EXISTS: indexes are
+		// created with the table
+		columns = append(columns, indexes...)
+		indexes = nil
+	}
+	stmts := []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n    %s\n);", t.Name, strings.Join(columns, ",\n    "))}
+	if len(t.StorageParameters) > 0 && t.Dialect == Postgres {
+		// Altering the table also applies the parameters to existing tables
+		params := make([]string, 0, len(t.StorageParameters))
+		for k, v := range t.StorageParameters {
+			params = append(params, k+" = "+v)
+		}
+		sort.Strings(params)
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s SET (%s);", t.Name, strings.Join(params, ", ")))
+	}
+	if stmt := t.columnStorageStatement(); stmt != "" && t.Dialect == Postgres {
+		stmts = append(stmts, stmt)
+	}
+	return append(stmts, indexes...)
+}
+
+// columnStorageStatement returns the statement setting the ColumnStorage, if
+// any. Only new values are stored accordingly.
+func (t *TableConfig) columnStorageStatement() string {
+	names := make([]string, 0, len(t.ColumnStorage))
+	for name := range t.ColumnStorage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var actions []string
+	for _, name := range names {
+		storage := t.ColumnStorage[name]
+		if storage.Storage != "" {
+			actions = append(actions, fmt.Sprintf("ALTER COLUMN %s SET STORAGE %s", name, strings.ToUpper(storage.Storage)))
+		}
+		if storage.Compression != "" {
+			actions = append(actions, fmt.Sprintf("ALTER COLUMN %s SET COMPRESSION %s", name, storage.Compression))
+		}
+	}
+	if len(actions) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("ALTER TABLE %s %s;", t.Name, strings.Join(actions, ", "))
+}
+
+// indexStatement returns the statement creating an index on column, using
+// method if set (PostgreSQL only). For MySQL, it's the index definition in
+// the CREATE TABLE statement.
+func (t *TableConfig) indexStatement(column, method string) string {
+	name := fmt.Sprintf("%s_%s_idx", t.indexPrefix(), column)
+	switch {
+	case t.Dialect == MySQL:
+		return fmt.Sprintf("INDEX %s (%s)", name, column)
+	case method != "" && t.Dialect == Postgres:
+		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING %s (%s);", name, t.Name, method, column)
+	}
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s);", name, t.Name, column)
+}
+
+// indexPrefix returns the table name usable as an index name prefix, without
+// its schema.
+func (t *TableConfig) indexPrefix() string {
+	return t.Name[strings.LastIndex(t.Name, ".")+1:]
+}
+
+// definition returns the SQL definition of the column.
+func (c Column) definition() string {
+	typ := c.Type
+	if typ == "" {
+		typ = "text"
+	}
+	if c.Generated != "" {
+		return fmt.Sprintf("%s %s GENERATED ALWAYS AS (%s) STORED", c.Name, typ, c.Generated)
+	}
+	if c.Default != "" {
+		return fmt.Sprintf("%s %s DEFAULT %s", c.Name, typ, c.Default)
+	}
+	return c.Name + " " + typ
+}
+
+// EnsureSchema creates the hook table and its indexes if they don't exist.
+// The ErrorTable, CheckpointTable, BatchTable, AnnotationTable, OutboxTable
+// and PayloadTable are created too, if set.
+// It returns an error if the ColumnStorage of the table isn't valid.
+func (hook *Hook) EnsureSchema(ctx context.Context) error {
+	if err := hook.Table.validateColumnStorage(); err != nil {
+		return err
+	}
+	stmts := hook.Table.Schema()
+	if hook.Table.Helpers && hook.Table.Dialect == Postgres {
+		stmts = append(stmts, hook.Table.helpers()...)
+	}
+	if hook.ErrorTable != "" {
+		stmts = append(stmts, errorTableSchema(hook.ErrorTable))
+	}
+	if hook.CheckpointTable != "" {
+		stmts = append(stmts, checkpointTableSchema(hook.CheckpointTable)...)
+	}
+	if hook.BatchTable != "" {
+		stmts = append(stmts, batchTableSchema(hook.BatchTable))
+	}
+	if hook.AnnotationTable != "" {
+		stmts = append(stmts, annotationTableSchema(hook.AnnotationTable))
+	}
+	if hook.OutboxTable != "" {
+		stmts = append(stmts, outboxTableSchema(hook.OutboxTable)...)
+	}
+	if hook.Table.offloadsPayloads() {
+		stmts = append(stmts, hook.Table.payloadTableSchema())
+	}
+	for _, stmt := range stmts {
+		if _, err := hook.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errorTableSchema returns the SQL statement creating the table storing hook
+// errors.
+func errorTableSchema(name string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id SERIAL,
+    op text NOT NULL,
+    error text NOT NULL,
+    level smallint,
+    message text,
+    created_at timestamp with time zone NOT NULL
+);`, name)
+}
+
+// helpers returns the statements creating the views and functions of the
+// table (see TableConfig.Helpers).
+func (t *TableConfig) helpers() []string {
+	from := t.from([]string{"*"}, false)
+	service := t.ServiceField
+	if service == "" {
+		service = "service"
+	}
+	search := "message ILIKE '%' || query || '%'"
+	if !t.Wide {
+		search += " OR message_data::text ILIKE '%' || query || '%'"
+	}
+	return []string{
+		fmt.Sprintf("CREATE OR REPLACE VIEW %s_errors_last_24h AS SELECT * FROM %s WHERE level <= %d AND created_at > now() - interval '24 hours';", t.Name, from, logrus.ErrorLevel),
+		fmt.Sprintf("CREATE OR REPLACE VIEW %s_by_service AS SELECT %s AS service, level, count(*) AS entries, max(created_at) AS last_entry_at FROM %s GROUP BY 1, 2;", t.Name, t.fieldExpression(service), from),
+		// Shards have the same row type
+		fmt.Sprintf("CREATE OR REPLACE FUNCTION %s_search(query text) RETURNS SETOF %s AS $$ SELECT * FROM %s WHERE %s ORDER BY created_at DESC $$ LANGUAGE sql STABLE;", t.Name, t.tableNames()[0], from, search),
+	}
+}
+
+// fieldExpression returns the SQL expression of field: its column, or its
+// text value in message_data. Dots separate the keys of nested objects (eg.
+// "user.id"), if there is no such field.
+func (t *TableConfig) fieldExpression(field string) string {
+	for _, c := range t.Columns {
+		if c.Field == field {
+			return c.Name
+		}
+		for _, f := range c.Fields {
+			if f == field && c.Type == "jsonb" {
+				return c.Name + "->>" + quoteLiteral(field)
+			}
+		}
+	}
+	if t.Wide {
+		return "NULL::text"
+	}
+	if strings.Contains(field, ".") {
+		return fmt.Sprintf("COALESCE(message_data->>%s, message_data#>>%s)", quoteLiteral(field), quoteLiteral("{"+strings.Replace(field, ".", ",", -1)+"}"))
+	}
+	return "message_data->>" + quoteLiteral(field)
+}
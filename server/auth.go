@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	pglogrus "github.com/gemnasium/logrus-postgresql-hook"
+)
+
+// ClientField is the field holding the identity of the client which sent an
+// entry, when the server authenticates its clients (see Server.Authenticate).
+// Values sent by clients are removed.
+const ClientField = "pglogrus.client"
+
+// ClientColumn returns an indexed column storing the identity of the client
+// which sent each entry.
+func ClientColumn(name string) pglogrus.Column {
+	return pglogrus.Column{Name: name, Field: ClientField, Type: "text", Index: true}
+}
+
+// An Authenticator returns the identity of the client of a request, or false
+// if the client isn't allowed.
+type Authenticator func(r *http.Request) (client string, ok bool)
+
+// BearerTokens authenticates the requests with a bearer token
+// ("Authorization: Bearer <token>"), mapping the tokens to the names of the
+// clients.
+func BearerTokens(tokens map[string]string) Authenticator {
+	return func(r *http.Request) (string, bool) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return "", false
+		}
+		token := []byte(strings.TrimPrefix(auth, "Bearer "))
+		for t, client := range tokens {
+			if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+				return client, true
+			}
+		}
+		return "", false
+	}
+}
+
+// ClientCertificates authenticates the requests with the client certificates
+// verified by the TLS server (see MutualTLSConfig). The identity of a client
+// is the common name of its certificate.
+func ClientCertificates() Authenticator {
+	return func(r *http.Request) (string, bool) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return "", false
+		}
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		return cn, cn != ""
+	}
+}
+
+// MutualTLSConfig returns the TLS config of a server requiring client
+// certificates signed by the certificate authorities of clientCA, with the
+// certificate of the server and its key (PEM files).
+//
+//	config, err := server.MutualTLSConfig("server.pem", "server.key", "clients-ca.pem")
+//	s := &http.Server{Addr: ":8443", Handler: ingest, TLSConfig: config}
+//	err = s.ListenAndServeTLS("", "")
+func MutualTLSConfig(cert, key, clientCA string) (*tls.Config, error) {
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("server: invalid certificate: %v", err)
+	}
+	pem, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return nil, fmt.Errorf("server: can't read client CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("server: no certificate found in client CA %s", clientCA)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// limiter limits the entries of each client with token buckets, allowing
+// bursts of one second of entries.
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes up to n tokens from the bucket of client, filled at rate tokens
+// per second, and returns the number of tokens taken.
+func (l *limiter) allow(client string, n int, rate float64, now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{}
+	}
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if float64(n) > b.tokens {
+		n = int(b.tokens)
+	}
+	b.tokens -= float64(n)
+	return n
+}
@@ -0,0 +1,173 @@
+// Package server provides an HTTP server ingesting log entries, and writing
+// them with a pglogrus hook, so processes without access to the database can
+// ship their logs to a central writer owning the connections.
+//
+// Batches of entries are posted as NDJSON (see pglogrus.MarshalNDJSON),
+// optionally gzipped:
+//
+//	http.Handle("/entries", server.New(hook))
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	pglogrus "github.com/gemnasium/logrus-postgresql-hook"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultMaxBodyBytes is the default maximum size of a batch, decompressed.
+const DefaultMaxBodyBytes = 10 << 20
+
+// A Server ingests batches of entries posted as NDJSON, and fires them to its
+// hook: they're filtered and written like the entries logged by the process
+// of the server. The fields of the entries prefixed with "pglogrus." (control
+// fields, ClientField...) are reserved to the server, and removed.
+type Server struct {
+	// Hook writes the entries, usually a *pglogrus.AsyncHook.
+	Hook logrus.Hook
+	// MaxBodyBytes is the maximum size of a batch, decompressed.
+	MaxBodyBytes int64
+	// Authenticate authenticates the clients, if set (see BearerTokens and
+	// ClientCertificates). Requests of unknown clients are rejected with
+	// 401 Unauthorized, and the identity of the client is stored in the
+	// ClientField of the entries.
+	Authenticate Authenticator
+	// RateLimit is the maximum number of entries per second of each client
+	// (or of all the clients, without Authenticate), allowing bursts of one
+	// second of entries. The entries exceeding it are rejected with
+	// 429 Too Many Requests. 0 means no limit.
+	RateLimit float64
+
+	limiter limiter
+}
+
+// New returns a server writing entries with hook.
+func New(hook logrus.Hook) *Server {
+	return &Server{Hook: hook, MaxBodyBytes: DefaultMaxBodyBytes}
+}
+
+// Response is the JSON response of the server.
+type Response struct {
+	// Accepted is the number of entries of the batch fired to the hook.
+	// When the hook fails, the client can send the other entries again.
+	Accepted int    `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ServeHTTP ingests the batch of entries of a POST request.
+// The batch is rejected with 401 Unauthorized if the client isn't allowed,
+// 400 Bad Request if an entry is invalid, and 413 Request Entity Too Large if
+// it's too big. It responds with 429 Too Many Requests when the rate limit
+// of the client is exceeded, and with
+// 503 Service Unavailable if the hook fails, for example when the queue of
+// the AsyncHook is full (see AsyncHook.NonBlocking).
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		respond(w, http.StatusMethodNotAllowed, Response{Error: "method not allowed"})
+		return
+	}
+	var client string
+	if s.Authenticate != nil {
+		var ok bool
+		if client, ok = s.Authenticate(r); !ok {
+			respond(w, http.StatusUnauthorized, Response{Error: "unauthorized"})
+			return
+		}
+	}
+	entries, status, err := s.read(r)
+	if err != nil {
+		respond(w, status, Response{Error: err.Error()})
+		return
+	}
+	allowed := len(entries)
+	if s.RateLimit > 0 {
+		allowed = s.limiter.allow(client, len(entries), s.RateLimit, time.Now())
+	}
+	for i, entry := range entries[:allowed] {
+		removeReservedFields(entry)
+		if s.Authenticate != nil {
+			entry.Data[ClientField] = client
+		}
+		if err := s.Hook.Fire(entry); err != nil {
+			respond(w, http.StatusServiceUnavailable, Response{Accepted: i, Error: err.Error()})
+			return
+		}
+	}
+	if allowed < len(entries) {
+		w.Header().Set("Retry-After", "1")
+		respond(w, http.StatusTooManyRequests, Response{Accepted: allowed, Error: "rate limit exceeded"})
+		return
+	}
+	respond(w, http.StatusAccepted, Response{Accepted: len(entries)})
+}
+
+// read parses the entries of the body of r, and returns the status of the
+// response if they're invalid.
+func (s *Server) read(r *http.Request) ([]*logrus.Entry, int, error) {
+	maxBytes := s.MaxBodyBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBodyBytes
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+	// Read one more byte to detect batches which are too big
+	limited := &io.LimitedReader{R: body, N: maxBytes + 1}
+	scanner := bufio.NewScanner(limited)
+	scanner.Buffer(nil, int(maxBytes)+1)
+
+	var entries []*logrus.Entry
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry, err := pglogrus.UnmarshalNDJSON(scanner.Bytes())
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid entry on line %d: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if limited.N <= 0 {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("batch larger than %d bytes", maxBytes)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return entries, 0, nil
+}
+
+// reservedPrefix is the prefix of the fields reserved to the hook and the
+// server, like the control fields (eg. pglogrus.TableControlField) and the
+// ClientField.
+const reservedPrefix = "pglogrus."
+
+// removeReservedFields removes the reserved fields sent by the client, so
+// clients can't choose the table of their entries, force synchronous commits,
+// or impersonate other clients.
+func removeReservedFields(entry *logrus.Entry) {
+	for k := range entry.Data {
+		if strings.HasPrefix(k, reservedPrefix) {
+			delete(entry.Data, k)
+		}
+	}
+}
+
+func respond(w http.ResponseWriter, status int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
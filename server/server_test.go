@@ -0,0 +1,281 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pglogrus "github.com/gemnasium/logrus-postgresql-hook"
+	"github.com/sirupsen/logrus"
+)
+
+// recordingHook records the entries fired, and fails after limit entries
+type recordingHook struct {
+	entries []*logrus.Entry
+	limit   int
+}
+
+func (h *recordingHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *recordingHook) Fire(entry *logrus.Entry) error {
+	if h.limit > 0 && len(h.entries) >= h.limit {
+		return errors.New("queue is full")
+	}
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(s))
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestServer(t *testing.T) {
+	batch := `{"level":"info","message":"first","message_data":{"user":"alice"},"created_at":"2019-03-18T10:00:00Z"}
+{"level":"error","message":"second","message_data":{},"created_at":"2019-03-18T10:00:01Z"}
+`
+	tests := map[string]struct {
+		method   string
+		body     []byte
+		gzip     bool
+		limit    int
+		maxBytes int64
+		status   int
+		expected Response
+	}{
+		"batch":    {body: []byte(batch), status: http.StatusAccepted, expected: Response{Accepted: 2}},
+		"gzip":     {body: gzipped(batch), gzip: true, status: http.StatusAccepted, expected: Response{Accepted: 2}},
+		"invalid":  {body: []byte(batch + "{\"level\":\"loud\"}\n"), status: http.StatusBadRequest, expected: Response{Error: `invalid entry on line 3: not a valid logrus Level: "loud"`}},
+		"too_big":  {body: []byte(batch), maxBytes: 100, status: http.StatusRequestEntityTooLarge, expected: Response{Error: "batch larger than 100 bytes"}},
+		"full":     {body: []byte(batch), limit: 1, status: http.StatusServiceUnavailable, expected: Response{Accepted: 1, Error: "queue is full"}},
+		"get":      {method: http.MethodGet, status: http.StatusMethodNotAllowed, expected: Response{Error: "method not allowed"}},
+		"no_entry": {body: []byte("\n"), status: http.StatusAccepted, expected: Response{}},
+	}
+	for name, test := range tests {
+		hook := &recordingHook{limit: test.limit}
+		server := New(hook)
+		if test.maxBytes > 0 {
+			server.MaxBodyBytes = test.maxBytes
+		}
+		method := test.method
+		if method == "" {
+			method = http.MethodPost
+		}
+		req := httptest.NewRequest(method, "/entries", bytes.NewReader(test.body))
+		if test.gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s: Expected status to be %d, got %d\n", name, test.status, w.Code)
+		}
+		var resp Response
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp != test.expected {
+			t.Errorf("%s: Expected response to be %+v, got %+v\n", name, test.expected, resp)
+		}
+		if test.status == http.StatusAccepted && len(hook.entries) != resp.Accepted {
+			t.Errorf("%s: Expected %d entries to be fired, got %d\n", name, resp.Accepted, len(hook.entries))
+		}
+	}
+
+	hook := &recordingHook{}
+	New(hook).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/entries", strings.NewReader(batch)))
+	if len(hook.entries) != 2 || hook.entries[0].Message != "first" || hook.entries[0].Data["user"] != "alice" || hook.entries[1].Level != logrus.ErrorLevel {
+		t.Errorf("Expected entries to be decoded, got %v\n", hook.entries)
+	}
+}
+
+func TestRemoteHook(t *testing.T) {
+	// The first batch is partially accepted, as the queue fills up
+	hook := &recordingHook{limit: 1}
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		New(hook).ServeHTTP(w, r)
+		hook.limit = 0
+	}))
+	defer ts.Close()
+
+	remote := pglogrus.NewRemoteHook(ts.URL, pglogrus.RemoteOptions{Backoff: time.Millisecond})
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(remote)
+	log.WithField("user", "alice").Info("first")
+	log.WithError(errors.New("oops")).Error("second")
+	remote.Flush()
+
+	if requests != 2 {
+		t.Errorf("Expected the batch to be retried once, got %d requests\n", requests)
+	}
+	if len(hook.entries) != 2 || hook.entries[0].Message != "first" || hook.entries[1].Data["error"] != "oops" {
+		t.Errorf("Expected entries to be shipped once, got %v\n", hook.entries)
+	}
+}
+
+func TestRemoteHookFlushed(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		New(&recordingHook{}).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	remote := pglogrus.NewRemoteHook(ts.URL, pglogrus.RemoteOptions{QueueSize: 1})
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(remote)
+	remote.Flush()
+
+	done := make(chan struct{})
+	go func() {
+		// Fire doesn't block on the full queue, and Flush on the exited worker
+		log.Info("first")
+		log.Info("second")
+		remote.Flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Fire and Flush to return once the hook is flushed\n")
+	}
+	if requests != 0 {
+		t.Errorf("Expected entries fired after Flush to be dropped, got %d requests\n", requests)
+	}
+}
+
+func TestRemoteHookTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	var errs []error
+	remote := pglogrus.NewRemoteHook(ts.URL, pglogrus.RemoteOptions{
+		Timeout:      10 * time.Millisecond,
+		MaxRetries:   -1,
+		ErrorHandler: func(event *pglogrus.ErrorEvent) { errs = append(errs, event.Err) },
+	})
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(remote)
+	log.Info("stuck")
+
+	done := make(chan struct{})
+	go func() {
+		remote.Flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the request to time out\n")
+	}
+	if len(errs) != 1 {
+		t.Errorf("Expected the timeout to be reported, got %v\n", errs)
+	}
+}
+
+func TestReservedFields(t *testing.T) {
+	batch := `{"level":"info","message":"table","message_data":{"pglogrus.table":"other_tenant.logs","user":"1"}}
+{"level":"info","message":"sync","message_data":{"pglogrus.sync":true}}
+{"level":"info","message":"client","message_data":{"pglogrus.client":"admin"}}
+`
+	for name, authenticate := range map[string]Authenticator{
+		"anonymous":     nil,
+		"authenticated": func(*http.Request) (string, bool) { return "billing", true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			hook := &recordingHook{}
+			server := New(hook)
+			server.Authenticate = authenticate
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/entries", strings.NewReader(batch)))
+			if w.Code != http.StatusAccepted || len(hook.entries) != 3 {
+				t.Fatalf("Expected the batch to be accepted, got %d %s\n", w.Code, w.Body)
+			}
+			for _, entry := range hook.entries {
+				for _, field := range []string{pglogrus.TableControlField, pglogrus.SyncControlField} {
+					if _, ok := entry.Data[field]; ok {
+						t.Errorf("Expected %s to be removed from entry %q, got %v\n", field, entry.Message, entry.Data)
+					}
+				}
+				client, ok := entry.Data[ClientField]
+				if authenticate == nil && ok {
+					t.Errorf("Expected %s to be removed from entry %q, got %v\n", ClientField, entry.Message, entry.Data)
+				}
+				if authenticate != nil && client != "billing" {
+					t.Errorf("Expected client of entry %q to be billing, got %v\n", entry.Message, client)
+				}
+			}
+			if hook.entries[0].Data["user"] != "1" {
+				t.Errorf("Expected other fields to be kept, got %v\n", hook.entries[0].Data)
+			}
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	hook := &recordingHook{}
+	server := New(hook)
+	server.Authenticate = BearerTokens(map[string]string{"s3cr3t": "billing"})
+	server.RateLimit = 2
+
+	batch := `{"level":"info","message":"1","message_data":{"pglogrus.client":"admin"}}
+{"level":"info","message":"2"}
+{"level":"info","message":"3"}
+`
+	tests := []struct {
+		token    string
+		status   int
+		expected Response
+	}{
+		{token: "wrong", status: http.StatusUnauthorized, expected: Response{Error: "unauthorized"}},
+		{token: "s3cr3t", status: http.StatusTooManyRequests, expected: Response{Accepted: 2, Error: "rate limit exceeded"}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/entries", strings.NewReader(batch))
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		var resp Response
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != test.status || resp != test.expected {
+			t.Errorf("Expected response to be %d %+v, got %d %+v\n", test.status, test.expected, w.Code, resp)
+		}
+	}
+	if len(hook.entries) != 2 || hook.entries[0].Data[ClientField] != "billing" {
+		t.Errorf("Expected the entries to have the client identity, got %v\n", hook.entries)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/entries", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "billing"}}}}}
+	if client, ok := ClientCertificates()(req); !ok || client != "billing" {
+		t.Errorf("Expected client to be %q, got %q\n", "billing", client)
+	}
+	if _, ok := ClientCertificates()(httptest.NewRequest(http.MethodPost, "/entries", nil)); ok {
+		t.Error("Expected requests without certificates to be rejected")
+	}
+}
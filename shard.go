@@ -0,0 +1,74 @@
+package pglogrus
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tableNames returns the names of the tables storing entries: the table
+// itself, or its shards (see TableConfig.Shards).
+func (t *TableConfig) tableNames() []string {
+	if t.Shards <= 1 {
+		return []string{t.Name}
+	}
+	names := make([]string, t.Shards)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s_%d", t.Name, i)
+	}
+	return names
+}
+
+// shard returns the index of the shard storing entry.
+func (t *TableConfig) shard(entry *logrus.Entry) int {
+	if t.Shards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	if v, ok := entry.Data[t.ShardField]; ok {
+		fmt.Fprint(h, v)
+	}
+	return int(h.Sum32() % uint32(t.Shards))
+}
+
+// tableName returns the name of the table storing entry.
+func (t *TableConfig) tableName(entry *logrus.Entry) string {
+	if table := entryControls(entry).table; table != "" {
+		return table
+	}
+	if t.Shards <= 1 {
+		return t.Name
+	}
+	return fmt.Sprintf("%s_%d", t.Name, t.shard(entry))
+}
+
+// shardTables returns the config of each shard of the table, or the table
+// itself if it isn't sharded.
+func (t *TableConfig) shardTables() []*TableConfig {
+	if t.Shards <= 1 {
+		return []*TableConfig{t}
+	}
+	var tables []*TableConfig
+	for _, name := range t.tableNames() {
+		shard := *t
+		shard.Name = name
+		shard.Shards = 0
+		tables = append(tables, &shard)
+	}
+	return tables
+}
+
+// groupByShard returns batch sorted by shard, so the entries of a shard are
+// inserted together.
+func (t *TableConfig) groupByShard(batch []*logrus.Entry) []*logrus.Entry {
+	if t.Shards <= 1 {
+		return batch
+	}
+	grouped := append([]*logrus.Entry(nil), batch...)
+	sort.SliceStable(grouped, func(i, j int) bool {
+		return t.shard(grouped[i]) < t.shard(grouped[j])
+	})
+	return grouped
+}
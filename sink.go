@@ -0,0 +1,124 @@
+package pglogrus
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sinkBufSize is the number of writes queued for each sink, after which
+// entries are dropped
+const sinkBufSize = 64
+
+// A SecondarySink receives the entries written by the hook, after they're
+// committed to the DB.
+// Sinks are best-effort: each sink is called from its own goroutine, and
+// entries are dropped if it can't keep up. Errors are reported to the hook
+// ErrorHandler, with the "sink" Op.
+type SecondarySink interface {
+	Write(entries []*logrus.Entry) error
+}
+
+// WriterSink returns a sink writing entries to w with formatter, such as a
+// logrus.JSONFormatter writing to stdout or a file.
+func WriterSink(w io.Writer, formatter logrus.Formatter) SecondarySink {
+	return &writerSink{w: w, formatter: formatter}
+}
+
+type writerSink struct {
+	w         io.Writer
+	formatter logrus.Formatter
+}
+
+func (s *writerSink) Write(entries []*logrus.Entry) error {
+	for _, entry := range entries {
+		line, err := s.formatter.Format(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := s.w.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sinkQueue writes entries to a sink in the background.
+// Its writes are tracked by pending, like the entries of an AsyncHook: the
+// hooks sharing the sinks (see Destination and Pipeline) queue entries while
+// others wait for them. mu makes the writes queued and counted atomically.
+type sinkQueue struct {
+	sink    SecondarySink
+	buf     chan []*logrus.Entry
+	mu      sync.Mutex
+	pending completion
+}
+
+// AddSink adds a sink receiving the entries committed by the hook.
+// Flushing an AsyncHook waits for its sinks to receive the queued entries.
+func (hook *Hook) AddSink(sink SecondarySink) {
+	q := hook.newSinkQueue(sink)
+	hook.mu.Lock()
+	hook.sinks = append(hook.sinks, q)
+	hook.mu.Unlock()
+}
+
+// newSinkQueue starts writing the entries queued for sink
+func (hook *Hook) newSinkQueue(sink SecondarySink) *sinkQueue {
+	q := &sinkQueue{sink: sink, buf: make(chan []*logrus.Entry, sinkBufSize)}
+	go func() {
+		for entries := range q.buf {
+			if err := sink.Write(entries); err != nil {
+				hook.handleError(&ErrorEvent{Time: time.Now(), Op: "sink", Err: err})
+				q.pending.ack(1, 0, 1)
+				continue
+			}
+			q.pending.ack(1, 1, 0)
+		}
+	}()
+	return q
+}
+
+// queue queues entries to q, or drops them if q is full
+func (hook *Hook) queue(q *sinkQueue, entries []*logrus.Entry) {
+	q.mu.Lock()
+	q.pending.add()
+	select {
+	case q.buf <- entries:
+		q.mu.Unlock()
+	default:
+		q.pending.cancel()
+		q.mu.Unlock()
+		hook.handleError(&ErrorEvent{Time: time.Now(), Op: "sink", Err: ErrQueueFull})
+	}
+}
+
+// sink queues committed entries to the sinks of the hook
+func (hook *Hook) sink(entries ...*logrus.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	hook.mu.RLock()
+	sinks := hook.sinks
+	hook.mu.RUnlock()
+	for _, q := range sinks {
+		hook.queue(q, entries)
+	}
+}
+
+// waitSinks waits for the sinks (and the dead letter sinks of ValidateFields)
+// to receive the entries queued so far
+func (hook *Hook) waitSinks() {
+	hook.mu.RLock()
+	sinks := append(append([]*sinkQueue(nil), hook.sinks...), hook.deadLetters...)
+	hook.mu.RUnlock()
+	for _, q := range sinks {
+		q.mu.Lock()
+		target, _ := q.pending.snapshot()
+		q.mu.Unlock()
+		q.pending.wait(context.Background(), target)
+	}
+}
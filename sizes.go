@@ -0,0 +1,91 @@
+package pglogrus
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Entry sizes are counted in exponential buckets, from 128 bytes
+// (2^minSizeShift) to 1MB (2^maxSizeShift).
+const (
+	minSizeShift = 7
+	maxSizeShift = 20
+)
+
+// sizeHistogram counts the sizes of entries, atomically.
+type sizeHistogram struct {
+	// counts has a last bucket for sizes above the last bound
+	counts [maxSizeShift - minSizeShift + 2]uint64
+	count  uint64
+	sum    uint64
+}
+
+func (h *sizeHistogram) observe(size int) {
+	// Index of the smallest power of 2 greater than or equal to size
+	i := bits.Len(uint(size-1)) - minSizeShift
+	if size <= 1<<minSizeShift {
+		i = 0
+	}
+	if i > len(h.counts)-1 {
+		i = len(h.counts) - 1
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(size))
+}
+
+// observeSize records the size of the row inserted with args.
+func (hook *Hook) observeSize(args []interface{}) {
+	size := 0
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		case time.Time:
+			size += 8
+		case nil:
+		default:
+			size += 8
+		}
+	}
+	hook.stats.sizes.observe(size)
+}
+
+// A SizeHistogram is the distribution of the sizes of the entries inserted.
+type SizeHistogram struct {
+	Count uint64
+	// Sum is the total size of the entries, in bytes.
+	Sum uint64
+	// Buckets are cumulative, like Prometheus buckets: each counts the
+	// entries whose size is less than or equal to its bound.
+	Buckets []SizeBucket
+}
+
+// SizeBucket of a SizeHistogram.
+type SizeBucket struct {
+	// LE is the bound of the bucket, in bytes.
+	LE    int
+	Count uint64
+}
+
+// EntrySizes returns the distribution of the sizes of the rows inserted by the
+// hook: the size of the message, payload and columns of the entries, as sent
+// to the DB. It helps planning the capacity of the logs table, like the share
+// of entries exceeding the TOAST threshold (2KB), or the size of partitions.
+func (hook *Hook) EntrySizes() SizeHistogram {
+	h := &hook.stats.sizes
+	histogram := SizeHistogram{
+		Count:   atomic.LoadUint64(&h.count),
+		Sum:     atomic.LoadUint64(&h.sum),
+		Buckets: make([]SizeBucket, len(h.counts)-1),
+	}
+	var cumulative uint64
+	for i := range histogram.Buckets {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		histogram.Buckets[i] = SizeBucket{LE: 1 << uint(minSizeShift+i), Count: cumulative}
+	}
+	return histogram
+}
@@ -0,0 +1,52 @@
+package pglogrus
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxStackSize is the size after which captured stacks are truncated
+const maxStackSize = 64 << 10
+
+// AddStackTrace adds the stack of the goroutine logging entries at level or
+// above (eg. logrus.ErrorLevel) to the entry fields, as field.
+// Capturing a stack is expensive: at most one stack is captured per interval,
+// so a flood of errors doesn't slow down the application. An interval of 0
+// captures a stack for every entry.
+// Use a Column of the hook Table to store it in a dedicated column.
+func (hook *Hook) AddStackTrace(level logrus.Level, field string, interval time.Duration) {
+	hook.AddFilter(stackFilter(level, field, interval))
+}
+
+func stackFilter(level logrus.Level, field string, interval time.Duration) filter {
+	var last int64 // time of the last capture, in UnixNano
+	return func(entry *logrus.Entry) *logrus.Entry {
+		if entry.Level > level {
+			return entry
+		}
+		if interval > 0 {
+			now := time.Now().UnixNano()
+			prev := atomic.LoadInt64(&last)
+			if now-prev < int64(interval) || !atomic.CompareAndSwapInt64(&last, prev, now) {
+				return entry
+			}
+		}
+		entry.Data[field] = stack()
+		return entry
+	}
+}
+
+// stack returns the stack of the current goroutine
+func stack() string {
+	buf := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) || len(buf) >= maxStackSize {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,175 @@
+package pglogrus
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stage is a stage of the pipeline of the hook, timed by StageTimings.
+type stage int
+
+const (
+	// stageFilter prepares entries: predicates, filters, encoders...
+	stageFilter stage = iota
+	// stageMarshal builds the insert statements, encoding the payloads
+	stageMarshal
+	// stageEnqueue waits for room in the queue of the AsyncHook
+	stageEnqueue
+	// stageBatchWait waits for a batch to be complete, from its first entry
+	stageBatchWait
+	// stageInsert executes the insert statements
+	stageInsert
+	// stageCommit commits the transactions
+	stageCommit
+	stageCount
+)
+
+var stageNames = [stageCount]string{"filter", "marshal", "enqueue", "batch_wait", "insert", "commit"}
+
+// stageBuckets are the upper bounds of the buckets of the stage histograms.
+var stageBuckets = []time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// histogram counts durations in stageBuckets, atomically.
+type histogram struct {
+	// counts has a last bucket for durations above the last bound
+	counts [8]uint64
+	count  uint64
+	sum    int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(stageBuckets) && d > stageBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// observe records the duration of stage s, started at start.
+func (hook *Hook) observe(s stage, start time.Time) {
+	hook.stats.stages[s].observe(time.Since(start))
+}
+
+// A Histogram is the distribution of the durations of a stage.
+type Histogram struct {
+	Count uint64
+	Sum   time.Duration
+	// Buckets are cumulative, like Prometheus buckets: each counts the
+	// durations less than or equal to its bound.
+	Buckets []Bucket
+}
+
+// Bucket of a Histogram.
+type Bucket struct {
+	LE    time.Duration
+	Count uint64
+}
+
+// StageTimings returns the histograms of the durations of the stages of the
+// hook, so slowness can be attributed to CPU or to the DB:
+//   - "filter": preparing entries (predicates, filters, encoders...),
+//   - "marshal": building the insert statements, and encoding payloads,
+//   - "enqueue": waiting for room in the queue (AsyncHook only),
+//   - "batch_wait": waiting for a batch to be complete, from its first entry
+//     (AsyncHook only),
+//   - "insert": executing the insert statements,
+//   - "commit": committing the transactions (AsyncHook only).
+func (hook *Hook) StageTimings() map[string]Histogram {
+	timings := make(map[string]Histogram, stageCount)
+	for s, name := range stageNames {
+		h := &hook.stats.stages[s]
+		histogram := Histogram{
+			Count:   atomic.LoadUint64(&h.count),
+			Sum:     time.Duration(atomic.LoadInt64(&h.sum)),
+			Buckets: make([]Bucket, len(stageBuckets)),
+		}
+		var cumulative uint64
+		for i, le := range stageBuckets {
+			cumulative += atomic.LoadUint64(&h.counts[i])
+			histogram.Buckets[i] = Bucket{LE: le, Count: cumulative}
+		}
+		timings[name] = histogram
+	}
+	return timings
+}
+
+// PrometheusHandler returns an HTTP handler exposing the hook stats, stage
+// timings and entry sizes in the Prometheus text format, without depending on
+// the Prometheus client:
+//
+//	http.Handle("/metrics/pglogrus", hook.PrometheusHandler())
+func (hook *Hook) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		stats := hook.Stats()
+		for _, c := range []struct {
+			name, help string
+			value      uint64
+		}{
+			{"fired", "Entries received by the hook.", stats.Fired},
+			{"ignored", "Entries ignored by predicates and filters.", stats.Ignored},
+			{"written", "Entries written to the DB.", stats.Written},
+			{"dropped", "Entries which couldn't be written.", stats.Dropped},
+			{"expired", "Entries dropped because they were queued for too long.", stats.Expired},
+		} {
+			fmt.Fprintf(w, "# HELP pglogrus_entries_%s_total %s\n# TYPE pglogrus_entries_%[1]s_total counter\npglogrus_entries_%[1]s_total %[3]d\n", c.name, c.help, c.value)
+		}
+		fmt.Fprintf(w, "# HELP pglogrus_errors_total Errors passed to the ErrorHandler.\n# TYPE pglogrus_errors_total counter\npglogrus_errors_total %d\n", stats.Errors)
+		fmt.Fprintf(w, "# HELP pglogrus_entries_queued Entries waiting to be written.\n# TYPE pglogrus_entries_queued gauge\npglogrus_entries_queued %d\n", stats.Queued)
+		fmt.Fprintf(w, "# HELP pglogrus_lag_seconds Age of the oldest queued entry.\n# TYPE pglogrus_lag_seconds gauge\npglogrus_lag_seconds %g\n", stats.Lag.Seconds())
+		fmt.Fprint(w, "# HELP pglogrus_entries_ignored_by_cause_total Entries ignored, by cause.\n# TYPE pglogrus_entries_ignored_by_cause_total counter\n")
+		for cause, name := range ignoreCauseNames {
+			fmt.Fprintf(w, "pglogrus_entries_ignored_by_cause_total{cause=%q} %d\n", name, atomic.LoadUint64(&hook.stats.ignoredBy[cause]))
+		}
+		ignored := hook.IgnoredByFilter()
+		if len(ignored) > 0 {
+			fmt.Fprint(w, "# HELP pglogrus_entries_ignored_by_filter_total Entries ignored by named filters and predicates.\n# TYPE pglogrus_entries_ignored_by_filter_total counter\n")
+			names := make([]string, 0, len(ignored))
+			for name := range ignored {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(w, "pglogrus_entries_ignored_by_filter_total{filter=%q} %d\n", name, ignored[name])
+			}
+		}
+		fmt.Fprint(w, "# HELP pglogrus_entries_shed_total Entries dropped because the queue was filling up.\n# TYPE pglogrus_entries_shed_total counter\n")
+		for level, n := range stats.Shed {
+			fmt.Fprintf(w, "pglogrus_entries_shed_total{level=%q} %d\n", logrus.Level(level), n)
+		}
+
+		fmt.Fprint(w, "# HELP pglogrus_stage_duration_seconds Duration of the stages of the hook.\n# TYPE pglogrus_stage_duration_seconds histogram\n")
+		timings := hook.StageTimings()
+		for _, name := range stageNames {
+			h := timings[name]
+			for _, b := range h.Buckets {
+				fmt.Fprintf(w, "pglogrus_stage_duration_seconds_bucket{stage=%q,le=\"%g\"} %d\n", name, b.LE.Seconds(), b.Count)
+			}
+			fmt.Fprintf(w, "pglogrus_stage_duration_seconds_bucket{stage=%q,le=\"+Inf\"} %d\n", name, h.Count)
+			fmt.Fprintf(w, "pglogrus_stage_duration_seconds_sum{stage=%q} %g\n", name, h.Sum.Seconds())
+			fmt.Fprintf(w, "pglogrus_stage_duration_seconds_count{stage=%q} %d\n", name, h.Count)
+		}
+
+		sizes := hook.EntrySizes()
+		fmt.Fprint(w, "# HELP pglogrus_entry_size_bytes Size of the inserted entries.\n# TYPE pglogrus_entry_size_bytes histogram\n")
+		for _, b := range sizes.Buckets {
+			fmt.Fprintf(w, "pglogrus_entry_size_bytes_bucket{le=\"%d\"} %d\n", b.LE, b.Count)
+		}
+		fmt.Fprintf(w, "pglogrus_entry_size_bytes_bucket{le=\"+Inf\"} %d\npglogrus_entry_size_bytes_sum %d\npglogrus_entry_size_bytes_count %[1]d\n", sizes.Count, sizes.Sum)
+	})
+}
@@ -0,0 +1,181 @@
+package pglogrus
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Stats are counters of the hook activity, since its creation.
+type Stats struct {
+	// Fired is the number of entries received by the hook.
+	Fired uint64
+	// Ignored is the number of entries ignored by predicates and filters.
+	Ignored uint64
+	// IgnoredBy are the Ignored entries by cause. See IgnoredByFilter for
+	// the counts of named filters.
+	IgnoredBy IgnoredCounts
+	// Queued is the number of entries waiting to be written (AsyncHook only).
+	Queued uint64
+	// Written is the number of entries written to the DB.
+	Written uint64
+	// Dropped is the number of entries which couldn't be written.
+	Dropped uint64
+	// Expired is the number of entries dropped because they were queued for
+	// too long (see AsyncHook.QueueTTL). They're counted in Dropped too.
+	Expired uint64
+	// Errors is the number of errors passed to the ErrorHandler.
+	Errors uint64
+	// Lag is the age of the oldest entry queued or being written, according
+	// to its time (AsyncHook only). It grows when the hook falls behind.
+	Lag time.Duration
+	// Shed is the number of entries dropped because the queue was filling
+	// up (see AsyncHook.ShedLevels), indexed by level. They're counted in
+	// Dropped too.
+	Shed [logrus.TraceLevel + 1]uint64
+	// Degraded is true while the DB is unavailable for longer than the
+	// Outage policy of the AsyncHook allows.
+	Degraded bool
+}
+
+// counters are updated atomically by the hook.
+// They're allocated separately from the hook, to be 64-bit aligned.
+type counters struct {
+	fired   uint64
+	ignored uint64
+	written uint64
+	dropped uint64
+	expired uint64
+	errors  uint64
+	// lastCommitted is the UnixNano time of the last committed entry
+	lastCommitted int64
+	// shed are the entries shed by level
+	shed [logrus.TraceLevel + 1]uint64
+	// ignoredBy are the ignored entries by cause
+	ignoredBy [ignoreCauseCount]uint64
+	// named are the counters of the named filters and predicates, guarded
+	// by the mutex of the hook
+	named []*namedCount
+	// stages are the durations of the stages of the hook
+	stages [stageCount]histogram
+	// sizes are the sizes of the inserted entries
+	sizes sizeHistogram
+	// degraded is 1 while the hook is degraded (see OutagePolicy)
+	degraded int32
+}
+
+// queueCounters are the counters of the queue of an AsyncHook, updated
+// atomically.
+type queueCounters struct {
+	queued uint64
+	// oldestQueued is the UnixNano time of the oldest queued entry, 0 if
+	// none
+	oldestQueued int64
+}
+
+// Stats returns the current counters of the hook.
+func (hook *Hook) Stats() Stats {
+	queued, lag := hook.queueStats()
+	return Stats{
+		Fired:     atomic.LoadUint64(&hook.stats.fired),
+		Ignored:   atomic.LoadUint64(&hook.stats.ignored),
+		IgnoredBy: hook.ignoredBy(),
+		Queued:    queued,
+		Written:   atomic.LoadUint64(&hook.stats.written),
+		Dropped:   atomic.LoadUint64(&hook.stats.dropped),
+		Expired:   atomic.LoadUint64(&hook.stats.expired),
+		Errors:    atomic.LoadUint64(&hook.stats.errors),
+		Lag:       lag,
+		Shed:      hook.shed(),
+		Degraded:  atomic.LoadInt32(&hook.stats.degraded) == 1,
+	}
+}
+
+// addQueue adds the counters of the queue of an AsyncHook to the stats of hook.
+func (hook *Hook) addQueue(q *queueCounters) {
+	hook.mu.Lock()
+	hook.queues = append(hook.queues, q)
+	hook.mu.Unlock()
+}
+
+// queueStats returns the number of entries queued by the AsyncHooks of hook
+// (its pipelines have their own queue), and the lag of the most lagging one.
+func (hook *Hook) queueStats() (queued uint64, lag time.Duration) {
+	hook.mu.RLock()
+	queues := hook.queues
+	hook.mu.RUnlock()
+	for _, q := range queues {
+		queued += atomic.LoadUint64(&q.queued)
+		if l := q.lag(); l > lag {
+			lag = l
+		}
+	}
+	return queued, lag
+}
+
+// shed returns the number of entries shed by level.
+func (hook *Hook) shed() [logrus.TraceLevel + 1]uint64 {
+	var shed [logrus.TraceLevel + 1]uint64
+	for level := range shed {
+		shed[level] = atomic.LoadUint64(&hook.stats.shed[level])
+	}
+	return shed
+}
+
+// lag returns the age of the oldest entry of the queue
+func (q *queueCounters) lag() time.Duration {
+	oldest := atomic.LoadInt64(&q.oldestQueued)
+	if oldest == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, oldest))
+}
+
+// setOldestQueued records entry as the oldest queued entry
+func (hook *AsyncHook) setOldestQueued(entry *logrus.Entry) {
+	t := entry.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	atomic.StoreInt64(&hook.queue.oldestQueued, t.UnixNano())
+}
+
+// checkLag reports the lag of the hook when it exceeds MaxLag.
+func (hook *AsyncHook) checkLag() {
+	if hook.MaxLag <= 0 {
+		return
+	}
+	lag := hook.queue.lag()
+	if lag <= hook.MaxLag {
+		hook.lagging = false
+		return
+	}
+	if !hook.lagging {
+		hook.lagging = true
+		hook.handleError(&ErrorEvent{Time: time.Now(), Op: "lag", Err: fmt.Errorf("oldest queued entry is %v old", lag.Round(time.Millisecond))})
+	}
+}
+
+// PublishExpvar publishes the hook stats with expvar, under name (eg.
+// "pglogrus"), served by the expvar handler at /debug/vars.
+// Like expvar.Publish, it panics if name is already published.
+func (hook *Hook) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return hook.Stats()
+	}))
+}
+
+// EntrySize estimates the size of entry once stored: the size of its message,
+// its data encoded in JSON, and of the other columns.
+func EntrySize(entry *logrus.Entry) int {
+	const columnsSize = 2 + 8 // level and created_at
+	size := columnsSize + len(entry.Message)
+	if data, err := json.Marshal(entry.Data); err == nil {
+		size += len(data)
+	}
+	return size
+}
@@ -0,0 +1,154 @@
+package pglogrus
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A StoredEntry is an entry read from the hook table, with ScanEntry.
+// Its fields can also be scanned from custom queries:
+//
+//	var e pglogrus.StoredEntry
+//	err := db.QueryRow("SELECT level, message, message_data, created_at FROM logs WHERE id = $1", id).
+//		Scan(&e.Level, &e.Message, &e.Data, &e.CreatedAt)
+type StoredEntry struct {
+	ID        int64
+	Level     StoredLevel
+	Message   string
+	Data      StoredFields
+	CreatedAt StoredTime
+	// Shard is the index of the shard of the entry, if the table is sharded.
+	// Ids are only unique by shard.
+	Shard int
+	// Columns are the values of the Columns of the table (and of its
+	// UnknownColumn), by name. Binary values are converted to strings.
+	Columns map[string]interface{}
+
+	// table is the config of the table, to restore the fields stored in
+	// columns
+	table *TableConfig
+}
+
+// StoredLevel is the level of a stored entry. It's stored as an integer, and
+// can be scanned from an integer or a level name (eg. "info").
+type StoredLevel logrus.Level
+
+// Scan implements sql.Scanner.
+func (l *StoredLevel) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case int64:
+		*l = StoredLevel(v)
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("pglogrus: can't scan %T as a level", src)
+	}
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		*l = StoredLevel(n)
+		return nil
+	}
+	level, err := logrus.ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	*l = StoredLevel(level)
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (l StoredLevel) Value() (driver.Value, error) {
+	return int64(l), nil
+}
+
+func (l StoredLevel) String() string {
+	return logrus.Level(l).String()
+}
+
+// StoredFields are the fields of a stored entry, stored as a JSON object
+// (message_data). NULL is scanned as nil.
+type StoredFields logrus.Fields
+
+// Scan implements sql.Scanner.
+func (f *StoredFields) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*f = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("pglogrus: can't scan %T as fields", src)
+	}
+	fields := StoredFields{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	*f = fields
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (f StoredFields) Value() (driver.Value, error) {
+	if f == nil {
+		return nil, nil
+	}
+	return json.Marshal(f)
+}
+
+// timestampLayouts are the layouts of the timestamps scanned from text, as
+// stored by SQLite and MySQL.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// StoredTime is the time of a stored entry (created_at). It can be scanned
+// from a time or its text representation.
+type StoredTime time.Time
+
+// Scan implements sql.Scanner.
+func (t *StoredTime) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case time.Time:
+		*t = StoredTime(v)
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("pglogrus: can't scan %T as a timestamp", src)
+	}
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			*t = StoredTime(parsed)
+			return nil
+		}
+	}
+	return fmt.Errorf("pglogrus: invalid timestamp %q", s)
+}
+
+// Value implements driver.Valuer.
+func (t StoredTime) Value() (driver.Value, error) {
+	return time.Time(t), nil
+}
+
+// Time returns t as a time.Time.
+func (t StoredTime) Time() time.Time {
+	return time.Time(t)
+}
@@ -0,0 +1,151 @@
+package pglogrus
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SyslogSeverity maps logrus levels to the numeric severities of syslog
+// (RFC 5424): 0 is Emergency, 7 is Debug.
+var SyslogSeverity = map[logrus.Level]int{
+	logrus.PanicLevel: 0, // Emergency
+	logrus.FatalLevel: 2, // Critical
+	logrus.ErrorLevel: 3, // Error
+	logrus.WarnLevel:  4, // Warning
+	logrus.InfoLevel:  6, // Informational
+	logrus.DebugLevel: 7, // Debug
+	logrus.TraceLevel: 7, // Debug
+}
+
+// SeverityColumn returns a column generated from the level of the entries,
+// storing their syslog severity, as mapped by severities (SyslogSeverity if
+// nil). Levels missing from severities are Debug (7).
+func SeverityColumn(name string, severities map[logrus.Level]int) Column {
+	if severities == nil {
+		severities = SyslogSeverity
+	}
+	levels := make([]int, 0, len(severities))
+	for level := range severities {
+		levels = append(levels, int(level))
+	}
+	sort.Ints(levels)
+	var expr strings.Builder
+	expr.WriteString("CASE level")
+	for _, level := range levels {
+		fmt.Fprintf(&expr, " WHEN %d THEN %d", level, severities[logrus.Level(level)])
+	}
+	expr.WriteString(" ELSE 7 END")
+	return Column{Name: name, Type: "smallint", Generated: expr.String()}
+}
+
+// FacilityColumn returns a column storing the syslog facility of the
+// entries, like 1 (user-level messages) or 16 (local0).
+func FacilityColumn(name string, facility int) Column {
+	return Column{Name: name, Type: "smallint", Generated: fmt.Sprint(facility)}
+}
+
+// RFC5424Formatter formats entries as syslog lines (RFC 5424), with the
+// entry fields as structured data. It can be used as the Formatter of a
+// logger, or to export stored entries (see ExportOptions).
+type RFC5424Formatter struct {
+	// Facility of the entries, like 1 (user-level messages) or 16 (local0).
+	Facility int
+	// Severities maps levels to syslog severities, SyslogSeverity if nil.
+	Severities map[logrus.Level]int
+	// Hostname and AppName are the HOSTNAME and APP-NAME of the lines, if
+	// set.
+	Hostname, AppName string
+	// SDID is the ID of the structured data element containing the fields,
+	// "fields@32473" by default.
+	SDID string
+}
+
+// Format implements logrus.Formatter.
+func (f *RFC5424Formatter) Format(entry *logrus.Entry) ([]byte, error) {
+	severities := f.Severities
+	if severities == nil {
+		severities = SyslogSeverity
+	}
+	severity, ok := severities[entry.Level]
+	if !ok {
+		severity = 7
+	}
+	sdID := f.SDID
+	if sdID == "" {
+		sdID = "fields@32473"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s - - ", f.Facility*8+severity, entry.Time.Format("2006-01-02T15:04:05.999999Z07:00"), syslogHeader(f.Hostname), syslogHeader(f.AppName))
+	if len(entry.Data) == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString("[" + sdID)
+		keys := make([]string, 0, len(entry.Data))
+		for k := range entry.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v, err := fieldString(entry.Data[k])
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, ` %s="%s"`, sdParamName(k), sdParamValueEscaper.Replace(v))
+		}
+		b.WriteString("]")
+	}
+	if entry.Message != "" {
+		b.WriteString(" " + entry.Message)
+	}
+	b.WriteString("\n")
+	return []byte(b.String()), nil
+}
+
+// syslogHeader returns s as a header field of a syslog line: printable ASCII
+// without spaces, or "-" if empty.
+func syslogHeader(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// sdParamName returns k as the name of a structured data parameter: at most
+// 32 printable ASCII characters, without '=', ']' and '"'.
+func sdParamName(k string) string {
+	k = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, k)
+	if len(k) > 32 {
+		k = k[:32]
+	}
+	return k
+}
+
+// sdParamValueEscaper escapes the characters of structured data parameter
+// values.
+var sdParamValueEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// fieldString returns the text representation of a field value: strings are
+// kept as is, other values are encoded in JSON.
+func fieldString(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
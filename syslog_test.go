@@ -0,0 +1,65 @@
+package pglogrus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRFC5424Formatter(t *testing.T) {
+	at := time.Date(2019, 3, 18, 10, 0, 0, 123456789, time.UTC)
+	tests := map[string]struct {
+		formatter *RFC5424Formatter
+		entry     *logrus.Entry
+		expected  string
+	}{
+		"fields": {
+			formatter: &RFC5424Formatter{Facility: 16, Hostname: "web 1", AppName: "api"},
+			entry: &logrus.Entry{
+				Level:   logrus.ErrorLevel,
+				Message: "it failed",
+				Time:    at,
+				Data:    logrus.Fields{"user": "12", "path": `/a"b]`, "status": 500},
+			},
+			expected: `<131>1 2019-03-18T10:00:00.123456Z web_1 api - - [fields@32473 path="/a\"b\]" status="500" user="12"] it failed` + "\n",
+		},
+		"no fields": {
+			formatter: &RFC5424Formatter{SDID: "app@1234"},
+			entry:     &logrus.Entry{Level: logrus.InfoLevel, Message: "started", Time: at, Data: logrus.Fields{}},
+			expected:  "<6>1 2019-03-18T10:00:00.123456Z - - - - - started\n",
+		},
+	}
+	for name, test := range tests {
+		line, err := test.formatter.Format(test.entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(line) != test.expected {
+			t.Errorf("%s: Expected line to be %q, got %q\n", name, test.expected, line)
+		}
+	}
+}
+
+func TestJournaldFormatter(t *testing.T) {
+	formatter := &JournaldFormatter{Identifier: "api"}
+	line, err := formatter.Format(&logrus.Entry{
+		Level:   logrus.WarnLevel,
+		Message: "slow\nquery",
+		Time:    time.Unix(1552903200, 5000),
+		Data:    logrus.Fields{"request.id": "a1", "_secret": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "__REALTIME_TIMESTAMP=1552903200000005\n" +
+		"MESSAGE\n\x0a\x00\x00\x00\x00\x00\x00\x00slow\nquery\n" +
+		"PRIORITY=4\n" +
+		"SYSLOG_IDENTIFIER=api\n" +
+		"F_SECRET=true\n" +
+		"REQUEST_ID=a1\n" +
+		"\n"
+	if string(line) != expected {
+		t.Errorf("Expected entry to be %q, got %q\n", expected, line)
+	}
+}
@@ -0,0 +1,402 @@
+package pglogrus
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TableConfig describes the table where the default InsertFunc stores entries.
+// The table must have at least the columns of the schema in `migrations`.
+type TableConfig struct {
+	// Name of the table, "logs" by default.
+	Name string
+	// Columns are additional columns, filled with entry fields.
+	Columns []Column
+	// TimeIndex is the method of the index created on created_at by
+	// EnsureSchema: "btree", or "brin" for very large append-only tables.
+	// No index is created by default.
+	TimeIndex string
+	// StorageParameters are set on the table by EnsureSchema (eg.
+	// "fillfactor" or "autovacuum_analyze_scale_factor").
+	// See AppendOnlyStorage.
+	StorageParameters map[string]string
+	// ColumnStorage sets the storage and compression of columns by name (eg.
+	// "message_data"), to tune the TOAST of large values (PostgreSQL only).
+	ColumnStorage map[string]ColumnStorage
+	// Shards spreads the entries over several tables, named after Name:
+	// "logs_0", "logs_1", ... The table of each entry is chosen by a hash of
+	// its ShardField (eg. "tenant"), to reduce the contention on the indexes
+	// of very busy tables. Reads (Export, Archive, ...) cover all the shards.
+	Shards     int
+	ShardField string
+	// Dialect is the SQL dialect of the database, Postgres by default.
+	Dialect Dialect
+	// Payload returns the value stored in message_data, instead of the entry
+	// fields (eg. GELFPayload).
+	Payload Payload `json:"-"`
+	// Encoding encodes payloads in a binary message_data column, instead of
+	// JSON (eg. MarshalCBOR).
+	Encoding Encoding `json:"-"`
+	// Wide makes a fully relational table, without message_data: each field
+	// is stored in its own typed column, and Columns are the closed set of
+	// the fields of entries. Other fields are relocated to the UnknownColumn
+	// as logfmt text (eg. `user=12 path="/a b"`), or the entries having such
+	// fields are rejected if there's no UnknownColumn.
+	Wide          bool
+	UnknownColumn string
+	// Helpers makes EnsureSchema create views and functions to query the
+	// table (PostgreSQL only), named after the table:
+	//   - logs_errors_last_24h: the entries of the last 24 hours at error
+	//     level or above,
+	//   - logs_by_service: the number of entries by ServiceField ("service" by
+	//     default) and level, with the time of their last entry,
+	//   - logs_search(text): the entries whose message or fields contain the
+	//     text (case insensitive), latest first.
+	Helpers      bool
+	ServiceField string
+	// PayloadTable stores the payloads larger than PayloadThreshold bytes
+	// (eg. "log_payloads"), referenced by the PayloadIDColumn of the table,
+	// to keep the table narrow and fast to scan (PostgreSQL only).
+	// message_data is then an empty object. Reads (Export, Archive, ...)
+	// fetch the offloaded payloads, and Prune and Archive delete them.
+	PayloadTable     string
+	PayloadThreshold int
+}
+
+// AppendOnlyStorage returns storage parameters suited for large append-only
+// log tables: pages are filled completely, and autovacuum runs more often with
+// a larger budget, to keep statistics fresh and avoid long vacuums.
+func AppendOnlyStorage() map[string]string {
+	return map[string]string{
+		"fillfactor":                      "100",
+		"autovacuum_analyze_scale_factor": "0.01",
+		"autovacuum_vacuum_scale_factor":  "0.05",
+		"autovacuum_vacuum_cost_limit":    "1000",
+	}
+}
+
+// ColumnStorage is the storage of the values of a column, set by
+// EnsureSchema (see ALTER TABLE in the PostgreSQL documentation).
+type ColumnStorage struct {
+	// Storage is "PLAIN", "MAIN", "EXTERNAL" (out of line, uncompressed: fast
+	// substring operations) or "EXTENDED" (out of line, compressed: the
+	// default).
+	Storage string
+	// Compression is "pglz" or "lz4" (faster, PostgreSQL 14 or later).
+	Compression string
+}
+
+// Column maps an entry field to a dedicated column of the table.
+// The field is stored in the column instead of message_data.
+type Column struct {
+	// Name of the column.
+	Name string
+	// Field is the entry field stored in the column.
+	Field string
+	// Type is the PostgreSQL type of the column (eg. "inet"), "text" by
+	// default.
+	// Values of the "inet", "cidr", "uuid" and PostGIS types (see
+	// PointColumn and GeoJSONColumn) are validated before being inserted:
+	// invalid values are kept in message_data, and the column is set to NULL.
+	Type string
+	// Index makes EnsureSchema create an index on the column.
+	Index bool
+	// Generated is the SQL expression of a generated column, computed by
+	// PostgreSQL from the other columns (eg. "date_trunc('day', created_at)").
+	// Generated columns have no Field: they're never inserted.
+	Generated string
+	// Default is the SQL expression of the default value of the column.
+	// Columns with a Default and no Field are never inserted, like
+	// generated columns.
+	Default string
+	// Fields are entry fields stored together in the column, as a JSON
+	// object, instead of a single Field. See LabelsColumn.
+	Fields []string
+}
+
+// UUIDColumn returns an indexed uuid column storing field, such as
+// "request_id" or "correlation_id".
+func UUIDColumn(field string) Column {
+	return Column{Name: field, Field: field, Type: "uuid", Index: true}
+}
+
+// IngestedAtColumn returns a column storing when entries were inserted in the
+// database, according to its clock, while created_at is the time of the entry.
+// It measures the clock skew and delay of the logging pipeline.
+// Entries of the same transaction share the same time (see now() in
+// PostgreSQL).
+func IngestedAtColumn(name string) Column {
+	return Column{Name: name, Type: "timestamp with time zone", Default: "now()"}
+}
+
+// LabelsColumn returns a jsonb column storing fields as "labels", like Loki:
+// a few low-cardinality fields identifying the source of entries (eg. "app",
+// "env" or "region"), while the other fields remain in message_data.
+func LabelsColumn(name string, fields ...string) Column {
+	return Column{Name: name, Type: "jsonb", Fields: fields}
+}
+
+// hasField reports whether a column of the table stores field.
+func (t *TableConfig) hasField(field string) bool {
+	for _, c := range t.Columns {
+		if c.Field == field {
+			return true
+		}
+		for _, f := range c.Fields {
+			if f == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// object returns the JSON object of the Fields of the column, and removes
+// them from data. It returns nil if none of the fields is set.
+func (c Column) object(data logrus.Fields) (interface{}, error) {
+	if srid, ok := c.srid(); ok && len(c.Fields) == 2 {
+		return c.point(data, srid), nil
+	}
+	obj := logrus.Fields{}
+	for _, f := range c.Fields {
+		if v, ok := data[f]; ok {
+			obj[f] = v
+			delete(data, f)
+		}
+	}
+	if len(obj) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// inserted reports whether the column is set by insert statements.
+func (c Column) inserted() bool {
+	if c.Generated != "" {
+		return false
+	}
+	return c.Field != "" || len(c.Fields) > 0 || c.Default == ""
+}
+
+// value returns the value to insert in the column for v, and whether v was
+// valid for the column.
+func (c Column) value(v interface{}) (interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	switch c.Type {
+	case "inet", "cidr":
+		return ipValue(v, c.Type == "cidr")
+	case "uuid":
+		return uuidValue(v)
+	}
+	if srid, ok := c.srid(); ok {
+		return geoJSONValue(v, srid)
+	}
+	if raw, ok := v.(json.RawMessage); ok {
+		return string(raw), true
+	}
+	return v, true
+}
+
+// ipValue validates v as an inet (or cidr) value.
+func ipValue(v interface{}, cidr bool) (interface{}, bool) {
+	if encoded, ok := IPEncoder()(v); ok {
+		v = encoded
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+	if ip, ipNet, err := net.ParseCIDR(s); err == nil {
+		if cidr && !ip.Equal(ipNet.IP) {
+			// cidr values can't have bits set to the right of the netmask
+			return nil, false
+		}
+		return s, true
+	}
+	if net.ParseIP(s) == nil {
+		return nil, false
+	}
+	return s, true
+}
+
+// uuidValue validates v as a uuid value, in its canonical form or without
+// hyphens.
+func uuidValue(v interface{}) (interface{}, bool) {
+	var s string
+	switch id := v.(type) {
+	case string:
+		s = id
+	case [16]byte:
+		s = hex.EncodeToString(id[:])
+	case fmt.Stringer:
+		s = id.String()
+	default:
+		return nil, false
+	}
+	h := s
+	if len(s) == 36 {
+		if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return nil, false
+		}
+		h = strings.Replace(s, "-", "", -1)
+	}
+	if len(h) != 32 {
+		return nil, false
+	}
+	if _, err := hex.DecodeString(h); err != nil {
+		return nil, false
+	}
+	return s, true
+}
+
+// insertStatement returns the query and its arguments to insert entry in
+// the table.
+func (t *TableConfig) insertStatement(entry *logrus.Entry) (string, []interface{}, error) {
+	columns, args, err := t.insertValues(entry)
+	if err != nil {
+		return "", nil, err
+	}
+	if !t.Wide && t.offloaded(args[2]) {
+		return t.offloadStatement(entry, columns, args), args, nil
+	}
+	return t.statement(entry, columns, args), args, nil
+}
+
+// insertValues returns the columns and the values inserting entry in the
+// table.
+func (t *TableConfig) insertValues(entry *logrus.Entry) ([]string, []interface{}, error) {
+	columns := []string{"level", "message", "message_data", "created_at"}
+	args := []interface{}{entry.Level, entry.Message, nil, entry.Time}
+
+	data := entry.Data
+	if len(t.Columns) > 0 || t.Wide {
+		// Don't alter entry.Data, the entry may still be used by the caller
+		data = make(logrus.Fields, len(entry.Data))
+		for k, v := range entry.Data {
+			data[k] = v
+		}
+		for _, c := range t.Columns {
+			if !c.inserted() {
+				continue
+			}
+			if len(c.Fields) > 0 {
+				v, err := c.object(data)
+				if err != nil {
+					return nil, nil, err
+				}
+				columns = append(columns, c.Name)
+				args = append(args, v)
+				continue
+			}
+			v, ok := c.value(data[c.Field])
+			if ok {
+				delete(data, c.Field)
+			}
+			columns = append(columns, c.Name)
+			args = append(args, v)
+		}
+	}
+
+	if t.Wide {
+		// Remove message_data
+		columns = append(columns[:2], columns[3:]...)
+		args = append(args[:2], args[3:]...)
+		if len(data) > 0 {
+			if t.UnknownColumn == "" {
+				return nil, nil, fmt.Errorf("pglogrus: fields without column: %s", strings.Join(sortedKeys(data), ", "))
+			}
+			columns = append(columns, t.UnknownColumn)
+			args = append(args, logfmt(data))
+		}
+		return columns, args, nil
+	}
+
+	var payload interface{} = data
+	if t.Payload != nil {
+		payload = t.Payload(entry, data)
+	}
+	if t.Encoding != nil {
+		encoded, err := t.Encoding(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		args[2] = encoded
+	} else {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		args[2] = t.Dialect.jsonValue(jsonData)
+	}
+
+	return columns, args, nil
+}
+
+// statement returns the query inserting args in columns, for entry.
+func (t *TableConfig) statement(entry *logrus.Entry, columns []string, args []interface{}) string {
+	placeholders := make([]string, len(args))
+	for i := range args {
+		placeholders[i] = t.Dialect.placeholder(i + 1)
+	}
+	return fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s);", t.tableName(entry), strings.Join(columns, ", "), strings.Join(placeholders, ","))
+}
+
+// sortedKeys returns the keys of data, sorted.
+func sortedKeys(data logrus.Fields) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// logfmt returns data formatted as logfmt, sorted by key.
+func logfmt(data logrus.Fields) string {
+	var b strings.Builder
+	for i, k := range sortedKeys(data) {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		v := fmt.Sprint(data[k])
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(k + "=" + v)
+	}
+	return b.String()
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insert stores entry in the hook table
+func (hook *Hook) insert(db execer, entry *logrus.Entry) error {
+	start := time.Now()
+	query, args, err := hook.table().insertStatement(entry)
+	if err != nil {
+		return err
+	}
+	hook.observe(stageMarshal, start)
+	hook.observeSize(args)
+	defer hook.observe(stageInsert, time.Now())
+	_, err = db.Exec(query, args...)
+	return err
+}
@@ -0,0 +1,937 @@
+package pglogrus
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/hex"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestInsertStatement(t *testing.T) {
+	table := TableConfig{
+		Name: "logs",
+		Columns: []Column{
+			{Name: "client_ip", Field: "client_ip", Type: "inet"},
+			{Name: "network", Field: "network", Type: "cidr"},
+		},
+	}
+
+	tests := map[string]struct {
+		data     logrus.Fields
+		args     []interface{}
+		jsonData string
+	}{
+		"valid": {
+			data:     logrus.Fields{"client_ip": net.ParseIP("10.0.0.1"), "network": "10.0.0.0/8", "user": "123"},
+			args:     []interface{}{"10.0.0.1", "10.0.0.0/8"},
+			jsonData: `{"user":"123"}`,
+		},
+		"invalid": {
+			data:     logrus.Fields{"client_ip": "localhost", "network": "10.0.0.1/8"},
+			args:     []interface{}{nil, nil},
+			jsonData: `{"client_ip":"localhost","network":"10.0.0.1/8"}`,
+		},
+		"missing": {
+			data:     logrus.Fields{},
+			args:     []interface{}{nil, nil},
+			jsonData: `{}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			query, args, err := table.insertStatement(&logrus.Entry{Data: test.data})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedQuery := "INSERT INTO logs(level, message, message_data, created_at, client_ip, network) VALUES ($1,$2,$3,$4,$5,$6);"
+			if query != expectedQuery {
+				t.Errorf("Expected query to be %q, got %q\n", expectedQuery, query)
+			}
+			if jsonData := string(args[2].([]byte)); jsonData != test.jsonData {
+				t.Errorf("Expected message_data to be %s, got %s\n", test.jsonData, jsonData)
+			}
+			if !reflect.DeepEqual(test.args, args[4:]) {
+				t.Errorf("Expected column values to be %v, got %v\n", test.args, args[4:])
+			}
+		})
+	}
+}
+
+func TestUUIDValue(t *testing.T) {
+	tests := map[string]bool{
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c8": true,
+		"6ba7b8109dad11d180b400c04fd430c8":     true,
+		"6ba7b810-9dad-11d1-80b4_00c04fd430c8": false,
+		"6ba7b810-9dad-11d1-80b4-00c04fd430cz": false,
+		"123":                                  false,
+	}
+	for id, valid := range tests {
+		if _, ok := uuidValue(id); ok != valid {
+			t.Errorf("Expected validity of %q to be %v, got %v\n", id, valid, ok)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	table := TableConfig{
+		Name:      "public.logs",
+		Columns:   []Column{UUIDColumn("request_id"), {Name: "client_ip", Field: "ip", Type: "inet"}},
+		TimeIndex: "brin",
+		StorageParameters: map[string]string{
+			"fillfactor":                      "100",
+			"autovacuum_analyze_scale_factor": "0.01",
+		},
+		ColumnStorage: map[string]ColumnStorage{
+			"message_data": {Storage: "external", Compression: "lz4"},
+			"client_ip":    {Compression: "pglz"},
+		},
+	}
+	expected := []string{
+		`CREATE TABLE IF NOT EXISTS public.logs (
+    id SERIAL,
+    level smallint NOT NULL,
+    message text NOT NULL,
+    message_data json NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    request_id uuid,
+    client_ip inet
+);`,
+		"ALTER TABLE public.logs SET (autovacuum_analyze_scale_factor = 0.01, fillfactor = 100);",
+		"ALTER TABLE public.logs ALTER COLUMN client_ip SET COMPRESSION pglz, ALTER COLUMN message_data SET STORAGE EXTERNAL, ALTER COLUMN message_data SET COMPRESSION lz4;",
+		"CREATE INDEX IF NOT EXISTS logs_created_at_idx ON public.logs USING brin (created_at);",
+		"CREATE INDEX IF NOT EXISTS logs_request_id_idx ON public.logs (request_id);",
+	}
+	if schema := table.Schema(); !reflect.DeepEqual(expected, schema) {
+		t.Errorf("Expected schema to be %q, got %q\n", expected, schema)
+	}
+	if err := table.validateColumnStorage(); err != nil {
+		t.Error(err)
+	}
+
+	// Values of ColumnStorage are written in the statement
+	for name, storage := range map[string]map[string]ColumnStorage{
+		"column":      {"message_data; DROP TABLE logs; --": {Storage: "EXTERNAL"}},
+		"storage":     {"message_data": {Storage: "EXTERNAL; DROP TABLE logs"}},
+		"compression": {"message_data": {Compression: "zstd"}},
+	} {
+		db, recorded := openRecordingDB(nil)
+		hook := NewHook(db, nil)
+		hook.Table.ColumnStorage = storage
+		if err := hook.EnsureSchema(context.Background()); err == nil || len(recorded.statements) > 0 {
+			t.Errorf("%s: Expected ColumnStorage to be rejected, got %v and statements %q\n", name, err, recorded.statements)
+		}
+	}
+}
+
+func TestMigrationsRegistry(t *testing.T) {
+	tables := []*TableConfig{
+		{Name: "logs", Encoding: MarshalCBOR, PayloadTable: "log_payloads"},
+		{Name: "logs", Wide: true, UnknownColumn: "extra"},
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Expected migration %d to have version %d, got %d\n", i, i+1, m.Version)
+		}
+		var up, down int
+		for _, table := range tables {
+			up += len(m.Up(table))
+			down += len(m.Down(table))
+		}
+		if up == 0 || down == 0 {
+			t.Errorf("Expected migration %d to have up and down statements\n", m.Version)
+		}
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	// Migrations are frozen: they don't follow the current Schema
+	table := &TableConfig{Name: "logs", Shards: 2, Columns: []Column{{Name: "app", Index: true}}, PayloadTable: "log_payloads"}
+	var stmts []string
+	for _, m := range migrations {
+		stmts = append(stmts, m.Up(table)...)
+	}
+	expected := []string{
+		"CREATE TABLE IF NOT EXISTS logs_0 (\n    id SERIAL,\n    level smallint NOT NULL,\n    message text NOT NULL,\n    message_data json NOT NULL,\n    created_at timestamp with time zone NOT NULL\n);",
+		"ALTER TABLE logs_0 ADD COLUMN IF NOT EXISTS app text;",
+		"CREATE INDEX IF NOT EXISTS logs_0_app_idx ON logs_0 (app);",
+		"CREATE TABLE IF NOT EXISTS logs_1 (\n    id SERIAL,\n    level smallint NOT NULL,\n    message text NOT NULL,\n    message_data json NOT NULL,\n    created_at timestamp with time zone NOT NULL\n);",
+		"ALTER TABLE logs_1 ADD COLUMN IF NOT EXISTS app text;",
+		"CREATE INDEX IF NOT EXISTS logs_1_app_idx ON logs_1 (app);",
+		"CREATE TABLE IF NOT EXISTS log_payloads (\n    id bigserial PRIMARY KEY,\n    message_data json NOT NULL,\n    created_at timestamp with time zone NOT NULL\n);",
+		"ALTER TABLE logs_0 ADD COLUMN IF NOT EXISTS payload_id bigint;",
+		"ALTER TABLE logs_1 ADD COLUMN IF NOT EXISTS payload_id bigint;",
+	}
+	if !reflect.DeepEqual(expected, stmts) {
+		t.Errorf("Expected migrations to be %q, got %q\n", expected, stmts)
+	}
+
+	table = &TableConfig{Name: "logs", Encoding: MarshalCBOR}
+	expectedUp := []string{"ALTER TABLE logs ALTER COLUMN message_data TYPE bytea USING convert_to(message_data::text, 'UTF8');"}
+	if up := migrations[1].Up(table); !reflect.DeepEqual(expectedUp, up) {
+		t.Errorf("Expected binary message_data migration to be %q, got %q\n", expectedUp, up)
+	}
+	if migrations[1].Destructive {
+		t.Errorf("Expected binary message_data migration not to be destructive\n")
+	}
+	for _, version := range []int{1, 3, 4} {
+		if !migrations[version-1].Destructive {
+			t.Errorf("Expected migration %d to be destructive\n", version)
+		}
+	}
+}
+
+func TestGeneratedColumns(t *testing.T) {
+	table := TableConfig{
+		Name:    "logs",
+		Columns: []Column{{Name: "created_on", Type: "date", Generated: "(created_at AT TIME ZONE 'UTC')::date"}},
+	}
+	query, _, err := table.insertStatement(&logrus.Entry{Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedQuery := "INSERT INTO logs(level, message, message_data, created_at) VALUES ($1,$2,$3,$4);"
+	if query != expectedQuery {
+		t.Errorf("Expected query to be %q, got %q\n", expectedQuery, query)
+	}
+	expectedDefinition := "created_on date GENERATED ALWAYS AS ((created_at AT TIME ZONE 'UTC')::date) STORED"
+	if d := table.Columns[0].definition(); d != expectedDefinition {
+		t.Errorf("Expected column definition to be %q, got %q\n", expectedDefinition, d)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	var buf bytes.Buffer
+	hook := NewAsyncHook(nil, map[string]interface{}{})
+	hook.Table.Columns = []Column{UUIDColumn("request_id")}
+	hook.DryRun = &buf
+
+	err := hook.Fire(&logrus.Entry{
+		Level:   logrus.InfoLevel,
+		Message: "it's done",
+		Data:    logrus.Fields{"request_id": "not-a-uuid"},
+		Time:    time.Date(2019, 3, 18, 10, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `INSERT INTO logs(level, message, message_data, created_at, request_id) VALUES (4,'it''s done','{"request_id":"not-a-uuid"}','2019-03-18T10:00:00Z',NULL);` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected dry run output to be %q, got %q\n", expected, buf.String())
+	}
+}
+
+func TestRenderStatement(t *testing.T) {
+	args := make([]interface{}, 10)
+	for i := range args {
+		args[i] = i + 1
+	}
+	args[0] = "costs $1 and $4"
+	args[9] = "$10"
+	query := "INSERT INTO logs VALUES ($1,$4,$10,$11,'$');"
+	expected := "INSERT INTO logs VALUES ('costs $1 and $4',4,'$10',$11,'$');"
+	if stmt := renderStatement(query, args); stmt != expected {
+		t.Errorf("Expected dry run statement to be %q, got %q\n", expected, stmt)
+	}
+}
+
+func TestBatchID(t *testing.T) {
+	id := newUUID()
+	if _, ok := uuidValue(id); !ok {
+		t.Fatalf("Expected %q to be a valid uuid\n", id)
+	}
+
+	entry := &logrus.Entry{Data: logrus.Fields{"a": "b"}}
+	stamped := withBatchID([]*logrus.Entry{entry}, id)
+	if stamped[0].Data[BatchIDField] != id || stamped[0].Data["a"] != "b" {
+		t.Errorf("Expected entry to be stamped with the batch ID, got %v\n", stamped[0].Data)
+	}
+	if _, ok := entry.Data[BatchIDField]; ok {
+		t.Error("Expected original entry not to be modified")
+	}
+
+	table := TableConfig{Name: "logs", Columns: []Column{BatchIDColumn("batch_id")}}
+	if !table.hasField(BatchIDField) {
+		t.Error("Expected table to store the batch ID")
+	}
+}
+
+func TestSyslogColumns(t *testing.T) {
+	tests := map[string]struct {
+		column   Column
+		expected string
+	}{
+		"default severities": {
+			column:   SeverityColumn("severity", nil),
+			expected: "severity smallint GENERATED ALWAYS AS (CASE level WHEN 0 THEN 0 WHEN 1 THEN 2 WHEN 2 THEN 3 WHEN 3 THEN 4 WHEN 4 THEN 6 WHEN 5 THEN 7 WHEN 6 THEN 7 ELSE 7 END) STORED",
+		},
+		"custom severities": {
+			column:   SeverityColumn("severity", map[logrus.Level]int{logrus.InfoLevel: 5, logrus.PanicLevel: 1}),
+			expected: "severity smallint GENERATED ALWAYS AS (CASE level WHEN 0 THEN 1 WHEN 4 THEN 5 ELSE 7 END) STORED",
+		},
+		"facility": {
+			column:   FacilityColumn("facility", 16),
+			expected: "facility smallint GENERATED ALWAYS AS (16) STORED",
+		},
+	}
+	for name, test := range tests {
+		if d := test.column.definition(); d != test.expected {
+			t.Errorf("%s: Expected column definition to be %q, got %q\n", name, test.expected, d)
+		}
+	}
+}
+
+func TestGELFPayload(t *testing.T) {
+	table := TableConfig{
+		Name:    "logs",
+		Columns: []Column{{Name: "user_id", Field: "user_id"}},
+		Payload: GELFPayload("web-1"),
+	}
+	_, args, err := table.insertStatement(&logrus.Entry{
+		Level:   logrus.ErrorLevel,
+		Message: "it failed\ngoroutine 1 [running]:",
+		Time:    time.Date(2019, 3, 18, 10, 0, 0, 500000000, time.UTC),
+		Data:    logrus.Fields{"user_id": "12", "id": 1, "request id": "a1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"_id_":1,"_request_id":"a1","full_message":"it failed\ngoroutine 1 [running]:","host":"web-1","level":3,"short_message":"it failed","timestamp":1552903200.5,"version":"1.1"}`
+	if jsonData := string(args[2].([]byte)); jsonData != expected {
+		t.Errorf("Expected message_data to be %s, got %s\n", expected, jsonData)
+	}
+}
+
+func TestLabelsColumn(t *testing.T) {
+	table := TableConfig{Name: "logs", Columns: []Column{LabelsColumn("labels", "app", "env")}}
+
+	tests := map[string]struct {
+		data     logrus.Fields
+		labels   interface{}
+		jsonData string
+	}{
+		"labels": {
+			data:     logrus.Fields{"app": "api", "env": "prod", "user": "12"},
+			labels:   `{"app":"api","env":"prod"}`,
+			jsonData: `{"user":"12"}`,
+		},
+		"no labels": {
+			data:     logrus.Fields{"user": "12"},
+			labels:   nil,
+			jsonData: `{"user":"12"}`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			query, args, err := table.insertStatement(&logrus.Entry{Data: test.data})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedQuery := "INSERT INTO logs(level, message, message_data, created_at, labels) VALUES ($1,$2,$3,$4,$5);"
+			if query != expectedQuery {
+				t.Errorf("Expected query to be %q, got %q\n", expectedQuery, query)
+			}
+			if jsonData := string(args[2].([]byte)); jsonData != test.jsonData {
+				t.Errorf("Expected message_data to be %s, got %s\n", test.jsonData, jsonData)
+			}
+			if args[4] != test.labels {
+				t.Errorf("Expected labels to be %v, got %v\n", test.labels, args[4])
+			}
+		})
+	}
+	if !table.hasField("env") {
+		t.Error("Expected table to store the env field")
+	}
+}
+
+func TestIngestedAtColumn(t *testing.T) {
+	table := TableConfig{Name: "logs", Columns: []Column{IngestedAtColumn("ingested_at")}}
+	query, _, err := table.insertStatement(&logrus.Entry{Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedQuery := "INSERT INTO logs(level, message, message_data, created_at) VALUES ($1,$2,$3,$4);"
+	if query != expectedQuery {
+		t.Errorf("Expected query to be %q, got %q\n", expectedQuery, query)
+	}
+	expectedDefinition := "ingested_at timestamp with time zone DEFAULT now()"
+	if d := table.Columns[0].definition(); d != expectedDefinition {
+		t.Errorf("Expected column definition to be %q, got %q\n", expectedDefinition, d)
+	}
+}
+
+func TestSchemaMismatches(t *testing.T) {
+	table := TableConfig{
+		Name: "logs",
+		Columns: []Column{
+			UUIDColumn("request_id"),
+			{Name: "user_id", Field: "user_id"},
+			{Name: "tags", Field: "tags", Type: "varchar(64)[]"},
+			{Name: "client_ip", Field: "ip", Type: "inet"},
+		},
+	}
+	tests := map[string]struct {
+		actual   map[string]string
+		expected []string
+	}{
+		"compatible": {
+			actual: map[string]string{
+				"id": "int4", "level": "int4", "message": "text", "message_data": "jsonb", "created_at": "timestamptz",
+				"request_id": "uuid", "user_id": "varchar", "tags": "_varchar", "client_ip": "inet",
+			},
+		},
+		"mismatches": {
+			actual: map[string]string{
+				"level": "int2", "message": "text", "message_data": "json", "created_at": "timestamp",
+				"request_id": "text", "user_id": "text", "tags": "_varchar",
+			},
+			expected: []string{
+				"column created_at is timestamp, expected timestamp with time zone",
+				"column request_id is text, expected uuid",
+				"column client_ip is missing",
+			},
+		},
+		"missing table": {
+			actual:   map[string]string{},
+			expected: []string{"table doesn't exist"},
+		},
+	}
+	for name, test := range tests {
+		if mismatches := table.schemaMismatches(test.actual); !reflect.DeepEqual(test.expected, mismatches) {
+			t.Errorf("%s: Expected mismatches to be %q, got %q\n", name, test.expected, mismatches)
+		}
+	}
+}
+
+func TestDialects(t *testing.T) {
+	tests := map[Dialect]struct {
+		query  string
+		schema []string
+		dryRun string
+	}{
+		MySQL: {
+			query: "INSERT INTO logs(level, message, message_data, created_at, request_id) VALUES (?,?,?,?,?);",
+			schema: []string{`CREATE TABLE IF NOT EXISTS logs (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    level smallint NOT NULL,
+    message text NOT NULL,
+    message_data json NOT NULL,
+    created_at datetime(6) NOT NULL,
+    request_id char(36),
+    INDEX logs_created_at_idx (created_at),
+    INDEX logs_request_id_idx (request_id)
+);`},
+			dryRun: `INSERT INTO logs(level, message, message_data, created_at, request_id) VALUES (4,'what?','{}','2019-03-18T10:00:00Z','6ba7b810-9dad-11d1-80b4-00c04fd430c8');`,
+		},
+		SQLite: {
+			query: "INSERT INTO logs(level, message, message_data, created_at, request_id) VALUES (?,?,?,?,?);",
+			schema: []string{`CREATE TABLE IF NOT EXISTS logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    level integer NOT NULL,
+    message text NOT NULL,
+    message_data text NOT NULL,
+    created_at timestamp NOT NULL,
+    request_id char(36)
+);`,
+				"CREATE INDEX IF NOT EXISTS logs_created_at_idx ON logs (created_at);",
+				"CREATE INDEX IF NOT EXISTS logs_request_id_idx ON logs (request_id);",
+			},
+			dryRun: `INSERT INTO logs(level, message, message_data, created_at, request_id) VALUES (4,'what?','{}','2019-03-18T10:00:00Z','6ba7b810-9dad-11d1-80b4-00c04fd430c8');`,
+		},
+	}
+	for dialect, test := range tests {
+		table := TableConfig{
+			Name:              "logs",
+			Columns:           []Column{{Name: "request_id", Field: "request_id", Type: "char(36)", Index: true}},
+			TimeIndex:         "brin",
+			StorageParameters: AppendOnlyStorage(),
+			Dialect:           dialect,
+		}
+		entry := &logrus.Entry{
+			Level:   logrus.InfoLevel,
+			Message: "what?",
+			Data:    logrus.Fields{"request_id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+			Time:    time.Date(2019, 3, 18, 10, 0, 0, 0, time.UTC),
+		}
+		query, args, err := table.insertStatement(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query != test.query {
+			t.Errorf("Expected query to be %q, got %q\n", test.query, query)
+		}
+		if _, ok := args[2].(string); !ok {
+			t.Errorf("Expected message_data to be a string, got %T\n", args[2])
+		}
+		if schema := table.Schema(); !reflect.DeepEqual(test.schema, schema) {
+			t.Errorf("Expected schema to be %q, got %q\n", test.schema, schema)
+		}
+		if stmt := dialect.renderStatement(query, args); stmt != test.dryRun {
+			t.Errorf("Expected dry run statement to be %q, got %q\n", test.dryRun, stmt)
+		}
+	}
+}
+
+func TestShards(t *testing.T) {
+	table := TableConfig{Name: "logs", Shards: 4, ShardField: "tenant"}
+
+	tables := map[string]string{}
+	for _, tenant := range []string{"a", "b", "c", "d", "e", "a"} {
+		entry := &logrus.Entry{Data: logrus.Fields{"tenant": tenant}}
+		query, _, err := table.insertStatement(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := strings.Fields(query)[2]
+		name = name[:strings.Index(name, "(")]
+		if previous, ok := tables[tenant]; ok && previous != name {
+			t.Errorf("Expected entries of tenant %s to be stored in %s, got %s\n", tenant, previous, name)
+		}
+		tables[tenant] = name
+		if expected := table.tableNames()[table.shard(entry)]; name != expected {
+			t.Errorf("Expected entry of tenant %s to be stored in %s, got %s\n", tenant, expected, name)
+		}
+	}
+
+	if schema := table.Schema(); len(schema) != 4 || !strings.Contains(schema[3], "logs_3 (") {
+		t.Errorf("Expected schema to create 4 shards, got %q\n", schema)
+	}
+
+	expectedQuery := "SELECT id, level, message, message_data, created_at, pglogrus_shard FROM (SELECT id, level, message, message_data, created_at, 0 AS pglogrus_shard FROM logs_0 UNION ALL SELECT id, level, message, message_data, created_at, 1 AS pglogrus_shard FROM logs_1 UNION ALL SELECT id, level, message, message_data, created_at, 2 AS pglogrus_shard FROM logs_2 UNION ALL SELECT id, level, message, message_data, created_at, 3 AS pglogrus_shard FROM logs_3) AS logs ORDER BY created_at;"
+	if query := table.SelectQuery("ORDER BY created_at"); query != expectedQuery {
+		t.Errorf("Expected query to be %q, got %q\n", expectedQuery, query)
+	}
+
+	// Pages are read by time, id and shard, as ids are only unique by shard
+	t0 := time.Date(2019, 3, 18, 10, 0, 0, 0, time.UTC)
+	db, recorded := openRecordingDB(func(query string) [][]driver.Value {
+		return [][]driver.Value{{int64(7), int64(4), "a", []byte("{}"), t0, int64(2)}}
+	})
+	hook := NewHook(db, nil)
+	hook.Table = table
+	entries, err := hook.Query(context.Background(), QueryOptions{After: &Cursor{Time: t0, ID: 7, Shard: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Cursor{Time: t0, ID: 7, Shard: 2}); len(entries) != 1 || entries[0].Cursor() != expected {
+		t.Errorf("Expected the cursor of the entry to be %v, got %v\n", expected, entries)
+	}
+	expectedQuery = strings.TrimSuffix(table.SelectQuery(""), ";") + " WHERE (created_at, id, pglogrus_shard) > ($1, $2, $3) ORDER BY created_at ASC, id ASC, pglogrus_shard ASC LIMIT 100;"
+	if expected := []string{expectedQuery}; !reflect.DeepEqual(expected, recorded.statements) {
+		t.Errorf("Expected statements to be %q, got %q\n", expected, recorded.statements)
+	}
+
+	batch := []*logrus.Entry{
+		{Data: logrus.Fields{"tenant": "a"}},
+		{Data: logrus.Fields{"tenant": "b"}},
+		{Data: logrus.Fields{"tenant": "c"}},
+		{Data: logrus.Fields{"tenant": "a"}},
+	}
+	grouped := table.groupByShard(batch)
+	for i := 1; i < len(grouped); i++ {
+		if table.shard(grouped[i-1]) > table.shard(grouped[i]) {
+			t.Errorf("Expected batch to be grouped by shard, got %v\n", grouped)
+		}
+	}
+}
+
+func TestControlFields(t *testing.T) {
+	hook := NewHook(nil, nil)
+	hook.ControlTables = []string{"audit.logs"}
+	var errs []error
+	hook.ErrorHandler = func(event *ErrorEvent) {
+		errs = append(errs, event.Err)
+	}
+
+	tests := map[string]struct {
+		table    interface{}
+		expected string
+		errors   int
+	}{
+		"override": {table: "audit.logs", expected: "INSERT INTO audit.logs("},
+		"invalid":  {table: "logs; DROP TABLE logs", expected: "INSERT INTO logs(", errors: 1},
+		"unlisted": {table: "accounts", expected: "INSERT INTO logs(", errors: 1},
+		"default":  {expected: "INSERT INTO logs("},
+	}
+	for name, test := range tests {
+		errs = nil
+		data := logrus.Fields{"user": "alice", SyncControlField: true}
+		if test.table != nil {
+			data[TableControlField] = test.table
+		}
+		entry := hook.newEntry(&logrus.Entry{Data: data})
+		if _, ok := entry.Data[TableControlField]; ok || len(entry.Data) != 1 {
+			t.Errorf("%s: Expected control fields to be removed, got %v\n", name, entry.Data)
+		}
+		if !entryControls(entry).sync {
+			t.Errorf("%s: Expected entry to be written synchronously\n", name)
+		}
+		query, _, err := hook.table().insertStatement(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(query, test.expected) {
+			t.Errorf("%s: Expected query to start with %q, got %q\n", name, test.expected, query)
+		}
+		if len(errs) != test.errors {
+			t.Errorf("%s: Expected %d errors, got %v\n", name, test.errors, errs)
+		}
+	}
+}
+
+func TestCBOREncoding(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "f6"},
+		{-1, "20"},
+		{1000, "1903e8"},
+		{1.5, "fb3ff8000000000000"},
+		{"IETF", "6449455446"},
+		{logrus.Fields{"bb": true, "a": []int{2, 3}}, "a26161820203626262f5"},
+	}
+	for _, test := range tests {
+		b, err := MarshalCBOR(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := hex.EncodeToString(b); actual != test.expected {
+			t.Errorf("Expected CBOR of %v to be %s, got %s\n", test.value, test.expected, actual)
+		}
+	}
+
+	table := TableConfig{Name: "logs", Encoding: MarshalCBOR}
+	if schema := table.Schema()[0]; !strings.Contains(schema, "message_data bytea NOT NULL") {
+		t.Errorf("Expected message_data to be bytea, got %s\n", schema)
+	}
+	_, args, err := table.insertStatement(&logrus.Entry{Data: logrus.Fields{"a": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0xa1, 0x61, 'a', 0x01}; !bytes.Equal(expected, args[2].([]byte)) {
+		t.Errorf("Expected message_data to be %x, got %x\n", expected, args[2])
+	}
+}
+
+func TestWideTable(t *testing.T) {
+	table := TableConfig{
+		Name:    "logs",
+		Wide:    true,
+		Columns: []Column{{Name: "user_id", Field: "user_id", Type: "bigint"}},
+	}
+	expectedSchema := "CREATE TABLE IF NOT EXISTS logs (\n    id SERIAL,\n    level smallint NOT NULL,\n    message text NOT NULL,\n    created_at timestamp with time zone NOT NULL,\n    user_id bigint\n);"
+	if schema := table.Schema()[0]; schema != expectedSchema {
+		t.Errorf("Expected schema to be %s, got %s\n", expectedSchema, schema)
+	}
+
+	entry := &logrus.Entry{Message: "hi", Data: logrus.Fields{"user_id": 12, "path": "/a b", "ok": true}}
+	if _, _, err := table.insertStatement(entry); err == nil {
+		t.Errorf("Expected entry with unknown fields to be rejected\n")
+	}
+
+	table.UnknownColumn = "unknown_fields"
+	query, args, err := table.insertStatement(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedQuery := "INSERT INTO logs(level, message, created_at, user_id, unknown_fields) VALUES ($1,$2,$3,$4,$5);"
+	if query != expectedQuery {
+		t.Errorf("Expected query to be %s, got %s\n", expectedQuery, query)
+	}
+	if expected := `ok=true path="/a b"`; args[4] != expected {
+		t.Errorf("Expected unknown fields to be %s, got %v\n", expected, args[4])
+	}
+}
+
+func TestAutoColumns(t *testing.T) {
+	var buf bytes.Buffer
+	hook := NewHook(nil, map[string]interface{}{})
+	hook.DryRun = &buf
+	hook.AutoColumns("user_id", "tags")
+
+	for i := 0; i < 2; i++ {
+		err := hook.Fire(&logrus.Entry{
+			Level:   logrus.InfoLevel,
+			Message: "hi",
+			Data:    logrus.Fields{"user_id": 12, "tags": []string{"a"}, "other": "x"},
+			Time:    time.Date(2019, 3, 18, 10, 0, 0, 0, time.UTC),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert := `INSERT INTO logs(level, message, message_data, created_at, user_id) VALUES (4,'hi','{"other":"x","tags":["a"]}','2019-03-18T10:00:00Z',12);` + "\n"
+	expected := "ALTER TABLE logs ADD COLUMN IF NOT EXISTS user_id bigint;\n" + insert + insert
+	if buf.String() != expected {
+		t.Errorf("Expected dry run output to be %q, got %q\n", expected, buf.String())
+	}
+}
+
+// tableWriter reads the hook table while the dry run output is written.
+type tableWriter struct {
+	hook    *Hook
+	columns []int
+}
+
+func (w *tableWriter) Write(p []byte) (int, error) {
+	w.columns = append(w.columns, len(w.hook.table().Columns))
+	return len(p), nil
+}
+
+func TestAutoColumnsUnlocked(t *testing.T) {
+	hook := NewHook(nil, map[string]interface{}{})
+	w := &tableWriter{hook: hook}
+	hook.DryRun = w
+	hook.AutoColumns("user_id")
+
+	done := make(chan error)
+	go func() {
+		done <- hook.Fire(&logrus.Entry{Level: logrus.InfoLevel, Message: "hi", Data: logrus.Fields{"user_id": 12}})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the column to be added without holding the hook mutex")
+	}
+	if expected := []int{0, 1}; !reflect.DeepEqual(expected, w.columns) {
+		t.Errorf("Expected columns while writing to be %v, got %v\n", expected, w.columns)
+	}
+}
+
+func TestHelpers(t *testing.T) {
+	table := TableConfig{Name: "logs", Helpers: true, Columns: []Column{{Name: "app", Field: "app"}}, ServiceField: "app"}
+	expected := []string{
+		"CREATE OR REPLACE VIEW logs_errors_last_24h AS SELECT * FROM logs WHERE level <= 2 AND created_at > now() - interval '24 hours';",
+		"CREATE OR REPLACE VIEW logs_by_service AS SELECT app AS service, level, count(*) AS entries, max(created_at) AS last_entry_at FROM logs GROUP BY 1, 2;",
+		"CREATE OR REPLACE FUNCTION logs_search(query text) RETURNS SETOF logs AS $$ SELECT * FROM logs WHERE message ILIKE '%' || query || '%' OR message_data::text ILIKE '%' || query || '%' ORDER BY created_at DESC $$ LANGUAGE sql STABLE;",
+	}
+	if helpers := table.helpers(); !reflect.DeepEqual(expected, helpers) {
+		t.Errorf("Expected helpers to be %q, got %q\n", expected, helpers)
+	}
+
+	table = TableConfig{Name: "logs", Shards: 2}
+	expectedService := "message_data->>'service'"
+	if expr := table.fieldExpression("service"); expr != expectedService {
+		t.Errorf("Expected service expression to be %s, got %s\n", expectedService, expr)
+	}
+	expectedSearch := "CREATE OR REPLACE FUNCTION logs_search(query text) RETURNS SETOF logs_0 AS $$ SELECT * FROM (SELECT * FROM logs_0 UNION ALL SELECT * FROM logs_1) AS logs WHERE"
+	if search := table.helpers()[2]; !strings.HasPrefix(search, expectedSearch) {
+		t.Errorf("Expected search function to start with %s, got %s\n", expectedSearch, search)
+	}
+}
+
+func TestGeoColumns(t *testing.T) {
+	table := TableConfig{
+		Name: "logs",
+		Columns: []Column{
+			PointColumn("location", "geography", 4326, "lat", "lon"),
+			GeoJSONColumn("area", "geometry", 3857, "area"),
+		},
+	}
+
+	tests := map[string]struct {
+		data     logrus.Fields
+		args     []interface{}
+		jsonData string
+	}{
+		"valid": {
+			data: logrus.Fields{
+				"lat":  48.8566,
+				"lon":  2,
+				"area": map[string]interface{}{"type": "Polygon", "coordinates": [][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}},
+			},
+			args:     []interface{}{"SRID=4326;POINT(2 48.8566)", "SRID=3857;POLYGON((0 0,1 0,1 1,0 0))"},
+			jsonData: `{}`,
+		},
+		"feature": {
+			data:     logrus.Fields{"area": `{"type":"Feature","geometry":{"type":"Point","coordinates":[1.5,2]}}`},
+			args:     []interface{}{nil, "SRID=3857;POINT(1.5 2)"},
+			jsonData: `{}`,
+		},
+		"invalid": {
+			data:     logrus.Fields{"lat": "north", "lon": 2, "area": `{"type":"Circle","coordinates":[0,0]}`},
+			args:     []interface{}{nil, nil},
+			jsonData: `{"area":"{\"type\":\"Circle\",\"coordinates\":[0,0]}","lat":"north","lon":2}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, args, err := table.insertStatement(&logrus.Entry{Data: test.data})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jsonData := string(args[2].([]byte)); jsonData != test.jsonData {
+				t.Errorf("Expected message_data to be %s, got %s\n", test.jsonData, jsonData)
+			}
+			if !reflect.DeepEqual(args[4:], test.args) {
+				t.Errorf("Expected column values to be %v, got %v\n", test.args, args[4:])
+			}
+		})
+	}
+
+	expected := "CREATE INDEX IF NOT EXISTS logs_location_idx ON logs USING gist (location);"
+	if schema := table.Schema(); schema[1] != expected {
+		t.Errorf("Expected index to be %q, got %q\n", expected, schema[1])
+	}
+}
+
+func TestPayloadTable(t *testing.T) {
+	table := TableConfig{
+		Name:             "logs",
+		Columns:          []Column{{Name: "user_id", Field: "user"}},
+		PayloadTable:     "log_payloads",
+		PayloadThreshold: 20,
+	}
+
+	query, _, err := table.insertStatement(&logrus.Entry{Data: logrus.Fields{"user": "123", "a": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "INSERT INTO logs(level, message, message_data, created_at, user_id) VALUES ($1,$2,$3,$4,$5);"
+	if query != expected {
+		t.Errorf("Expected query to be %q, got %q\n", expected, query)
+	}
+
+	query, args, err := table.insertStatement(&logrus.Entry{Data: logrus.Fields{"user": "123", "body": strings.Repeat("a", 20)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "WITH payload AS (INSERT INTO log_payloads(message_data, created_at) VALUES ($3,$4) RETURNING id) INSERT INTO logs(level, message, message_data, created_at, user_id, payload_id) SELECT $1,$2,'{}',$4,$5,payload.id FROM payload;"
+	if query != expected {
+		t.Errorf("Expected query to be %q, got %q\n", expected, query)
+	}
+	if expectedArgs := []interface{}{[]byte(`{"body":"aaaaaaaaaaaaaaaaaaaa"}`), "123"}; !reflect.DeepEqual(expectedArgs, []interface{}{args[2], args[4]}) {
+		t.Errorf("Expected args to be %q, got %q\n", expectedArgs, args)
+	}
+
+	expected = "SELECT id, level, message, message_data, created_at, user_id FROM (SELECT id, level, message, COALESCE((SELECT p.message_data FROM log_payloads p WHERE p.id = payload_id), message_data) AS message_data, created_at, user_id FROM logs) AS logs;"
+	if query := table.SelectQuery(""); query != expected {
+		t.Errorf("Expected select query to be %q, got %q\n", expected, query)
+	}
+	if schema := table.Schema(); !strings.Contains(schema[0], "payload_id bigint") {
+		t.Errorf("Expected schema to have a payload_id column, got %q\n", schema[0])
+	}
+}
+
+func TestStoredEntry(t *testing.T) {
+	var e StoredEntry
+	for _, src := range []interface{}{int64(4), []byte("4"), "info"} {
+		if err := e.Level.Scan(src); err != nil || e.Level != StoredLevel(logrus.InfoLevel) {
+			t.Errorf("Expected %v to be scanned as info, got %v (%v)\n", src, e.Level, err)
+		}
+	}
+	if err := e.Data.Scan([]byte(`{"user":"123"}`)); err != nil || e.Data["user"] != "123" {
+		t.Errorf("Expected data to be scanned, got %v (%v)\n", e.Data, err)
+	}
+	expected := time.Date(2019, 3, 18, 10, 0, 0, 500, time.UTC)
+	for _, src := range []interface{}{expected, "2019-03-18T10:00:00.0000005Z", []byte("2019-03-18 10:00:00.0000005+00:00")} {
+		if err := e.CreatedAt.Scan(src); err != nil || !e.CreatedAt.Time().Equal(expected) {
+			t.Errorf("Expected %v to be scanned as %v, got %v (%v)\n", src, expected, e.CreatedAt.Time(), err)
+		}
+	}
+
+	e.table = &TableConfig{Columns: []Column{{Name: "user_id", Field: "user"}, LabelsColumn("labels", "app")}}
+	e.Columns = map[string]interface{}{"user_id": "12", "labels": `{"app":"api"}`}
+	entry, err := e.Entry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedData := logrus.Fields{"user": "12", "app": "api"}
+	if !reflect.DeepEqual(expectedData, entry.Data) || entry.Level != logrus.InfoLevel || !entry.Time.Equal(expected) {
+		t.Errorf("Expected entry to have data %v, got %v\n", expectedData, entry.Data)
+	}
+}
+
+func TestAggregateQuery(t *testing.T) {
+	table := TableConfig{Name: "logs", Columns: []Column{{Name: "app", Field: "app"}, LabelsColumn("labels", "env")}}
+	from := time.Date(2019, 3, 18, 10, 0, 0, 0, time.UTC)
+	query, args := table.aggregateQuery(
+		GroupBy{Level: true, TimeBucket: 5 * time.Minute, Fields: []string{"app", "env", "user.id"}},
+		AggregateOptions{From: from, Value: "duration_ms", Percentiles: []float64{0.5, 0.99}},
+	)
+	expected := "SELECT level, to_timestamp(floor(extract(epoch FROM created_at) / 300) * 300), app, labels->>'env', COALESCE(message_data->>'user.id', message_data#>>'{user,id}'), count(*), " +
+		"percentile_cont(0.5) WITHIN GROUP (ORDER BY " + numericExpression("message_data->>'duration_ms'") + "), percentile_cont(0.99) WITHIN GROUP (ORDER BY " + numericExpression("message_data->>'duration_ms'") + ") " +
+		"FROM logs WHERE created_at >= $1 GROUP BY 1, 2, 3, 4, 5 ORDER BY 1, 2, 3, 4, 5;"
+	if query != expected {
+		t.Errorf("Expected query to be %q, got %q\n", expected, query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{from}) {
+		t.Errorf("Expected args to be %v, got %v\n", []interface{}{from}, args)
+	}
+
+	// Values stored in columns are numbers
+	table.Columns = append(table.Columns, Column{Name: "duration", Field: "duration_ms", Type: "bigint"})
+	query, _ = table.aggregateQuery(GroupBy{}, AggregateOptions{Value: "duration_ms", Percentiles: []float64{0.5}})
+	expected = "SELECT count(*), percentile_cont(0.5) WITHIN GROUP (ORDER BY (duration)::double precision) FROM logs;"
+	if query != expected {
+		t.Errorf("Expected query to be %q, got %q\n", expected, query)
+	}
+
+	table.Columns = table.Columns[:2]
+	table.PayloadTable = "log_payloads"
+	query, _ = table.aggregateQuery(GroupBy{}, AggregateOptions{})
+	expected = "SELECT count(*) FROM (SELECT id, level, message, COALESCE((SELECT p.message_data FROM log_payloads p WHERE p.id = payload_id), message_data) AS message_data, created_at, app, labels FROM logs) AS logs;"
+	if query != expected {
+		t.Errorf("Expected query to be %q, got %q\n", expected, query)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	table := TableConfig{Name: "logs", Columns: []Column{{Name: "user_id", Field: "user", Type: "bigint"}}}
+	c := conditions{dialect: table.Dialect}
+	c.filter(&table, []Filter{
+		Field("user").Eq(12),
+		Field("request.path").Eq("/a"),
+		Field("latency_ms").Gt(500),
+		Or(Field("code").In(500, 503), Field("panic").Exists()),
+		{},
+	})
+	expected := "WHERE user_id = $1 AND COALESCE(message_data->>'request.path', message_data#>>'{request,path}') = $2 AND CASE WHEN (message_data->>'latency_ms') ~ '" + numberPattern + "' THEN (message_data->>'latency_ms')::double precision END > $3 AND ((message_data->>'code' IN ($4, $5)) OR (message_data->>'panic' IS NOT NULL))"
+	if where := c.where(); where != expected {
+		t.Errorf("Expected filters to be %q, got %q\n", expected, where)
+	}
+	expectedArgs := []interface{}{12, "/a", 500.0, "500", "503"}
+	if !reflect.DeepEqual(expectedArgs, c.args) {
+		t.Errorf("Expected args to be %v, got %v\n", expectedArgs, c.args)
+	}
+}
+
+func TestNumberPattern(t *testing.T) {
+	pattern := regexp.MustCompile(numberPattern)
+	for text, expected := range map[string]bool{
+		"12": true, "-1.5e3": true, "+.5": true, "3.": true, "1E-2": true,
+		"slow": false, "1-2": false, "": false, "e": false, ".": false, "true": false,
+	} {
+		if pattern.MatchString(text) != expected {
+			t.Errorf("Expected %q to be a number: %v\n", text, expected)
+		}
+	}
+}
+
+func TestPartitionUpper(t *testing.T) {
+	tests := map[string]time.Time{
+		"FOR VALUES FROM ('2024-01-01 00:00:00+00') TO ('2024-02-01 00:00:00+00')": time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		"FOR VALUES FROM ('2024-01-01') TO ('2024-01-02')":                         time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		"FOR VALUES FROM (MINVALUE) TO (MAXVALUE)":                                 {},
+		"FOR VALUES IN ('a')": {},
+	}
+	for bound, expected := range tests {
+		upper, ok := partitionUpper(bound)
+		if ok != !expected.IsZero() || !upper.Equal(expected) {
+			t.Errorf("Expected upper bound of %q to be %v, got %v\n", bound, expected, upper)
+		}
+	}
+}
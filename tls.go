@@ -0,0 +1,108 @@
+package pglogrus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// TLSConfig configures the TLS connections of hooks created from a DSN (see
+// WithTLS).
+type TLSConfig struct {
+	// Mode is the sslmode of the connections: "disable", "allow", "prefer",
+	// "require", "verify-ca" or "verify-full".
+	Mode string
+	// RootCA is the PEM file of the certificate authorities used to verify
+	// the server certificate.
+	RootCA string
+	// Cert and Key are the PEM files of the client certificate and its key,
+	// if the server requires one.
+	Cert, Key string
+}
+
+var sslModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// validate checks the mode and the files of c.
+func (c TLSConfig) validate() error {
+	if !sslModes[c.Mode] {
+		return fmt.Errorf("pglogrus: invalid TLS mode %q", c.Mode)
+	}
+	if (c.Mode == "verify-ca" || c.Mode == "verify-full") && c.RootCA == "" {
+		return fmt.Errorf("pglogrus: TLS mode %s requires a RootCA", c.Mode)
+	}
+	if c.RootCA != "" {
+		pem, err := ioutil.ReadFile(c.RootCA)
+		if err != nil {
+			return fmt.Errorf("pglogrus: can't read TLS root CA: %v", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return fmt.Errorf("pglogrus: no certificate found in TLS root CA %s", c.RootCA)
+		}
+	}
+	if (c.Cert == "") != (c.Key == "") {
+		return fmt.Errorf("pglogrus: TLS client certificate and key must be set together")
+	}
+	if c.Cert != "" {
+		if _, err := tls.LoadX509KeyPair(c.Cert, c.Key); err != nil {
+			return fmt.Errorf("pglogrus: invalid TLS client certificate: %v", err)
+		}
+	}
+	return nil
+}
+
+// settings returns the connection settings of c.
+func (c TLSConfig) settings() [][2]string {
+	settings := [][2]string{{"sslmode", c.Mode}}
+	if c.RootCA != "" {
+		settings = append(settings, [2]string{"sslrootcert", c.RootCA})
+	}
+	if c.Cert != "" {
+		settings = append(settings, [2]string{"sslcert", c.Cert}, [2]string{"sslkey", c.Key})
+	}
+	return settings
+}
+
+// WithTLS configures the TLS connections of NewHookDSN and NewAsyncHookDSN,
+// overriding the ssl settings of the DSN.
+// The files are checked when the hook is created.
+func WithTLS(config TLSConfig) Option {
+	return func(o *options) error {
+		if err := config.validate(); err != nil {
+			return err
+		}
+		o.tls = &config
+		return nil
+	}
+}
+
+// withSettings returns dsn with settings, overriding its own.
+// dsn is either an URL (postgres://...) or a list of key=value settings.
+func withSettings(dsn string, settings [][2]string) (string, error) {
+	if isURL(dsn) {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		for _, s := range settings {
+			q.Set(s[0], s[1])
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+	// Later settings override earlier ones
+	for _, s := range settings {
+		dsn += " " + s[0] + "=" + quoteSetting(s[1])
+	}
+	return strings.TrimSpace(dsn), nil
+}
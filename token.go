@@ -0,0 +1,44 @@
+package pglogrus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A TokenProvider returns an authentication token used as password, like the
+// tokens of AWS RDS IAM authentication, which expire after 15 minutes.
+type TokenProvider func(ctx context.Context) (string, error)
+
+// WithTokenProvider makes NewHookDSN and NewAsyncHookDSN connect with a
+// token from provider as password, minted for each new connection.
+// The user is the one of the DSN. See CacheToken to reuse tokens.
+func WithTokenProvider(provider TokenProvider) Option {
+	return WithCredentials(func(ctx context.Context) (Credentials, error) {
+		token, err := provider(ctx)
+		return Credentials{Password: token}, err
+	})
+}
+
+// CacheToken returns a provider reusing the tokens of provider for ttl,
+// which must be shorter than their lifetime.
+func CacheToken(provider TokenProvider, ttl time.Duration) TokenProvider {
+	var (
+		mu      sync.Mutex
+		token   string
+		expires time.Time
+	)
+	return func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if token != "" && time.Now().Before(expires) {
+			return token, nil
+		}
+		t, err := provider(ctx)
+		if err != nil {
+			return "", err
+		}
+		token, expires = t, time.Now().Add(ttl)
+		return token, nil
+	}
+}
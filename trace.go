@@ -0,0 +1,24 @@
+package pglogrus
+
+// TraceInfo describes a traced operation of the AsyncHook, when it ends.
+type TraceInfo struct {
+	// Entries is the number of entries of the operation.
+	Entries int
+	// Failed is the number of entries which couldn't be written.
+	Failed int
+	// Bytes is the estimated size of the entries, when MaxBatchBytes is set.
+	Bytes int
+	// Err is the error of the operation, if any.
+	Err error
+}
+
+// trace starts tracing op, and returns the func ending it.
+func (hook *AsyncHook) trace(op string) func(TraceInfo) {
+	if hook.Trace == nil {
+		return func(TraceInfo) {}
+	}
+	if end := hook.Trace(op); end != nil {
+		return end
+	}
+	return func(TraceInfo) {}
+}
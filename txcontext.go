@@ -0,0 +1,78 @@
+package pglogrus
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type txKey struct{}
+
+// WithTx returns a context making the hooks write the entries logged with it
+// (with logrus.WithContext) within tx, synchronously, so they're committed or
+// rolled back with the changes of the application:
+//
+//	tx, err := db.BeginTx(ctx, nil)
+//	ctx = pglogrus.WithTx(ctx, tx)
+//	log.WithContext(ctx).Info("Account closed")
+//	tx.Commit()
+//
+// The default insert of the hook is always used, even if InsertFunc is set.
+// With an OutboxTable, the entries are inserted in the outbox instead.
+// As the hook doesn't know whether the transaction is committed, the entries
+// aren't passed to secondary sinks, and don't update LastCommitted.
+// The statements of the hook run in savepoints of tx: if an entry can't be
+// inserted, Fire returns the error, and tx can still be committed.
+func WithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// entryTx returns the transaction of the context of entry, if any.
+func entryTx(entry *logrus.Entry) *sql.Tx {
+	if entry.Context == nil {
+		return nil
+	}
+	tx, _ := entry.Context.Value(txKey{}).(*sql.Tx)
+	return tx
+}
+
+// writeInTx inserts entry within a savepoint of tx, so a failure doesn't
+// abort the transaction of the application.
+func (hook *Hook) writeInTx(tx *sql.Tx, entry *logrus.Entry) error {
+	if hook.Disabled {
+		return nil
+	}
+	ctx := entry.Context
+	batch := &sqlBatch{tx: tx}
+	insert := hook.wrapInsert(func(entry *logrus.Entry) error {
+		return withSavepoint(ctx, batch, "pglogrus_entry", func() error {
+			if hook.OutboxTable != "" {
+				return hook.insertOutbox(tx, entry)
+			}
+			return hook.insert(tx, entry)
+		})
+	})
+	err := newDBError(insert(entry))
+	if err != nil {
+		atomic.AddUint64(&hook.stats.dropped, 1)
+		hook.handleError(&ErrorEvent{Time: time.Now(), Op: "insert", Err: err, Entry: entry})
+		return err
+	}
+	if hook.OutboxTable != "" {
+		// Written by RelayOutbox
+		return nil
+	}
+	if query, args, ok := hook.annotationStatement(entry); ok {
+		err := withSavepoint(ctx, batch, "pglogrus_annotation", func() error {
+			return batch.Insert(ctx, query, args...)
+		})
+		if err != nil {
+			hook.handleError(&ErrorEvent{Time: time.Now(), Op: "annotation", Err: err, Entry: entry})
+		}
+	}
+	atomic.AddUint64(&hook.stats.written, 1)
+	return nil
+}
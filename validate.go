@@ -0,0 +1,200 @@
+package pglogrus
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SchemaError is returned by ValidateSchema when the table doesn't match the
+// hook config.
+type SchemaError struct {
+	Table string
+	// Mismatches describe each difference, like "column request_id is
+	// missing".
+	Mismatches []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("pglogrus: table %s doesn't match the hook config: %s", e.Table, strings.Join(e.Mismatches, "; "))
+}
+
+// ValidateSchema checks that the hook table exists, with the columns of its
+// config and compatible types. It returns a *SchemaError listing the
+// differences otherwise, so they're reported when the application starts
+// rather than by the first insert.
+func (hook *Hook) ValidateSchema(ctx context.Context) error {
+	for _, table := range hook.Table.shardTables() {
+		if err := hook.validateTable(ctx, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTable checks the schema of a table (or shard).
+func (hook *Hook) validateTable(ctx context.Context, table *TableConfig) error {
+	var schema sql.NullString
+	name := table.Name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		schema = sql.NullString{String: name[:i], Valid: true}
+		name = name[i+1:]
+	}
+	rows, err := hook.db.QueryContext(ctx, "SELECT column_name, udt_name FROM information_schema.columns WHERE table_schema = COALESCE($1, current_schema()) AND table_name = $2;", schema, name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	actual := map[string]string{}
+	for rows.Next() {
+		var column, udt string
+		if err := rows.Scan(&column, &udt); err != nil {
+			return err
+		}
+		actual[column] = udt
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if mismatches := table.schemaMismatches(actual); len(mismatches) > 0 {
+		return &SchemaError{Table: table.Name, Mismatches: mismatches}
+	}
+	return nil
+}
+
+// schemaMismatches returns the differences between the table config and the
+// actual columns of the table, mapping column names to their type (the
+// udt_name of information_schema).
+func (t *TableConfig) schemaMismatches(actual map[string]string) []string {
+	if len(actual) == 0 {
+		return []string{"table doesn't exist"}
+	}
+	columns := []Column{
+		{Name: "level", Type: "smallint"},
+		{Name: "message", Type: "text"},
+		{Name: "message_data", Type: "json"},
+		{Name: "created_at", Type: "timestamp with time zone"},
+	}
+	switch {
+	case t.Wide:
+		columns = append(columns[:2], columns[3:]...)
+		if t.UnknownColumn != "" {
+			columns = append(columns, Column{Name: t.UnknownColumn})
+		}
+	case t.Encoding != nil:
+		columns[2].Type = "bytea"
+	}
+	columns = append(columns, t.Columns...)
+	if t.offloadsPayloads() {
+		columns = append(columns, Column{Name: PayloadIDColumn, Type: "bigint"})
+	}
+
+	var mismatches []string
+	for _, c := range columns {
+		typ, ok := actual[c.Name]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("column %s is missing", c.Name))
+			continue
+		}
+		expected := c.Type
+		if expected == "" {
+			expected = "text"
+		}
+		if !compatibleType(udtName(expected), typ) {
+			mismatches = append(mismatches, fmt.Sprintf("column %s is %s, expected %s", c.Name, typ, expected))
+		}
+	}
+	return mismatches
+}
+
+// udtNames maps SQL type names to their internal name, used by
+// information_schema.
+var udtNames = map[string]string{
+	"smallint":                 "int2",
+	"integer":                  "int4",
+	"int":                      "int4",
+	"bigint":                   "int8",
+	"real":                     "float4",
+	"double precision":         "float8",
+	"boolean":                  "bool",
+	"character varying":        "varchar",
+	"character":                "bpchar",
+	"char":                     "bpchar",
+	"timestamp with time zone": "timestamptz",
+	"timestamp":                "timestamp",
+	"decimal":                  "numeric",
+}
+
+// udtName returns the internal name of the SQL type typ (eg. "int4" for
+// "integer"). Arrays are prefixed with an underscore.
+func udtName(typ string) string {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	array := strings.HasSuffix(typ, "[]")
+	typ = strings.TrimSuffix(typ, "[]")
+	// Remove modifiers, like varchar(255)
+	if i := strings.IndexByte(typ, '('); i >= 0 {
+		typ = strings.TrimSpace(typ[:i])
+	}
+	if name, ok := udtNames[typ]; ok {
+		typ = name
+	}
+	if array {
+		typ = "_" + typ
+	}
+	return typ
+}
+
+// compatibleTypes are the types accepted for a column of the given type, when
+// values inserted by the hook fit in both.
+var compatibleTypes = map[string][]string{
+	"int2": {"int4", "int8"},
+	"json": {"jsonb"},
+	"text": {"varchar", "citext"},
+}
+
+// compatibleType reports whether a column of type actual can store values of
+// the expected type (both being internal names).
+func compatibleType(expected, actual string) bool {
+	if expected == actual {
+		return true
+	}
+	for _, typ := range compatibleTypes[expected] {
+		if typ == actual {
+			return true
+		}
+	}
+	return false
+}
+
+// columnIdentifier matches the column names accepted by ColumnStorage.
+var columnIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateColumnStorage returns an error if the ColumnStorage of the table
+// isn't valid, as its values are written in the statement setting it.
+func (t *TableConfig) validateColumnStorage() error {
+	names := make([]string, 0, len(t.ColumnStorage))
+	for name := range t.ColumnStorage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		storage := t.ColumnStorage[name]
+		if !columnIdentifier.MatchString(name) {
+			return fmt.Errorf("pglogrus: invalid ColumnStorage column %q", name)
+		}
+		switch strings.ToUpper(storage.Storage) {
+		case "", "PLAIN", "MAIN", "EXTERNAL", "EXTENDED":
+		default:
+			return fmt.Errorf("pglogrus: invalid storage %q of column %s", storage.Storage, name)
+		}
+		switch strings.ToLower(storage.Compression) {
+		case "", "pglz", "lz4":
+		default:
+			return fmt.Errorf("pglogrus: invalid compression %q of column %s", storage.Compression, name)
+		}
+	}
+	return nil
+}